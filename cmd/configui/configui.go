@@ -3,49 +3,116 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/config"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 	"github.com/gin-gonic/gin"
 )
 
 var configStrings = map[string]string{
 	"mbhost":   "activemq",
 	"mbport":   "61613",
+	"mbtype":   "stomp",
 	"httpport": "8082",
 }
 
+// busTimeout bounds how long a request waits for the services it talks to over the message bus
+const busTimeout = 10 * time.Second
+
+// telemetryTimeout bounds telemetry configuration requests, which make several round trips to the iDRAC
+const telemetryTimeout = 60 * time.Second
+
 type SystemHandler struct {
 	AuthClient *auth.AuthorizationClient
 	DataBus    *databus.DataBusClient
-	ConfigBus  *config.ConfigClient
+	// one config client per pump, so concurrent requests never share a command or response queue setting
+	SplunkConfig *config.ConfigClient
+	KafkaConfig  *config.ConfigClient
+	OtelConfig   *config.ConfigClient
+}
+
+// busContext bounds a request's message bus round trips by busTimeout
+func busContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request.Context(), busTimeout)
+}
+
+// busError answers a request whose message bus round trip failed, with 504 if the other side did not answer in time
+func busError(c *gin.Context, what string, err error) {
+	log.Printf("Failed to get %s: %v", what, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "timed out waiting for " + what})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
 func getSystemList(c *gin.Context, s *SystemHandler) {
-	producers := s.DataBus.GetProducers("/configui/databus_in")
+	ctx, cancel := busContext(c)
+	defer cancel()
+	producers, err := s.DataBus.GetProducers(ctx, "/configui/databus_in")
+	if err != nil {
+		busError(c, "producers", err)
+		return
+	}
 	c.JSON(200, producers)
 }
 
 type MySys struct {
-	Hostname string `json:"hostname"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Hostname     string `json:"hostname"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PollInterval string `json:"pollinterval,omitempty"`
+	EventMode    string `json:"eventmode,omitempty"`
+	// AuthType is "session" for session authentication, anything else for basic authentication
+	AuthType string `json:"authtype,omitempty"`
+	// CACert is the path, as redfishread sees it, of a PEM file with the CAs of the system's certificate.
+	// Fingerprint pins its SHA-256 fingerprint and Insecure set to "true" skips checking it.
+	CACert      string `json:"cacert,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Insecure    string `json:"insecure,omitempty"`
+	// ServiceType is "msm" for an MSM chassis, whose sleds are then reported on, anything else for an iDRAC
+	ServiceType string `json:"servicetype,omitempty"`
+}
+
+// setTLSOptions stores how the service's certificate is checked, leaving out what is not set
+func setTLSOptions(service *auth.Service, cacert string, fingerprint string, insecure string) {
+	for name, value := range map[string]string{
+		auth.AuthCACert:      cacert,
+		auth.AuthFingerprint: fingerprint,
+		auth.AuthInsecure:    insecure,
+	} {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			service.Auth[name] = value
+		}
+	}
 }
 
 type MyDelSys struct {
 	Hostname []string `json:"hostname"`
 }
 
+type MyTelemetry struct {
+	Hostname []string `json:"hostname"`
+	databus.TelemetryConfig
+}
+
 type MyHec struct {
 	Url   string `json:"url"`
 	Key   string `json:"key"`
@@ -61,6 +128,10 @@ type KfkConfig struct {
 	KafkaSkipVerify string `json:"kafkaSkipVerify"`
 	TLS             string `json:"tls"`
 	ClientAuth      string `json:"clientAuth"`
+	// SASL mechanism is one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. The password is write only.
+	KafkaSaslMechanism string `json:"kafkaSaslMechanism"`
+	KafkaSaslUsername  string `json:"kafkaSaslUsername"`
+	KafkaSaslPassword  string `json:"kafkaSaslPassword,omitempty"`
 }
 
 type OtelConfig struct {
@@ -75,23 +146,24 @@ type OtelConfig struct {
 
 func getKafkaBrokerConfig(c *gin.Context, s *SystemHandler) {
 	var KafkaConfig KfkConfig
-	s.ConfigBus.CommandQueue = "/kafkapump/config"
-	s.ConfigBus.ResponseQueue = "/kconfigui"
-	configValues, err := s.ConfigBus.Get("kafkaBroker")
+	ctx, cancel := busContext(c)
+	defer cancel()
+	configValues, err := s.KafkaConfig.Get(ctx, "kafkaBroker")
 	if err != nil {
-		log.Printf("Failed to get kafkaBroker values %v", err)
-	} else {
-		KafkaConfig.Broker = configValues.Value.(string)
+		// kafkapump is not answering, don't wait for the rest
+		busError(c, "kafkapump config", err)
+		return
 	}
+	KafkaConfig.Broker = configValues.Value.(string)
 
-	configValues, err = s.ConfigBus.Get("kafkaTopic")
+	configValues, err = s.KafkaConfig.Get(ctx, "kafkaTopic")
 	if err != nil {
 		log.Printf("Failed to get kafkaTopic values %v", err)
 	} else {
 		KafkaConfig.Topic = configValues.Value.(string)
 	}
 
-	configValues, err = s.ConfigBus.Get("kafkaCACert")
+	configValues, err = s.KafkaConfig.Get(ctx, "kafkaCACert")
 	if err != nil {
 		log.Printf("Failed to get kafkaTopic values %v", err)
 	} else {
@@ -101,14 +173,14 @@ func getKafkaBrokerConfig(c *gin.Context, s *SystemHandler) {
 		}
 	}
 
-	configValues, err = s.ConfigBus.Get("kafkaSkipVerify")
+	configValues, err = s.KafkaConfig.Get(ctx, "kafkaSkipVerify")
 	if err != nil {
 		log.Printf("Failed to get kafkaClientCert values %v", err)
 	} else {
 		KafkaConfig.KafkaSkipVerify = configValues.Value.(string)
 	}
 
-	configValues, err = s.ConfigBus.Get("kafkaClientCert")
+	configValues, err = s.KafkaConfig.Get(ctx, "kafkaClientCert")
 	if err != nil {
 		log.Printf("Failed to get kafkaClientCert values %v", err)
 	} else {
@@ -118,29 +190,45 @@ func getKafkaBrokerConfig(c *gin.Context, s *SystemHandler) {
 		}
 	}
 
+	configValues, err = s.KafkaConfig.Get(ctx, "kafkaSaslMechanism")
+	if err != nil {
+		log.Printf("Failed to get kafkaSaslMechanism values %v", err)
+	} else {
+		KafkaConfig.KafkaSaslMechanism = configValues.Value.(string)
+	}
+
+	configValues, err = s.KafkaConfig.Get(ctx, "kafkaSaslUsername")
+	if err != nil {
+		log.Printf("Failed to get kafkaSaslUsername values %v", err)
+	} else {
+		KafkaConfig.KafkaSaslUsername = configValues.Value.(string)
+	}
+
 	c.JSON(200, KafkaConfig)
 }
 
 func getSplunkHttpConfig(c *gin.Context, s *SystemHandler) {
 	var SplunkConfig MyHec
-	s.ConfigBus.CommandQueue = "/splunkpump/config"
-	s.ConfigBus.ResponseQueue = "/configui"
-	configValues, err := s.ConfigBus.Get("splunkURL")
+	ctx, cancel := busContext(c)
+	defer cancel()
+	configValues, err := s.SplunkConfig.Get(ctx, "splunkURL")
 	if err != nil {
-		log.Printf("Failed to get any config url values %v", err)
+		busError(c, "splunkpump config", err)
+		return
 	}
-	SplunkConfig.Url = configValues.Value.(string)
-	configValues, err = s.ConfigBus.Get("splunkKey")
+	SplunkConfig.Url, _ = configValues.Value.(string)
+	configValues, err = s.SplunkConfig.Get(ctx, "splunkKey")
 	if err != nil {
-		log.Printf("Failed to get any config key values %v", err)
+		busError(c, "splunkpump config", err)
+		return
 	}
-	SplunkConfig.Key = configValues.Value.(string)
-	configValues, err = s.ConfigBus.Get("splunkIndex")
-
+	SplunkConfig.Key, _ = configValues.Value.(string)
+	configValues, err = s.SplunkConfig.Get(ctx, "splunkIndex")
 	if err != nil {
-		log.Printf("Failed to get any Index values %v", err)
+		busError(c, "splunkpump config", err)
+		return
 	}
-	SplunkConfig.Index = configValues.Value.(string)
+	SplunkConfig.Index, _ = configValues.Value.(string)
 	c.JSON(200, SplunkConfig)
 }
 
@@ -151,25 +239,25 @@ func kafkaConfig(c *gin.Context, s *SystemHandler) {
 		log.Println("Failed to parse json: ", err)
 		_ = c.AbortWithError(500, err)
 	}
-	s.ConfigBus.CommandQueue = "/kafkapump/config"
-	s.ConfigBus.ResponseQueue = "/kconfigui"
+	ctx, cancel := busContext(c)
+	defer cancel()
 
 	if tmp.Broker != "" {
-		_, err = s.ConfigBus.Set("kafkaBroker", tmp.Broker)
+		_, err = s.KafkaConfig.Set(ctx, "kafkaBroker", tmp.Broker)
 		if err != nil {
 			log.Println("Failed to update kafkaBroker config: ", err)
 		}
 	}
 
 	if tmp.Topic != "" {
-		_, err = s.ConfigBus.Set("kafkaTopic", tmp.Topic)
+		_, err = s.KafkaConfig.Set(ctx, "kafkaTopic", tmp.Topic)
 		if err != nil {
 			log.Println("Failed to update kafkaTopic config: ", err)
 		}
 	}
 
 	if tmp.KafkaSkipVerify != "" {
-		_, err = s.ConfigBus.Set("kafkaSkipVerify", tmp.KafkaSkipVerify)
+		_, err = s.KafkaConfig.Set(ctx, "kafkaSkipVerify", tmp.KafkaSkipVerify)
 		if err != nil {
 			log.Println("Failed to update kafkaTopic config: ", err)
 		}
@@ -182,7 +270,7 @@ func kafkaConfig(c *gin.Context, s *SystemHandler) {
 		}
 
 		//log.Println(tmp.KafkaCACert.Filename)
-		_, err = s.ConfigBus.Set("kafkaCACert", "kafkaCACert")
+		_, err = s.KafkaConfig.Set(ctx, "kafkaCACert", "kafkaCACert")
 		if err != nil {
 			log.Println("Failed to update kafkaCACert config: ", err)
 		}
@@ -194,7 +282,7 @@ func kafkaConfig(c *gin.Context, s *SystemHandler) {
 			log.Println("Failed to save client cert: ", err)
 		}
 
-		_, err = s.ConfigBus.Set("kafkaClientCert", "kafkaClientCert")
+		_, err = s.KafkaConfig.Set(ctx, "kafkaClientCert", "kafkaClientCert")
 		if err != nil {
 			log.Println("Failed to update kafkaClientCert config: ", err)
 		}
@@ -205,12 +293,40 @@ func kafkaConfig(c *gin.Context, s *SystemHandler) {
 		if err != nil {
 			log.Println("Failed to save client key: ", err)
 		}
-		_, err = s.ConfigBus.Set("kafkaClientKey", "kafkaClientKey")
+		_, err = s.KafkaConfig.Set(ctx, "kafkaClientKey", "kafkaClientKey")
 		if err != nil {
 			log.Println("Failed to update kafkaClientCert config: ", err)
 		}
 	}
 
+	if tmp.KafkaSaslMechanism != "" {
+		switch strings.ToUpper(tmp.KafkaSaslMechanism) {
+		case messagebus.SASLPlain, messagebus.SASLScramSHA256, messagebus.SASLScramSHA512:
+		default:
+			log.Println("Unsupported kafka SASL mechanism: ", tmp.KafkaSaslMechanism)
+			c.JSON(400, gin.H{"error": "kafkaSaslMechanism must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512"})
+			return
+		}
+		_, err = s.KafkaConfig.Set(ctx, "kafkaSaslMechanism", strings.ToUpper(tmp.KafkaSaslMechanism))
+		if err != nil {
+			log.Println("Failed to update kafkaSaslMechanism config: ", err)
+		}
+	}
+
+	if tmp.KafkaSaslUsername != "" {
+		_, err = s.KafkaConfig.Set(ctx, "kafkaSaslUsername", tmp.KafkaSaslUsername)
+		if err != nil {
+			log.Println("Failed to update kafkaSaslUsername config: ", err)
+		}
+	}
+
+	if tmp.KafkaSaslPassword != "" {
+		_, err = s.KafkaConfig.Set(ctx, "kafkaSaslPassword", tmp.KafkaSaslPassword)
+		if err != nil {
+			log.Println("Failed to update kafkaSaslPassword config: ", err)
+		}
+	}
+
 }
 
 func otelConfig(c *gin.Context, s *SystemHandler) {
@@ -220,18 +336,18 @@ func otelConfig(c *gin.Context, s *SystemHandler) {
 		log.Println("Failed to parse json: ", err)
 		_ = c.AbortWithError(500, err)
 	}
-	s.ConfigBus.CommandQueue = "/otelpump/config"
-	s.ConfigBus.ResponseQueue = "/oconfigui"
+	ctx, cancel := busContext(c)
+	defer cancel()
 
 	if tmp.OtelCollector != "" {
-		_, err = s.ConfigBus.Set("otelCollector", tmp.OtelCollector)
+		_, err = s.OtelConfig.Set(ctx, "otelCollector", tmp.OtelCollector)
 		if err != nil {
 			log.Println("Failed to update otelCollector config: ", err)
 		}
 	}
 
 	if tmp.OtelSkipVerify != "" {
-		_, err = s.ConfigBus.Set("otelSkipVerify", tmp.OtelSkipVerify)
+		_, err = s.OtelConfig.Set(ctx, "otelSkipVerify", tmp.OtelSkipVerify)
 		if err != nil {
 			log.Println("Failed to update otelTopic config: ", err)
 		}
@@ -244,7 +360,7 @@ func otelConfig(c *gin.Context, s *SystemHandler) {
 		}
 
 		//log.Println(tmp.OtelCACert.Filename)
-		_, err = s.ConfigBus.Set("otelCACert", "otelCACert")
+		_, err = s.OtelConfig.Set(ctx, "otelCACert", "otelCACert")
 		if err != nil {
 			log.Println("Failed to update otelCACert config: ", err)
 		}
@@ -256,7 +372,7 @@ func otelConfig(c *gin.Context, s *SystemHandler) {
 			log.Println("Failed to save client cert: ", err)
 		}
 
-		_, err = s.ConfigBus.Set("otelClientCert", "otelClientCert")
+		_, err = s.OtelConfig.Set(ctx, "otelClientCert", "otelClientCert")
 		if err != nil {
 			log.Println("Failed to update otelClientCert config: ", err)
 		}
@@ -267,7 +383,7 @@ func otelConfig(c *gin.Context, s *SystemHandler) {
 		if err != nil {
 			log.Println("Failed to save client key: ", err)
 		}
-		_, err = s.ConfigBus.Set("otelClientKey", "otelClientKey")
+		_, err = s.OtelConfig.Set(ctx, "otelClientKey", "otelClientKey")
 		if err != nil {
 			log.Println("Failed to update otelClientCert config: ", err)
 		}
@@ -292,17 +408,17 @@ func configHEC(c *gin.Context, s *SystemHandler) {
 		_ = c.AbortWithError(500, err)
 	} else {
 		var hecconfig auth.SplunkConfig
-		s.ConfigBus.CommandQueue = "/splunkpump/config"
-		s.ConfigBus.ResponseQueue = "/configui"
-		_, err = s.ConfigBus.Set("splunkURL", tmp.Url)
+		ctx, cancel := busContext(c)
+		defer cancel()
+		_, err = s.SplunkConfig.Set(ctx, "splunkURL", tmp.Url)
 		if err != nil {
 			log.Printf("Failed to send config (splunkURL) %v", err)
 		}
-		_, err = s.ConfigBus.Set("splunkKey", tmp.Key)
+		_, err = s.SplunkConfig.Set(ctx, "splunkKey", tmp.Key)
 		if err != nil {
 			log.Printf("Failed to send config (splunkKey) %v", err)
 		}
-		_, err = s.ConfigBus.Set("splunkIndex", tmp.Index)
+		_, err = s.SplunkConfig.Set(ctx, "splunkIndex", tmp.Index)
 		if err != nil {
 			log.Printf("Failed to send config (splunkIndex) %v", err)
 		}
@@ -325,11 +441,24 @@ func addSystem(c *gin.Context, s *SystemHandler) {
 	} else {
 		var service auth.Service
 		service.ServiceType = auth.IDRAC
+		if strings.EqualFold(tmp.ServiceType, "msm") {
+			service.ServiceType = auth.MSM
+		}
 		service.Ip = tmp.Hostname
 		service.AuthType = auth.AuthTypeUsernamePassword
+		if tmp.AuthType == "session" {
+			service.AuthType = auth.AuthTypeXAuthToken
+		}
 		service.Auth = make(map[string]string)
 		service.Auth["username"] = tmp.Username
 		service.Auth["password"] = tmp.Password
+		if tmp.PollInterval != "" {
+			service.Auth[auth.AuthPollInterval] = tmp.PollInterval
+		}
+		if tmp.EventMode != "" {
+			service.Auth[auth.AuthEventMode] = tmp.EventMode
+		}
+		setTLSOptions(&service, tmp.CACert, tmp.Fingerprint, tmp.Insecure)
 		serviceerr := s.AuthClient.AddService(service)
 		if serviceerr != nil {
 			log.Println("Failed to add service parse json: ", serviceerr)
@@ -364,6 +493,46 @@ func deleteSystem(c *gin.Context, s *SystemHandler) {
 	}
 }
 
+func getTelemetry(c *gin.Context, s *SystemHandler) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), telemetryTimeout)
+	defer cancel()
+	state, err := s.DataBus.GetTelemetry(ctx, "/configui/databus_in", c.Query("hostname"))
+	if err != nil {
+		busError(c, "telemetry state", err)
+		return
+	}
+	c.JSON(200, state)
+}
+
+// setTelemetry applies the same telemetry configuration to every selected system, all at once
+func setTelemetry(c *gin.Context, s *SystemHandler) {
+	var tmp MyTelemetry
+	err := c.ShouldBind(&tmp)
+	if err != nil {
+		log.Println("Failed to parse json: ", err)
+		_ = c.AbortWithError(500, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), telemetryTimeout)
+	defer cancel()
+	states := make([]*databus.TelemetryState, len(tmp.Hostname))
+	var wg sync.WaitGroup
+	for i, hostname := range tmp.Hostname {
+		wg.Add(1)
+		go func(i int, hostname string) {
+			defer wg.Done()
+			state, err := s.DataBus.SetTelemetry(ctx, "/configui/databus_in", hostname, tmp.TelemetryConfig)
+			if err != nil {
+				log.Printf("Failed to configure telemetry of %s: %v", hostname, err)
+				state = &databus.TelemetryState{ServiceIP: hostname, Errors: []string{err.Error()}}
+			}
+			states[i] = state
+		}(i, hostname)
+	}
+	wg.Wait()
+	c.JSON(200, states)
+}
+
 func getEnvSettings() {
 	mbHost := os.Getenv("MESSAGEBUS_HOST")
 	if len(mbHost) > 0 {
@@ -373,6 +542,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 	httpPort := os.Getenv("CONFIGUI_HTTP_PORT")
 	if len(httpPort) > 0 {
 		configStrings["httpport"] = httpPort
@@ -396,7 +569,9 @@ func handleCsv(c *gin.Context, s *SystemHandler) {
 	}
 	defer csvFile.Close()
 
+	// ip, username, password and optionally cacert, fingerprint and insecure
 	reader := csv.NewReader(csvFile)
+	reader.FieldsPerRecord = -1
 	idracRecords, _ := reader.ReadAll()
 
 	for _, line := range idracRecords {
@@ -407,6 +582,11 @@ func handleCsv(c *gin.Context, s *SystemHandler) {
 		service.Auth = make(map[string]string)
 		service.Auth["username"] = line[1]
 		service.Auth["password"] = line[2]
+		tlsFields := make([]string, 3)
+		if len(line) > 3 {
+			copy(tlsFields, line[3:])
+		}
+		setTLSOptions(&service, tlsFields[0], tlsFields[1], tlsFields[2])
 		serviceerr := s.AuthClient.AddService(service)
 		if serviceerr != nil {
 			log.Println("Failed to add service parse json: ", serviceerr)
@@ -428,19 +608,20 @@ func main() {
 	systemHandler := new(SystemHandler)
 	systemHandler.AuthClient = new(auth.AuthorizationClient)
 	systemHandler.DataBus = new(databus.DataBusClient)
-	systemHandler.ConfigBus = new(config.ConfigClient)
 
 	//Initialize messagebus
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
 		} else {
 			systemHandler.AuthClient.Bus = mb
 			systemHandler.DataBus.Bus = mb
-			systemHandler.ConfigBus.Bus = mb
+			systemHandler.SplunkConfig = config.NewConfigClient(mb, "/splunkpump/config", "/configui")
+			systemHandler.KafkaConfig = config.NewConfigClient(mb, "/kafkapump/config", "/kconfigui")
+			systemHandler.OtelConfig = config.NewConfigClient(mb, "/otelpump/config", "/oconfigui")
 			defer mb.Close()
 			break
 		}
@@ -463,6 +644,7 @@ func main() {
 	router.StaticFile("/", "index.html")
 	router.StaticFile("/index.html", "index.html")
 	router.StaticFile("/index.bundle.js", "index.bundle.js")
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 	router.GET("/api/v1/Systems", func(c *gin.Context) {
 		getSystemList(c, systemHandler)
 	})
@@ -475,6 +657,12 @@ func main() {
 	router.POST("/api/v1/Delete", func(c *gin.Context) {
 		deleteSystem(c, systemHandler)
 	})
+	router.GET("/api/v1/Telemetry", func(c *gin.Context) {
+		getTelemetry(c, systemHandler)
+	})
+	router.POST("/api/v1/Telemetry", func(c *gin.Context) {
+		setTelemetry(c, systemHandler)
+	})
 	router.POST("/api/v1/HecConfig", func(c *gin.Context) {
 		configHEC(c, systemHandler)
 	})
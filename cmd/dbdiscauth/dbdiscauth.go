@@ -16,13 +16,15 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	//default settings
 	"mbhost":        "activemq",                    //to be provided by user
 	"mbport":        "61613",                       //to be provided by user
+	"mbtype":        "stomp",                       //to be provided by user
 	"mysqluser":     "",                            //to be provided by user
 	"mysqlpwd":      "",                            //to be provieed by user
 	"mysqlHost":     "localhost",                   //to be provided by user
@@ -129,6 +131,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 	username := os.Getenv("MYSQL_USER")
 	if len(username) > 0 {
 		configStrings["mysqluser"] = username
@@ -214,8 +220,8 @@ func main() {
 
 	//Initialize messagebus
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -279,9 +285,14 @@ func main() {
 			HecConfig, err := getHECInstancesFromDB(db)
 			if err != nil {
 				log.Print("Failed to get db entries: ", err)
-				break
 			}
-			log.Print(HecConfig)
+			if command.ReceiveQueue != "" {
+				resp := auth.Response{SplunkConfigs: HecConfig}
+				if err != nil {
+					resp.Error = err.Error()
+				}
+				_ = authorizationService.Reply(command, resp)
+			}
 		case auth.TERMINATE:
 			os.Exit(0)
 		}
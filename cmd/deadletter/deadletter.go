@@ -0,0 +1,186 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+// deadletter inspects, replays and purges the dead letter queue that services move unparsable bus messages to.
+//
+//	deadletter [flags] list     print every dead letter as a JSON line and leave the queue as it was
+//	deadletter [flags] replay   send the original body of each dead letter back to the queue it came from
+//	deadletter [flags] purge    drop dead letters from the queue
+//
+// -source limits replay and purge to letters from one queue, the rest are put back. replay -file replays letters
+// saved earlier with list, possibly after fixing them up by hand, instead of reading the queue.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
+)
+
+var configStrings = map[string]string{
+	"mbhost": "activemq",
+	"mbport": "61613",
+	"mbtype": "stomp",
+}
+
+// letter is one message read off the dead letter queue. Raw is kept so anything put back is byte for byte the same,
+// including messages that are not dead letters at all.
+type letter struct {
+	raw    string
+	letter *messagebus.DeadLetter
+}
+
+func getEnvSettings() {
+	mbHost := os.Getenv("MESSAGEBUS_HOST")
+	if len(mbHost) > 0 {
+		configStrings["mbhost"] = mbHost
+	}
+	mbPort := os.Getenv("MESSAGEBUS_PORT")
+	if len(mbPort) > 0 {
+		configStrings["mbport"] = mbPort
+	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
+}
+
+// drain reads the queue until it has been idle for wait
+func drain(mb messagebus.Messagebus, queue string, wait time.Duration) ([]letter, error) {
+	messages := make(chan string, 10)
+	sub, err := mb.ReceiveMessage(messages, queue)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	var letters []letter
+	for {
+		select {
+		case message := <-messages:
+			l := letter{raw: message}
+			dl := new(messagebus.DeadLetter)
+			if json.Unmarshal([]byte(message), dl) == nil && dl.Queue != "" {
+				l.letter = dl
+			}
+			letters = append(letters, l)
+		case <-time.After(wait):
+			return letters, nil
+		}
+	}
+}
+
+func putBack(mb messagebus.Messagebus, queue string, letters []letter) {
+	for _, l := range letters {
+		err := mb.SendMessage([]byte(l.raw), queue)
+		if err != nil {
+			log.Printf("Failed to put message back on %s: %v", queue, err)
+		}
+	}
+}
+
+func readFile(name string) ([]letter, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []letter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		dl := new(messagebus.DeadLetter)
+		err := json.Unmarshal(scanner.Bytes(), dl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		letters = append(letters, letter{raw: scanner.Text(), letter: dl})
+	}
+	return letters, scanner.Err()
+}
+
+func main() {
+	mbhost := flag.String("mbhost", "", fmt.Sprintf("Message Bus hostname. Overrides default (%s). Overrides environment: MESSAGEBUS_HOST", configStrings["mbhost"]))
+	mbport := flag.Int("mbport", 0, fmt.Sprintf("Message Bus port. Overrides default (%s). Overrides environment: MESSAGEBUS_PORT", configStrings["mbport"]))
+	queue := flag.String("queue", messagebus.DeadLetterQueue, "Dead letter queue. Overrides environment: MESSAGEBUS_DEADLETTER_QUEUE")
+	source := flag.String("source", "", "Only replay or purge dead letters that came from this queue")
+	file := flag.String("file", "", "Replay dead letters from this file of JSON lines, as written by list, instead of the queue")
+	wait := flag.Duration("wait", 2*time.Second, "Stop reading once the queue has been idle this long")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] list|replay|purge\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	action := flag.Arg(0)
+
+	getEnvSettings()
+	if *mbhost != "" {
+		configStrings["mbhost"] = *mbhost
+	}
+	if *mbport != 0 {
+		configStrings["mbport"] = strconv.Itoa(*mbport)
+	}
+
+	mbPort, _ := strconv.Atoi(configStrings["mbport"])
+	mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
+	if err != nil {
+		log.Fatalf("Could not connect to message bus: %s", err)
+	}
+	defer mb.Close()
+
+	var letters []letter
+	if *file != "" {
+		if action != "replay" {
+			log.Fatalf("-file can only be used with replay")
+		}
+		letters, err = readFile(*file)
+	} else {
+		letters, err = drain(mb, *queue, *wait)
+	}
+	if err != nil {
+		log.Fatalf("Could not read dead letters: %v", err)
+	}
+
+	var keep []letter
+	done := 0
+	switch action {
+	case "list":
+		for _, l := range letters {
+			fmt.Println(l.raw)
+		}
+		keep = letters
+	case "replay", "purge":
+		for _, l := range letters {
+			if l.letter == nil || (*source != "" && l.letter.Queue != *source) {
+				keep = append(keep, l)
+				continue
+			}
+			if action == "replay" {
+				err := mb.SendMessage([]byte(l.letter.Body), l.letter.Queue)
+				if err != nil {
+					log.Printf("Failed to replay message to %s: %v", l.letter.Queue, err)
+					keep = append(keep, l)
+					continue
+				}
+			}
+			done++
+		}
+	default:
+		log.Fatalf("Unknown action %s, use list, replay or purge", action)
+	}
+
+	if *file == "" {
+		putBack(mb, *queue, keep)
+	}
+	log.Printf("%s: %d dead letters read, %d handled, %d left on %s", action, len(letters), done, len(keep), *queue)
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
 	"net/http"
 	"os"
 
@@ -19,12 +18,14 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	"mbhost": "activemq",
 	"mbport": "61613",
+	"mbtype": "stomp",
 }
 
 type DataValueElasticSearch struct {
@@ -83,13 +84,8 @@ func handleGroups(groupsChan chan *databus.DataGroup,
 			if len(value.Value) == 0 {
 				continue
 			}
-			intVal, intErr := strconv.ParseInt(value.Value, 10, 64)
-			floatVal, floatErr := strconv.ParseFloat(value.Value, 64)
 			esvalue := DataValueElasticSearch{value.ID, value.Context, value.Label, value.Value, value.System, value.Timestamp, 0}
-			switch {
-			case intErr == nil:
-				esvalue.ValueAggregatable = float64(intVal)
-			case floatErr == nil && !math.IsNaN(floatVal):
+			if floatVal, ok := value.Float(); ok {
 				esvalue.ValueAggregatable = floatVal
 			}
 
@@ -163,6 +159,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 }
 
 func main() {
@@ -197,8 +197,8 @@ func main() {
 
 	dbClient := new(databus.DataBusClient)
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -211,7 +211,7 @@ func main() {
 	}
 
 	groupsIn := make(chan *databus.DataGroup, 10)
-	dbClient.Subscribe("/elkstack")
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/elkstack", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/elkstack")
 	go dbClient.GetGroup(groupsIn, "/elkstack")
 
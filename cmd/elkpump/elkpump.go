@@ -21,12 +21,14 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	"mbhost": "activemq",
 	"mbport": "61613",
+	"mbtype": "stomp",
 }
 
 var (
@@ -90,6 +92,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 }
 
 func main() {
@@ -103,8 +109,8 @@ func main() {
 
 	dbClient := new(databus.DataBusClient)
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -116,7 +122,7 @@ func main() {
 	}
 
 	groupsIn := make(chan *databus.DataGroup, 10)
-	dbClient.Subscribe("/elkstack")
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/elkstack", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/elkstack")
 	go dbClient.GetGroup(groupsIn, "/elkstack")
 
@@ -48,6 +48,22 @@ document.getElementById('deleteServiceButton').addEventListener('click', functio
     deleteService();
 })
 
+document.getElementById('telemetryModalButton').addEventListener('click', function () {
+    showTelemetry();
+})
+
+document.getElementById('crossTelemetryModal').addEventListener('click', function () {
+    $('#telemetryModal').modal('hide');
+})
+
+document.getElementById('closeTelemetryModal').addEventListener('click', function () {
+    $('#telemetryModal').modal('hide');
+})
+
+document.getElementById('configTelemetryButton').addEventListener('click', function () {
+    configTelemetry();
+})
+
 
 document.getElementById('uploadCsvModalButton').addEventListener('click', function () {
     $('#csvModal').modal('show');
@@ -118,20 +134,36 @@ window.addEventListener('load', function () {
 //     $('[data-toggle="tooltip"]').tooltip()
 // })
 
+// sledOrder puts the devices sitting in a chassis right after it
+function sledOrder(data) {
+    var hostnames = new Set(data.map(producer => producer.Hostname));
+    var ordered = [];
+    data.filter(producer => !hostnames.has(producer.Parent)).forEach(function (producer) {
+        ordered.push(producer);
+        data.filter(child => child.Parent === producer.Hostname)
+            .sort((a, b) => a.Slot - b.Slot)
+            .forEach(child => ordered.push(child));
+    });
+    return ordered;
+}
+
 function gotServiceList(data) {
     var tbody = document.getElementById('services');
+    data = sledOrder(data);
     serviceData = data;
     var classStr;
     for (var i = 0; i < data.length; i++) {
         classStr = "text-warning"
         switch (data[i].State) {
             case 'Running':
+            case 'Polling':
                 classStr = "text-success";
                 break;
             case 'Stopped':
                 classStr = "text-danger";
                 break;
             case 'Connection Failed':
+            case 'Certificate Verification Failed':
                 classStr = "text-danger";
                 break;
             case 'Telemetry Service Not Found':
@@ -141,8 +173,18 @@ function gotServiceList(data) {
 
         var serviceRow = tbody.insertRow();
 
+        // devices in a chassis are indented under it, a chassis lists its sleds on hover
         var hostname = serviceRow.insertCell(0);
         hostname.innerHTML = data[i].Hostname;
+        if (data[i].Parent) {
+            hostname.innerHTML = '&nbsp;&nbsp;&#8627; ' + data[i].Hostname + ' (slot ' + data[i].Slot + ')';
+            hostname.title = 'In chassis ' + data[i].Parent;
+        }
+        if (data[i].Sleds) {
+            hostname.innerHTML += ' (' + data[i].Sleds.length + ' sleds)';
+            hostname.title = data[i].Sleds.map(sled => 'Slot ' + sled.Slot + ': ' + sled.ServiceTag +
+                (sled.Hostname ? ' at ' + sled.Hostname : '')).join('\n');
+        }
 
         var username = serviceRow.insertCell(1);
         username.innerHTML = data[i].Username;
@@ -154,7 +196,38 @@ function gotServiceList(data) {
         var lastEvent = serviceRow.insertCell(3);
         lastEvent.innerHTML = new Date(data[i].LastEvent);
 
-        var checkBoxService = serviceRow.insertCell(4);
+        var model = serviceRow.insertCell(4);
+        model.innerHTML = data[i].Model || '';
+        model.title = data[i].Platform || '';
+
+        var fwVer = serviceRow.insertCell(5);
+        fwVer.innerHTML = data[i].FwVer || '';
+
+        // zero times come back as year 1
+        var connected = serviceRow.insertCell(6);
+        var connectedSince = new Date(data[i].ConnectedSince);
+        connected.innerHTML = connectedSince.getFullYear() > 1 ? connectedSince : '';
+
+        // totals, with the per report and per event group counts on hover
+        var reports = serviceRow.insertCell(7);
+        var counts = [];
+        var total = 0;
+        for (const [id, count] of Object.entries(Object.assign({}, data[i].Reports, data[i].Events))) {
+            counts.push(id + ': ' + count);
+            total += count;
+        }
+        reports.innerHTML = total;
+        reports.title = counts.join('\n');
+
+        var errors = serviceRow.insertCell(8);
+        errors.innerHTML = data[i].ParseErrors + ' parse / ' + data[i].Reconnects + ' reconnects / ' +
+            data[i].MissedReports + ' missed (' + data[i].SequenceGaps + ' gaps) / ' +
+            data[i].DuplicateReports + ' duplicates';
+        if (data[i].LastError) {
+            errors.title = new Date(data[i].LastErrorTime) + ': ' + data[i].LastError;
+        }
+
+        var checkBoxService = serviceRow.insertCell(9);
         var checkbox = document.createElement('input');
         checkbox.classList.add('check');
         checkbox.type = 'checkbox';
@@ -175,7 +248,7 @@ function gotConfigList(data) {
 
 function gotKafkaConfigList(data) {
     var tbody = $('#kafka');
-    tbody.append('<tr><td>' + data.kafkaBroker + '</td><td>' + data.kafkaTopic + '</td><td>' + data.tls + '</td><td>' + data.kafkaSkipVerify + '</td><td>' + data.clientAuth + '</td></tr>')
+    tbody.append('<tr><td>' + data.kafkaBroker + '</td><td>' + data.kafkaTopic + '</td><td>' + data.tls + '</td><td>' + data.kafkaSkipVerify + '</td><td>' + data.clientAuth + '</td><td>' + (data.kafkaSaslMechanism || 'none') + '</td></tr>')
 }
 
 function checkboxClick(evt) {
@@ -187,8 +260,13 @@ function checkboxClick(evt) {
     else {
         serviceData[checkboxIndex].toDelete = false
     }
-    if ($(".check:checked").length) $("#deleteServiceButton").show();
-    else $("#deleteServiceButton").hide();
+    if ($(".check:checked").length) {
+        $("#deleteServiceButton").show();
+        $("#telemetryModalButton").show();
+    } else {
+        $("#deleteServiceButton").hide();
+        $("#telemetryModalButton").hide();
+    }
 };
 
 function readFileContents(file, callback) {
@@ -220,8 +298,8 @@ async function uploadFile() {
     var isValidCSVFlag = null;
     if (csvFile) {
 
-        // Check the format of the uploaded CSV file. 3 values in each line
-        // and  first value should be a valid IP
+        // Check the format of the uploaded CSV file. 3 to 6 values in each line
+        // (IP, username, password, CA bundle, fingerprint, insecure) and first value should be a valid IP
         var reader = new FileReader();
         reader.readAsText(csvFile, "UTF-8");
         reader.onload = function (e) {
@@ -230,18 +308,18 @@ async function uploadFile() {
                 console.log('row', i)
                 var cells = rows[i].trim().split(",");
 
-                //  Check if each row in the CSV file has 3 values
-                if (cells.length == 3) {
+                //  Check if each row in the CSV file has 3 to 6 values
+                if (cells.length >= 3 && cells.length <= 6) {
                     isValidfieldsFlag = true
                     // condition to check whether the IP is correct format
                     isValidIPFlag = isValidIP(cells[0])
                 } else {
-                    console.log("Row ", i, " should have 3 values")
+                    console.log("Row ", i, " should have 3 to 6 values")
                     isValidfieldsFlag = false;
                 }
             }
             // Uploaded file is invalid if either of the 2 conditions mentioned
-            // above (3 values in each line and  first value should be a valid IP)
+            // above (3 to 6 values in each line and  first value should be a valid IP)
             // are not satisfied
             isValidCSVFlag = isValidIPFlag && isValidfieldsFlag
 
@@ -268,7 +346,8 @@ async function uploadFile() {
                 // Alert the user if the uploaded file doesn't have the correct format
                 console.log("isValidCSV else ", isValidCSVFlag)
                 alert("Invalid CSV file format. Please ensure that the first value in every row "
-                    + "is a valid IP and that there are 3 values in each row of the csv file (IP, username, password)")
+                    + "is a valid IP and that there are 3 to 6 values in each row of the csv file "
+                    + "(IP, username, password and optionally CA bundle, fingerprint, insecure)")
             }
         }
     }
@@ -308,6 +387,77 @@ function deleteService() {
     })
 }
 
+function selectedHostnames() {
+    var hostnames = []
+    for (var i = 0; i < serviceData.length; i++) {
+        if (serviceData[i].toDelete == true) {
+            hostnames.push(serviceData[i].Hostname)
+        }
+    }
+    return hostnames
+}
+
+// The modal shows the state of the first selected system; applying it sets every selected system to the same state
+let telemetryReports;
+
+function showTelemetry() {
+    var hostnames = selectedHostnames();
+    $('#telemetrySystems').text(hostnames.join(', '));
+    $.getJSON('/api/v1/Telemetry', { hostname: hostnames[0] }, gotTelemetry)
+        .fail(function () {
+            alert("Failed to get the telemetry configuration of " + hostnames[0] + "!");
+        });
+}
+
+function gotTelemetry(data) {
+    telemetryReports = data.Reports;
+    $('#telemetryServiceEnabled').prop('checked', data.ServiceEnabled);
+    var tbody = document.getElementById('telemetryReports');
+    tbody.innerHTML = '';
+    for (var i = 0; i < data.Reports.length; i++) {
+        var row = tbody.insertRow(-1);
+        var name = row.insertCell(0);
+        name.innerHTML = data.Reports[i].ID;
+        name.title = data.Reports[i].Description || '';
+        var enabled = document.createElement('input');
+        enabled.type = 'checkbox';
+        enabled.id = 'telemetryReportEnabled-' + i;
+        enabled.checked = data.Reports[i].Enabled;
+        row.insertCell(1).appendChild(enabled);
+        var interval = document.createElement('input');
+        interval.type = 'text';
+        interval.className = 'form-control form-control-sm';
+        interval.id = 'telemetryReportInterval-' + i;
+        interval.value = data.Reports[i].RecurrenceInterval || '';
+        row.insertCell(2).appendChild(interval);
+    }
+    $('#telemetryModal').modal('show');
+}
+
+function configTelemetry() {
+    var reports = [];
+    for (var i = 0; i < telemetryReports.length; i++) {
+        var report = { id: telemetryReports[i].ID, enabled: $('#telemetryReportEnabled-' + i).is(':checked') };
+        var interval = $('#telemetryReportInterval-' + i).val();
+        if (interval) {
+            report.recurrenceInterval = interval;
+        }
+        reports.push(report);
+    }
+    $.ajax({
+        url: '/api/v1/Telemetry',
+        method: 'POST',
+        data: JSON.stringify({
+            hostname: selectedHostnames(),
+            serviceEnabled: $('#telemetryServiceEnabled').is(':checked'),
+            reports: reports
+        }),
+        contentType: 'application/json',
+        dataType: 'json',
+        complete: telemetryDone
+    })
+}
+
 function configHEC() {
     var arr = $('#newHEC').serializeArray();
     var obj = {};
@@ -344,6 +494,12 @@ function kafkaConfig() {
         kafkaTopic: $("#Topic").val()
     };
 
+    if ($("#kafkaSaslMechanism").val()) {
+        obj.kafkaSaslMechanism = $("#kafkaSaslMechanism").val();
+        obj.kafkaSaslUsername = $("#kafkaSaslUsername").val();
+        obj.kafkaSaslPassword = $("#kafkaSaslPassword").val();
+    }
+
     if ($("#tls").is(":checked")) {
         obj.tls = "true";
         obj.kafkaSkipVerify = $("#kafkaskipverify").is(":checked") ? "true" : "false";
@@ -425,6 +581,24 @@ function deleteDone(jqXHR) {
     }
 }
 
+function telemetryDone(jqXHR) {
+    if (jqXHR.status != 200) {
+        alert("Failed to configure telemetry!");
+        return;
+    }
+    var failed = [];
+    for (const state of jqXHR.responseJSON) {
+        if (state.Errors && state.Errors.length) {
+            failed.push(state.ServiceIP + ': ' + state.Errors.join('; '));
+        }
+    }
+    if (failed.length) {
+        alert("Failed to configure telemetry on\n" + failed.join('\n'));
+    } else {
+        $('#telemetryModal').modal('hide');
+    }
+}
+
 function kafkaDone(jqXHR) {
     if (jqXHR.status != 200) {
         alert("Failed to add kafka config!");
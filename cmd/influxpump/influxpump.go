@@ -16,19 +16,24 @@ import (
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	"mbhost": "activemq",
 	"mbport": "61613",
+	"mbtype": "stomp",
 	"URL":    "http://localhost:8086",
 }
 
 func handleGroups(writeAPI api.WriteAPI, groupsChan chan *databus.DataGroup) {
 	for group := range groupsChan {
 		for _, value := range group.Values {
-			floatVal, _ := strconv.ParseFloat(value.Value, 64)
+			floatVal, ok := value.Float()
+			if !ok {
+				continue
+			}
 
 			timestamp, err := time.Parse(time.RFC3339, value.Timestamp)
 			//fmt.Printf("Value: %#v\n", value)
@@ -47,31 +52,38 @@ func handleGroups(writeAPI api.WriteAPI, groupsChan chan *databus.DataGroup) {
 
 			// automatically batches things behind the scenes
 			writeAPI.WritePoint(p)
-
-			if group.Label == "Redfish LifeCycle Events" {
-                                log.Printf("Measurement - RLCE\n")
-                                r := write.NewPointWithMeasurement("redfishlce").
-                                        AddTag("RedfishSystem", value.System).
-                                        AddTag("RedfishContext", value.Context).
-                                        AddTag("RedfishLabel", value.Label).
-                                        AddTag("EventId", value.ID).
-                                        AddField("EventType", value.EventType).
-                                        AddField("MaxBandwidthPercent", value.MaxBandwidthPercent).
-                                        AddField("MinBandwidthPercent", value.MinBandwidthPercent).
-                                        AddField("DiscardedPkts", value.DiscardedPkts).
-                                        AddField("RxBroadcast", value.RxBroadcast).
-                                        AddField("RxBytes", value.RxBytes).
-                                        AddField("RxErrorPktAlignmentErrors", value.RxErrorPktAlignmentErrors).
-                                        AddField("RxMulticastPackets", value.RxMulticastPackets).
-                                        AddField("RxUnicastPackets", value.RxUnicastPackets).
-                                        AddField("TxBytes", value.TxBytes).
-                                        AddField("TxMutlicastPackets", value.TxMutlicastPackets).
-                                        AddField("TxUnicastPackets", value.TxUnicastPackets).
-                                        AddField("TxBroadcast", value.TxBroadcast).
-                                        SetTime(timestamp)
-                        // automatically batches things behind the scenes
-                                writeAPI.WritePoint(r)
+		}
+		for _, event := range group.LifecycleEvents {
+			if event.NIC == nil {
+				continue
 			}
+			timestamp, err := time.Parse(time.RFC3339, event.EventTimestamp)
+			if err != nil {
+				log.Printf("Error parsing timestamp for lifecycle event %s: (%s) %v", event.EventId, event.EventTimestamp, err)
+				continue
+			}
+			nic := event.NIC
+			r := write.NewPointWithMeasurement("redfishlce").
+				AddTag("RedfishSystem", group.System).
+				AddTag("RedfishContext", event.OriginOfCondition).
+				AddTag("RedfishLabel", nic.ID).
+				AddTag("EventId", event.EventId).
+				AddField("EventType", event.EventType).
+				AddField("MessageId", event.MessageId).
+				AddField("MaxBandwidthPercent", nic.MaxBandwidthPercent).
+				AddField("MinBandwidthPercent", nic.MinBandwidthPercent).
+				AddField("DiscardedPkts", nic.DiscardedPkts).
+				AddField("RxBroadcast", nic.RxBroadcast).
+				AddField("RxBytes", nic.RxBytes).
+				AddField("RxErrorPktAlignmentErrors", nic.RxErrorPktAlignmentErrors).
+				AddField("RxMulticastPackets", nic.RxMulticastPackets).
+				AddField("RxUnicastPackets", nic.RxUnicastPackets).
+				AddField("TxBytes", nic.TxBytes).
+				AddField("TxMulticastPackets", nic.TxMulticastPackets).
+				AddField("TxUnicastPackets", nic.TxUnicastPackets).
+				AddField("TxBroadcast", nic.TxBroadcast).
+				SetTime(timestamp)
+			writeAPI.WritePoint(r)
 		}
 	}
 }
@@ -88,6 +100,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 	configStrings["URL"] = os.Getenv("INFLUXDB_URL")
 	configStrings["Token"] = os.Getenv("INFLUX_TOKEN")
 	configStrings["Org"] = os.Getenv("INFLUX_ORG")
@@ -101,9 +117,9 @@ func main() {
 	getEnvSettings()
 
 	dbClient := new(databus.DataBusClient)
-	stompPort, _ := strconv.Atoi(configStrings["mbport"])
+	mbPort, _ := strconv.Atoi(configStrings["mbport"])
 	for {
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -115,7 +131,7 @@ func main() {
 	}
 
 	groupsIn := make(chan *databus.DataGroup, 10)
-	dbClient.Subscribe("/influx")
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/influx", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/influx")
 	go dbClient.GetGroup(groupsIn, "/influx")
 
@@ -15,8 +15,8 @@ import (
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
 
 	//"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/amqp"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/kafka"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
 )
 
 type kafkaEventFields struct {
@@ -31,6 +31,8 @@ type kafkaEventFields struct {
 	MessageId         string `json:"message_id,omitempty"`
 	Message           string `json:"message,omitempty"`
 	OriginOfCondition string `json:"origin,omitempty"`
+	// lifecycle event data, NIC only for events about a NIC port
+	NIC *databus.NICStats `json:"nic,omitempty"`
 }
 
 type kafkaEvent struct {
@@ -44,6 +46,7 @@ var configStringsMu sync.RWMutex
 var configStrings = map[string]string{
 	"mbhost":          "activemq",
 	"mbport":          "61613",
+	"mbtype":          "stomp",
 	"kafkaBroker":     "",
 	"kafkaTopic":      "",
 	"kafkaPartition":  "0",
@@ -51,6 +54,10 @@ var configStrings = map[string]string{
 	"kafkaClientCert": "",
 	"kafkaClientKey":  "",
 	"kafkaSkipVerify": "",
+
+	"kafkaSaslMechanism": "",
+	"kafkaSaslUsername":  "",
+	"kafkaSaslPassword":  "",
 }
 
 var configItems = map[string]*config.ConfigEntry{
@@ -89,6 +96,21 @@ var configItems = map[string]*config.ConfigEntry{
 		Get:     configGet,
 		Default: "",
 	},
+	"kafkaSaslMechanism": {
+		Set:     configSet,
+		Get:     configGet,
+		Default: "",
+	},
+	"kafkaSaslUsername": {
+		Set:     configSet,
+		Get:     configGet,
+		Default: "",
+	},
+	"kafkaSaslPassword": {
+		Set:     configSet,
+		Get:     configGet,
+		Default: "",
+	},
 }
 
 func configSet(name string, value interface{}) error {
@@ -96,7 +118,8 @@ func configSet(name string, value interface{}) error {
 	defer configStringsMu.Unlock()
 
 	switch name {
-	case "kafkaBroker", "kafkaTopic", "kafkaPartition", "kafkaCACert", "kafkaClientCert", "kafkaClientKey", "kafkaSkipVerify":
+	case "kafkaBroker", "kafkaTopic", "kafkaPartition", "kafkaCACert", "kafkaClientCert", "kafkaClientKey", "kafkaSkipVerify",
+		"kafkaSaslMechanism", "kafkaSaslUsername", "kafkaSaslPassword":
 		configStrings[name] = value.(string)
 	default:
 		return fmt.Errorf("unknown property %s", name)
@@ -106,11 +129,21 @@ func configSet(name string, value interface{}) error {
 
 func configGet(name string) (interface{}, error) {
 	switch name {
-	case "kafkaBroker", "kafkaTopic", "kafkaPartition", "kafkaCACert", "kafkaClientCert", "kafkaClientKey", "kafkaSkipVerify":
+	case "kafkaBroker", "kafkaTopic", "kafkaPartition", "kafkaCACert", "kafkaClientCert", "kafkaClientKey", "kafkaSkipVerify",
+		"kafkaSaslMechanism", "kafkaSaslUsername":
 		configStringsMu.RLock()
 		ret := configStrings[name]
 		configStringsMu.RUnlock()
 		return ret, nil
+	case "kafkaSaslPassword":
+		// never hand the password back out over the bus, only whether one is set
+		configStringsMu.RLock()
+		ret := configStrings[name]
+		configStringsMu.RUnlock()
+		if ret != "" {
+			ret = "********"
+		}
+		return ret, nil
 	default:
 		return nil, fmt.Errorf("unknown property %s", name)
 	}
@@ -128,6 +161,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 	kafkaBroker := os.Getenv("KAFKA_BROKER")
 	if len(kafkaBroker) > 0 {
 		configStrings["kafkaBroker"] = kafkaBroker
@@ -156,95 +193,111 @@ func getEnvSettings() {
 	if len(kafkaSkipVerify) > 0 {
 		configStrings["kafkaSkipVerify"] = kafkaSkipVerify
 	}
+	kafkaSaslMechanism := os.Getenv("KAFKA_SASL_MECHANISM")
+	if len(kafkaSaslMechanism) > 0 {
+		configStrings["kafkaSaslMechanism"] = kafkaSaslMechanism
+	}
+	kafkaSaslUsername := os.Getenv("KAFKA_SASL_USERNAME")
+	if len(kafkaSaslUsername) > 0 {
+		configStrings["kafkaSaslUsername"] = kafkaSaslUsername
+	}
+	kafkaSaslPassword := os.Getenv("KAFKA_SASL_PASSWORD")
+	if len(kafkaSaslPassword) > 0 {
+		configStrings["kafkaSaslPassword"] = kafkaSaslPassword
+	}
 
 }
 
-// handleGroups brings in the events from ActiveMQ
-func handleGroups(groupsChan chan *databus.DataGroup, kafkamb messagebus.Messagebus) {
-	for {
-		group := <-groupsChan // If you are new to GoLang see https://golangdocs.com/channels-in-golang
-		// log.Println("Got a group:  size of metrics alerts ", len(group.Values), len(group.Events))
-		events := make([]*kafkaEvent, len(group.Values)+len(group.Events))
-		for index, value := range group.Values {
-			timestamp, err := time.Parse(time.RFC3339, value.Timestamp)
+// handleGroup sends a group from ActiveMQ to kafka. The group is only acked on the internal bus once it returns nil.
+func handleGroup(group *databus.DataGroup, kafkamb messagebus.Messagebus) error {
+	// log.Println("Got a group:  size of metrics alerts ", len(group.Values), len(group.Events))
+	events := make([]*kafkaEvent, 0, len(group.Values)+len(group.Events)+len(group.LifecycleEvents))
+	for _, value := range group.Values {
+		timestamp, err := time.Parse(time.RFC3339, value.Timestamp)
+		if err != nil {
+			// For why we do this see https://datatracker.ietf.org/doc/html/rfc3339#section-4.3
+			// Go does not handle time properly. See https://github.com/golang/go/issues/20555
+			value.Timestamp = strings.ReplaceAll(value.Timestamp, "+0000", "Z")
+			timestamp, err = time.Parse(time.RFC3339, value.Timestamp)
 			if err != nil {
-				// For why we do this see https://datatracker.ietf.org/doc/html/rfc3339#section-4.3
-				// Go does not handle time properly. See https://github.com/golang/go/issues/20555
-				value.Timestamp = strings.ReplaceAll(value.Timestamp, "+0000", "Z")
-				timestamp, err = time.Parse(time.RFC3339, value.Timestamp)
-				if err != nil {
-					log.Printf("Error parsing timestamp for point %s: (%s) %v", value.Context+"_"+value.ID, value.Timestamp, err)
-					continue
-				}
-			}
-			event := new(kafkaEvent)
-			event.Time = timestamp.Unix()
-			event.Event = "metric"
-			event.Host = value.System
-
-			// --- safe numeric/boolean parsing (silent fallback) ---
-			var floatVal float64
-			switch strings.ToLower(value.Value) {
-			case "true":
-				floatVal = 1.0
-			case "false":
-				floatVal = 0.0
-			default:
-				f, err := strconv.ParseFloat(value.Value, 64)
-				if err != nil {
-					f = 0.0 // fallback silently, no log
-				}
-				floatVal = f
+				log.Printf("Error parsing timestamp for point %s: (%s) %v", value.Context+"_"+value.ID, value.Timestamp, err)
+				continue
 			}
+		}
+		event := new(kafkaEvent)
+		event.Time = timestamp.Unix()
+		event.Event = "metric"
+		event.Host = value.System
+
+		floatVal, ok := value.Float()
+		if !ok {
+			continue
+		}
 
-			event.Fields.Value = floatVal
-			event.Fields.MetricName = value.Context + "_" + value.ID
+		event.Fields.Value = floatVal
+		event.Fields.MetricName = value.Context + "_" + value.ID
 
-			events[index] = event
-		}
-		// alerts
-		for index, evt := range group.Events {
-			timestamp, err := time.Parse(time.RFC3339, evt.EventTimestamp)
+		events = append(events, event)
+	}
+	// alerts
+	for _, evt := range group.Events {
+		timestamp, err := time.Parse(time.RFC3339, evt.EventTimestamp)
+		if err != nil {
+			// For why we do this see https://datatracker.ietf.org/doc/html/rfc3339#section-4.3
+			// Go does not handle time properly. See https://github.com/golang/go/issues/20555
+			evt.EventTimestamp = strings.ReplaceAll(evt.EventTimestamp, "+0000", "Z")
+			timestamp, err = time.Parse(time.RFC3339, evt.EventTimestamp)
 			if err != nil {
-				// For why we do this see https://datatracker.ietf.org/doc/html/rfc3339#section-4.3
-				// Go does not handle time properly. See https://github.com/golang/go/issues/20555
-				evt.EventTimestamp = strings.ReplaceAll(evt.EventTimestamp, "+0000", "Z")
-				timestamp, err = time.Parse(time.RFC3339, evt.EventTimestamp)
-				if err != nil {
-					log.Printf("Error parsing timestamp for point %s: (%s) %v", evt.EventId+"_"+evt.MessageId, evt.EventTimestamp, err)
-					continue
-				}
+				log.Printf("Error parsing timestamp for point %s: (%s) %v", evt.EventId+"_"+evt.MessageId, evt.EventTimestamp, err)
+				continue
 			}
-			event := new(kafkaEvent)
-			event.Host = group.System
-			event.Time = timestamp.Unix()
-			event.Event = "alert"
-			event.Fields.AlertId = evt.EventId
-			event.Fields.MemberId = evt.MemberId
-			event.Fields.MessageId = evt.MessageId
-			event.Fields.Severity = evt.MessageSeverity
-			event.Fields.Message = evt.Message
-			event.Fields.OriginOfCondition = evt.OriginOfCondition
-
-			events[index] = event
 		}
-		// send
-		configStringsMu.RLock()
-		ktopic := configStrings["kafkaTopic"]
-		configStringsMu.RUnlock()
-
-		jsonStr, _ := json.Marshal(events)
-		if err := kafkamb.SendMessage(jsonStr, ktopic); err != nil {
-			log.Printf("SendMessage error, terminating for restart: %v", err)
-			os.Exit(1) // let K8s restart the pod
+		event := new(kafkaEvent)
+		event.Host = group.System
+		event.Time = timestamp.Unix()
+		event.Event = "alert"
+		event.Fields.AlertId = evt.EventId
+		event.Fields.MemberId = evt.MemberId
+		event.Fields.MessageId = evt.MessageId
+		event.Fields.Severity = evt.MessageSeverity
+		event.Fields.Message = evt.Message
+		event.Fields.OriginOfCondition = evt.OriginOfCondition
+
+		events = append(events, event)
+	}
+	// lifecycle events
+	for _, evt := range group.LifecycleEvents {
+		timestamp, err := time.Parse(time.RFC3339, strings.ReplaceAll(evt.EventTimestamp, "+0000", "Z"))
+		if err != nil {
+			log.Printf("Error parsing timestamp for point %s: (%s) %v", evt.EventId+"_"+evt.MessageId, evt.EventTimestamp, err)
+			continue
 		}
-
+		event := new(kafkaEvent)
+		event.Host = group.System
+		event.Time = timestamp.Unix()
+		event.Event = "lifecycle"
+		event.Fields.AlertId = evt.EventId
+		event.Fields.MessageId = evt.MessageId
+		event.Fields.Severity = evt.MessageSeverity
+		event.Fields.Message = evt.Message
+		event.Fields.OriginOfCondition = evt.OriginOfCondition
+		event.Fields.NIC = evt.NIC
+
+		events = append(events, event)
 	}
+	// send
+	configStringsMu.RLock()
+	ktopic := configStrings["kafkaTopic"]
+	configStringsMu.RUnlock()
+
+	jsonStr, _ := json.Marshal(events)
+	return kafkamb.SendMessage(jsonStr, ktopic)
 }
 
 func main() {
 	getEnvSettings()
 	configStringsMu.RLock()
+	mbType := configStrings["mbtype"]
 	host := configStrings["mbhost"]
 	port, _ := strconv.Atoi(configStrings["mbport"])
 	configStringsMu.RUnlock()
@@ -252,7 +305,7 @@ func main() {
 	// internal message bus
 	var mb messagebus.Messagebus
 	for {
-		smb, err := stomp.NewStompMessageBus(host, port)
+		smb, err := messagebus.New(messagebus.NewConfig(mbType, host, port))
 		if err == nil {
 			defer smb.Close()
 			mb = smb
@@ -266,10 +319,9 @@ func main() {
 	dbClient.Bus = mb
 	configService := config.NewConfigService(mb, "/kafkapump/config", configItems)
 
-	dbClient.Subscribe("/kafka")
+	ctx := dbClient.ExitOnSignal()
+	dbClient.SubscribeWithLease(ctx, "/kafka", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/kafka")
-	groupsIn := make(chan *databus.DataGroup, 10)
-	go dbClient.GetGroup(groupsIn, "/kafka")
 	go configService.Run()
 
 	// external message bus - kafka
@@ -278,6 +330,7 @@ func main() {
 
 	var kbroker []string
 	var skipVerify bool
+	var saslCfg kafka.KafkaSASLConfig
 
 	// wait for configuration
 	for {
@@ -298,8 +351,11 @@ func main() {
 		if configStrings["kafkaSkipVerify"] == "true" {
 			skipVerify = true
 		}
+		saslCfg.Mechanism = configStrings["kafkaSaslMechanism"]
+		saslCfg.Username = configStrings["kafkaSaslUsername"]
+		saslCfg.Password = configStrings["kafkaSaslPassword"]
 
-		log.Println("configStrings : ", configStrings)
+		log.Printf("Kafka configuration: broker %v topic %s partition %s SASL mechanism %q", kbroker, ktopic, kpart, saslCfg.Mechanism)
 		configStringsMu.RUnlock()
 
 		// minimum config available
@@ -313,18 +369,21 @@ func main() {
 
 	// connection loop
 	for {
-		tlsCfg := &kafka.KafkaTLSConfig{
-			ServerCA:   kcert,
-			ClientCert: kccert,
-			ClientKey:  kckey,
-			SkipVerify: skipVerify,
+		var tlsCfg *kafka.KafkaTLSConfig
+		if kcert != "" || kccert != "" || skipVerify {
+			tlsCfg = &kafka.KafkaTLSConfig{
+				ServerCA:   kcert,
+				ClientCert: kccert,
+				ClientKey:  kckey,
+				SkipVerify: skipVerify,
+			}
 		}
 
 		khost := kbroker[0]
 		kport, _ := strconv.Atoi(kbroker[1])
 		log.Printf("Connecting to kafka broker (%s:%d) with topic %s, partition %s\n", khost, kport, ktopic, kpart)
 		p, _ := strconv.Atoi(kpart)
-		kmb, err := kafka.NewKafkaMessageBus(khost, kport, ktopic, p, tlsCfg)
+		kmb, err := kafka.NewKafkaMessageBus(khost, kport, ktopic, p, tlsCfg, &saslCfg)
 		if err == nil {
 			defer kmb.Close()
 			kafkamb = kmb
@@ -337,5 +396,14 @@ func main() {
 
 	log.Printf("Entering processing loop")
 
-	handleGroups(groupsIn, kafkamb)
+	err := dbClient.ReceiveGroups(ctx, "/kafka", func(group *databus.DataGroup) error {
+		return handleGroup(group, kafkamb)
+	})
+	if ctx.Err() != nil {
+		// Stopped by a signal, ExitOnSignal exits once we have unsubscribed
+		select {}
+	}
+	// The group that failed was nacked, so it is sent again once we are restarted
+	log.Printf("SendMessage error, terminating for restart: %v", err)
+	os.Exit(1) // let K8s restart the pod
 }
@@ -15,7 +15,7 @@ import (
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/config"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 	"github.com/spf13/viper"
 
 	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
@@ -53,6 +53,7 @@ var configStringsMu sync.RWMutex
 var configStrings = map[string]string{
 	"mbhost":         "activemq",
 	"mbport":         "61613",
+	"mbtype":         "stomp",
 	"otelCollector":  "",
 	"otelCACert":     "",
 	"otelClientCert": "",
@@ -148,6 +149,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 	otelCollector := os.Getenv("OTEL_COLLECTOR")
 	if len(otelCollector) > 0 {
 		configStrings["otelCollector"] = otelCollector
@@ -272,7 +277,7 @@ func readOtelMeta(configFile string) {
 				continue
 			}
 			om.valueType = otyp
-			ou, ok := m["unit"].(string)
+			ou, ok := m["units"].(string)
 			if ok {
 				om.unit = ou
 			}
@@ -318,23 +323,6 @@ func readOtelMeta(configFile string) {
 	}
 }
 
-// parseFloat attempts to parse a string as a float64.
-//
-// Parameters:
-//
-//	value string - the string to convert.
-//
-// Returns:
-//
-//	(float64, bool) - the parsed float and true on success, or 0 and false on failure.
-func parseFloat(value string) (float64, bool) {
-	val, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		return 0, false
-	}
-	return val, true
-}
-
 // parseRFC3339ToNanos parses an RFC3339 timestamp string and returns the Unix nanosecond representation.
 //
 // Parameters:
@@ -427,14 +415,16 @@ func addScopeAttributes(otelM otelMeta, fqdd, timestamp string, scope *commonv1.
 // Returns:
 //
 //	map[string]map[string][]dp - a nested map keyed first by FQDD then by metric ID, each containing a slice of data points.
-func groupMetricsByFQDDAndMetricId(group *databus.DataGroup) map[string]map[string][]dp {
+func groupMetricsByFQDDAndMetricId(group *databus.DataGroup, catalog *databus.MetricCatalog) map[string]map[string][]dp {
 
 	metricsByFQDDMetricId := map[string]map[string][]dp{}
 	for _, value := range group.Values {
-		var val any
-		val, ok := parseFloat(value.Value)
-		if !ok {
-			val = value.Value // keep original string value for enum conversion
+		if value.Kind == "" {
+			catalog.Describe(&value)
+		}
+		var val any = value.Value // keep original string value for enum conversion
+		if value.Kind == databus.FLOAT || value.Kind == databus.INT {
+			val = value.Number
 		}
 		nanos, err := parseRFC3339ToNanos(value.Timestamp)
 		if err != nil {
@@ -454,17 +444,20 @@ func groupMetricsByFQDDAndMetricId(group *databus.DataGroup) map[string]map[stri
 }
 
 // toOTLPMetrics converts a DataGroup containing metric values into an
-// OTLP ResourceMetrics protobuf message.
+// OTLP ResourceMetrics protobuf message. Descriptions and units come from the
+// device's metric catalog, falling back to redfishToOtel.yaml for metrics it
+// does not define or when no catalog has been received yet.
 //
 // Parameters:
 //
 //	group *databus.DataGroup - the data group with metric information.
+//	catalog *databus.MetricCatalog - the metric catalog of the group's device, may be nil.
 //
 // Returns:
 //
 //	(*metricsv1.ResourceMetrics, error) - the constructed ResourceMetrics and any error encountered during conversion.
-func toOTLPMetrics(group *databus.DataGroup) (*metricsv1.ResourceMetrics, error) {
-	metricsByFQDDMetricId := groupMetricsByFQDDAndMetricId(group)
+func toOTLPMetrics(group *databus.DataGroup, catalog *databus.MetricCatalog) (*metricsv1.ResourceMetrics, error) {
+	metricsByFQDDMetricId := groupMetricsByFQDDAndMetricId(group, catalog)
 	// Top level object for every group. Here a DataGroup is a MetricReport
 	rm := &metricsv1.ResourceMetrics{
 		Resource: &resourcev1.Resource{
@@ -498,10 +491,19 @@ func toOTLPMetrics(group *databus.DataGroup) (*metricsv1.ResourceMetrics, error)
 				}
 				addScopeAttributes(otelM, fqdd, strconv.FormatInt(reportTime, 10), scope)
 			}
+			description, unit := otelM.description, otelM.unit
+			if def, ok := catalog.Definition(metricId); ok {
+				if def.Description != "" {
+					description = def.Description
+				}
+				if def.Units != "" {
+					unit = def.Units
+				}
+			}
 			m := &metricsv1.Metric{
 				Name:        otelM.name,
-				Description: otelM.description,
-				Unit:        otelM.unit,
+				Description: description,
+				Unit:        unit,
 				Data: &metricsv1.Metric_Gauge{
 					Gauge: &metricsv1.Gauge{DataPoints: make([]*metricsv1.NumberDataPoint, 0)},
 				},
@@ -523,12 +525,17 @@ func toOTLPMetrics(group *databus.DataGroup) (*metricsv1.ResourceMetrics, error)
 					})
 				case string:
 					// enum string to int conversion
-					if otelM.enum == nil {
-						slog.Warn("no enum map. cannot convert value", "metricId", metricId, "value", v)
-						continue
-					}
 					ev, ok := otelM.enum[strings.ToLower(v)]
 					if !ok {
+						// fall back to the interpretation the other pumps use for booleans and states
+						if f, ok := (databus.DataValue{Value: v}).Float(); ok {
+							m.GetGauge().DataPoints = append(m.GetGauge().DataPoints, &metricsv1.NumberDataPoint{
+								TimeUnixNano: uint64(p.time),
+								Attributes:   p.attr,
+								Value:        &metricsv1.NumberDataPoint_AsDouble{AsDouble: f},
+							})
+							continue
+						}
 						slog.Warn("no enum mapping for value", "metricId", metricId, "value", v)
 						continue
 					}
@@ -574,7 +581,44 @@ func mapSeverity(s string) logsv1.SeverityNumber {
 	}
 }
 
-// toOTLPLogs converts a DataGroup containing events into an
+// eventLogRecord builds a LogRecord whose body is event as JSON, or returns nil if the event cannot be converted.
+//
+// Parameters:
+//
+//	dataType string - the event.data.type attribute, "telemetry" for alerts and "lifecycle" for lifecycle events.
+//	id, eventType, timestamp, severity string - the identity, type, RFC3339 time and severity of the event.
+//	event interface{} - the event itself.
+//
+// Returns:
+//
+//	*logsv1.LogRecord - the constructed LogRecord, nil on error.
+func eventLogRecord(dataType, id, eventType, timestamp, severity string, event interface{}) *logsv1.LogRecord {
+	etime, err := parseRFC3339ToNanos(timestamp)
+	if err != nil {
+		slog.Warn("error formatting timestamp", "error", err, "event id", id)
+		return nil
+	}
+	attrs := []*commonv1.KeyValue{
+		kv("event.data.type", dataType),
+		kv("event.object.type", eventType),
+		kv("event.object.id", id),
+	}
+	jstr, err := json.Marshal(event)
+	if err != nil {
+		slog.Warn("error marshaling event data", "error", err, "event id", id)
+		return nil
+	}
+	return &logsv1.LogRecord{
+		TimeUnixNano:         uint64(etime),
+		ObservedTimeUnixNano: uint64(etime),
+		SeverityText:         severity,
+		SeverityNumber:       mapSeverity(severity),
+		Attributes:           attrs,
+		Body:                 &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: string(jstr)}},
+	}
+}
+
+// toOTLPLogs converts a DataGroup containing events or lifecycle events into an
 // OTLP ResourceLogs protobuf message.
 //
 // Parameters:
@@ -588,30 +632,16 @@ func toOTLPLogs(group *databus.DataGroup) (*logsv1.ResourceLogs, error) {
 	var records []*logsv1.LogRecord
 
 	for _, event := range group.Events {
-		etime, err := parseRFC3339ToNanos(event.EventTimestamp)
-		if err != nil {
-			slog.Warn("error formatting timestamp", "error", err, "event id", event.EventId)
-			continue
-		}
-		attrs := []*commonv1.KeyValue{
-			kv("event.data.type", "telemetry"),
-			kv("event.object.type", event.EventType),
-			kv("event.object.id", event.EventId),
-		}
-		jstr, err := json.Marshal(event)
-		if err != nil {
-			slog.Warn("error marshaling event data", "error", err, "event id", event.EventId)
-			continue
+		lr := eventLogRecord("telemetry", event.EventId, event.EventType, event.EventTimestamp, event.MessageSeverity, event)
+		if lr != nil {
+			records = append(records, lr)
 		}
-		lr := &logsv1.LogRecord{
-			TimeUnixNano:         uint64(etime),
-			ObservedTimeUnixNano: uint64(etime),
-			SeverityText:         event.MessageSeverity,
-			SeverityNumber:       mapSeverity(event.MessageSeverity),
-			Attributes:           attrs,
-			Body:                 &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: string(jstr)}},
+	}
+	for _, event := range group.LifecycleEvents {
+		lr := eventLogRecord("lifecycle", event.EventId, event.EventType, event.EventTimestamp, event.MessageSeverity, event)
+		if lr != nil {
+			records = append(records, lr)
 		}
-		records = append(records, lr)
 	}
 
 	if len(records) == 0 {
@@ -637,14 +667,11 @@ func toOTLPLogs(group *databus.DataGroup) (*logsv1.ResourceLogs, error) {
 	return rl, nil
 }
 
-func convertAndSendOtelMetrics(ctx context.Context, groupsChan chan *databus.DataGroup, exp *httpExporter) {
+func convertAndSendOtelMetrics(ctx context.Context, groupsChan chan *databus.DataGroup, dbClient *databus.DataBusClient, exp *httpExporter) {
 	for {
 		group := <-groupsChan
-		if group.ID == "MemoryMetrics" {
-			continue
-		}
 		if len(group.Values) > 0 {
-			rm, err := toOTLPMetrics(group)
+			rm, err := toOTLPMetrics(group, dbClient.Catalog(group.System))
 			if err != nil {
 				slog.Error("error converting metrics to OTLP", "error", err)
 				continue
@@ -656,7 +683,7 @@ func convertAndSendOtelMetrics(ctx context.Context, groupsChan chan *databus.Dat
 			if err := exp.exportMetrics(ctx, rm); err != nil {
 				slog.Error("error exporting metrics", "error", err)
 			}
-		} else if len(group.Events) > 0 {
+		} else if len(group.Events) > 0 || len(group.LifecycleEvents) > 0 {
 			rl, err := toOTLPLogs(group)
 			if err != nil {
 				slog.Error("error converting logs to OTLP", "error", err)
@@ -681,6 +708,7 @@ func main() {
 
 	getEnvSettings()
 	configStringsMu.RLock()
+	mbType := configStrings["mbtype"]
 	host := configStrings["mbhost"]
 	port, _ := strconv.Atoi(configStrings["mbport"])
 	configStringsMu.RUnlock()
@@ -688,7 +716,7 @@ func main() {
 	// internal message bus
 	var mb messagebus.Messagebus
 	for {
-		smb, err := stomp.NewStompMessageBus(host, port)
+		smb, err := messagebus.New(messagebus.NewConfig(mbType, host, port))
 		if err == nil {
 			defer smb.Close()
 			mb = smb
@@ -702,7 +730,12 @@ func main() {
 	dbClient.Bus = mb
 	configService := config.NewConfigService(mb, "/otelpump/config", configItems)
 
-	dbClient.Subscribe("/otel")
+	// MemoryMetrics is not exported by default, a filter from the environment replaces that
+	filter := databus.FilterFromEnv()
+	if filter == nil {
+		filter = &databus.Filter{ExcludeReports: []string{"MemoryMetrics"}}
+	}
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/otel", filter, databus.DefaultLease)
 	dbClient.Get("/otel")
 	groupsIn := make(chan *databus.DataGroup, 10)
 	go dbClient.GetGroup(groupsIn, "/otel")
@@ -754,5 +787,5 @@ func main() {
 
 	slog.Info("Entering processing loop....")
 	// convert DMTF metrics to OTEL format and send to OTEL Collector
-	convertAndSendOtelMetrics(ctx, groupsIn, exp)
+	convertAndSendOtelMetrics(ctx, groupsIn, dbClient, exp)
 }
@@ -204,6 +204,49 @@ func TestOTLPLogs(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// TestOTLPLifecycleLogs – lifecycle events become log records next to alerts.
+// ---------------------------------------------------------------------------
+func TestOTLPLifecycleLogs(t *testing.T) {
+	group := &databus.DataGroup{
+		ID:     "Lifecycle",
+		System: "host123",
+		Events: []databus.EventValue{
+			{EventId: "1", EventType: "Alert", EventTimestamp: "2024-01-01T00:00:00Z", MessageSeverity: "Warning"},
+		},
+		LifecycleEvents: []databus.LifecycleEvent{
+			{
+				EventId:         "2",
+				EventType:       "Other",
+				EventTimestamp:  "2024-01-01T00:00:01Z",
+				MessageId:       "NIC100",
+				MessageSeverity: "OK",
+				NIC:             &databus.NICStats{ID: "NIC.Integrated.1-1-1", RxBytes: 1024},
+			},
+			{EventId: "3", EventType: "Other", EventTimestamp: "not-a-time"},
+		},
+	}
+	rl, err := toOTLPLogs(group)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rl.ScopeLogs) != 1 || len(rl.ScopeLogs[0].LogRecords) != 2 {
+		t.Fatalf("got %v, want 2 LogRecords", rl.ScopeLogs)
+	}
+	lr := rl.ScopeLogs[0].LogRecords[1]
+	attrMap := make(map[string]string)
+	for _, kv := range lr.Attributes {
+		attrMap[kv.Key] = kv.Value.GetStringValue()
+	}
+	if attrMap["event.data.type"] != "lifecycle" || attrMap["event.object.id"] != "2" {
+		t.Errorf("got attributes %v", attrMap)
+	}
+	wantBody, _ := json.Marshal(group.LifecycleEvents[0])
+	if lr.Body.GetStringValue() != string(wantBody) {
+		t.Errorf("Body = %q, want %q", lr.Body.GetStringValue(), string(wantBody))
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Helper – provides a slice of test cases for TestOTLPMetrics.
 // ---------------------------------------------------------------------------
@@ -319,7 +362,7 @@ func TestOTLPMetrics(t *testing.T) {
 	readOtelMeta("redfishToOtel.yaml")
 	for _, tc := range metricTestCases() {
 		t.Run(tc.name, func(t *testing.T) {
-			rm, err := toOTLPMetrics(tc.group)
+			rm, err := toOTLPMetrics(tc.group, nil)
 
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
@@ -354,3 +397,33 @@ func TestOTLPMetrics(t *testing.T) {
 		})
 	}
 }
+
+// TestOTLPMetricsCatalog – descriptions and units come from the device's
+// metric catalog, and from redfishToOtel.yaml without one.
+func TestOTLPMetricsCatalog(t *testing.T) {
+	readOtelMeta("redfishToOtel.yaml")
+	group := metricTestCases()[0].group
+	catalog := &databus.MetricCatalog{System: "host123", MetricDefinitions: map[string]databus.MetricDefinition{
+		"GPUMemoryUsage": {ID: "GPUMemoryUsage", Description: "Percentage of GPU memory in use", Units: "%",
+			DataType: "Decimal"},
+	}}
+
+	for _, tc := range []struct {
+		name              string
+		catalog           *databus.MetricCatalog
+		description, unit string
+	}{
+		{"catalog", catalog, "Percentage of GPU memory in use", "%"},
+		{"yaml fallback", nil, "GPU Memory Usage Percent", "%"},
+	} {
+		rm, err := toOTLPMetrics(group, tc.catalog)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		metric := rm.ScopeMetrics[0].Metrics[0]
+		if metric.Description != tc.description || metric.Unit != tc.unit {
+			t.Errorf("%s: got description %q unit %q, want %q %q", tc.name, metric.Description, metric.Unit,
+				tc.description, tc.unit)
+		}
+	}
+}
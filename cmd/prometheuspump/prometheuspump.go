@@ -14,17 +14,23 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	"mbhost": "activemq",
 	"mbport": "61613",
+	"mbtype": "stomp",
 }
 
 var collectors map[string]map[string]*prometheus.GaugeVec
 
 func doFQDDGuage(value databus.DataValue, registry *prometheus.Registry) {
+	floatVal, ok := value.Float()
+	if !ok {
+		return
+	}
 	if collectors["FQDD"] == nil {
 		collectors["FQDD"] = make(map[string]*prometheus.GaugeVec)
 	}
@@ -40,27 +46,19 @@ func doFQDDGuage(value databus.DataValue, registry *prometheus.Registry) {
 				"FQDD",
 			})
 		registry.MustRegister(guage)
-		floatVal, err := strconv.ParseFloat(value.Value, 64)
-		if err != nil {
-			if value.Value == "Up" || value.Value == "Operational" {
-				floatVal = 1
-			}
-		}
 		guage.WithLabelValues(value.System, value.HostName, value.Context).Set(floatVal)
 		collectors["FQDD"][value.ID] = guage
 	} else {
 		guage := collectors["FQDD"][value.ID]
-		floatVal, err := strconv.ParseFloat(value.Value, 64)
-		if err != nil {
-			if value.Value == "Up" || value.Value == "Operational" {
-				floatVal = 1
-			}
-		}
 		guage.WithLabelValues(value.System, value.HostName, value.Context).Set(floatVal)
 	}
 }
 
 func doNonFQDDGuage(value databus.DataValue, registry *prometheus.Registry) {
+	floatVal, ok := value.Float()
+	if !ok {
+		return
+	}
 	value.Context = strings.Replace(value.Context, " ", "", -1)
 	if collectors[value.Context] == nil {
 		collectors[value.Context] = make(map[string]*prometheus.GaugeVec)
@@ -77,12 +75,10 @@ func doNonFQDDGuage(value databus.DataValue, registry *prometheus.Registry) {
 				"HostName",
 			})
 		registry.MustRegister(guage)
-		floatVal, _ := strconv.ParseFloat(value.Value, 64)
 		guage.WithLabelValues(value.System, value.HostName).Set(floatVal)
 		collectors[value.Context][value.ID] = guage
 	} else {
 		guage := collectors[value.Context][value.ID]
-		floatVal, _ := strconv.ParseFloat(value.Value, 64)
 		guage.WithLabelValues(value.System, value.HostName).Set(floatVal)
 	}
 }
@@ -111,6 +107,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 }
 
 func main() {
@@ -121,8 +121,8 @@ func main() {
 	dbClient := new(databus.DataBusClient)
 	//Initialize messagebus
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -134,11 +134,17 @@ func main() {
 	}
 
 	groupsIn := make(chan *databus.DataGroup, 10)
-	dbClient.Subscribe("/prometheus")
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/prometheus", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/prometheus")
 	go dbClient.GetGroup(groupsIn, "/prometheus")
 
 	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewExpvarCollector(map[string]*prometheus.Desc{
+		"malformed_messages": prometheus.NewDesc("idrac_telemetry_malformed_messages",
+			"Messages that could not be parsed and were moved to the dead letter queue", []string{"queue"}, nil),
+		"messagebus_connected": prometheus.NewDesc("idrac_telemetry_messagebus_connected",
+			"1 while the message bus connection to the broker is up, 0 while it is reconnecting", []string{"broker"}, nil),
+	}))
 	go handleGroups(groupsIn, registry)
 
 	gatherer := prometheus.Gatherer(registry)
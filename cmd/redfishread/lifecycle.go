@@ -0,0 +1,183 @@
+// Licensed to You under the Apache License, Version 2.0.
+// With INCLUDE_LCE set, the Lifecycle Controller events of a device are read from its SSE stream next to its alerts and
+// sent on the data bus as LifecycleEvents. Events about a NIC port carry the port's statistics as they were when the
+// event came in.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
+)
+
+// StartLceEventListener receives the device's Lifecycle Controller events until it is deleted, reconnecting a failed
+// stream with backoff. Devices without an SSE stream have no Lifecycle Controller events to offer.
+func (r *RedfishDevice) StartLceEventListener(dataBusService *databus.DataBusService) {
+	if r.LceEvents == nil {
+		r.LceEvents = make(chan *redfish.RedfishEvent, 10)
+	}
+	log.Printf("%s: Starting lifecycle event listener...\n", r.SystemID)
+	var retry backoff
+	cancel := r.startStream(r.listenForLceEvents)
+	for {
+		var event *redfish.RedfishEvent
+		select {
+		case event = <-r.LceEvents:
+		case <-r.Ctx.Done():
+			cancel()
+			return
+		}
+		if event == nil {
+			log.Printf("%s: Got SSE nil event \n", r.SystemID)
+			continue
+		}
+		if errors.Is(event.Err, redfish.ErrNoSSE) {
+			log.Printf("%s: No SSE stream, not listening for lifecycle events\n", r.SystemID)
+			cancel()
+			return
+		}
+		if event.Err != nil {
+			log.Printf("%s: Got SSE error %s\n", r.SystemID, event.Err)
+			r.recordStreamError(event.Err)
+			cancel()
+			delay := retry.next()
+			log.Printf("%s: Reconnecting lifecycle event stream in %s\n", r.SystemID, delay.Round(time.Second))
+			if !r.sleep(delay) {
+				return
+			}
+			cancel = r.startStream(r.listenForLceEvents)
+			continue
+		}
+		retry.reset()
+		r.recordAlive()
+		if event.Payload != nil {
+			if ot, ok := event.Payload.Object["@odata.type"].(string); ok && strings.Contains(ot, ".Event") {
+				log.Printf("%s: Got new lifecycle event\n", r.SystemID)
+				parseRedfishLce(event.Payload, r, dataBusService)
+				continue
+			}
+		}
+		log.Printf("%s: Got bad SSE event \n", r.SystemID)
+		r.recordParseError(errors.New("bad SSE event"))
+	}
+}
+
+// listenForLceEvents takes the Lifecycle Controller events from the SSE stream whatever the event mode, as they cannot
+// be told apart from the alerts in a push subscription
+func (r *RedfishDevice) listenForLceEvents(ctx context.Context) {
+	r.Redfish.ListenForLceEvents(ctx, r.LceEvents)
+}
+
+// parseRedfishLce sends the events of an SSE event record on the data bus as LifecycleEvents
+func parseRedfishLce(lceevents *redfish.RedfishPayload, r *RedfishDevice, dataBusService *databus.DataBusService) {
+	events, ok := lceevents.Object["Events"].([]interface{})
+	if !ok {
+		log.Printf("%s: Unable to get lifecycle eventData: %v", r.SystemID, lceevents.Object)
+		r.recordParseError(errors.New("lifecycle event record has no Events"))
+		return
+	}
+
+	group := r.newGroup()
+	group.ID, _ = lceevents.Object["Id"].(string)
+	group.Label, _ = lceevents.Object["Name"].(string)
+	for _, value := range events {
+		eventData, ok := value.(map[string]interface{})
+		if !ok || eventData["EventId"] == nil {
+			continue
+		}
+		data := databus.LifecycleEvent{}
+		data.EventId, _ = eventData["EventId"].(string)
+		data.EventType, _ = eventData["EventType"].(string)
+		data.EventTimestamp, _ = eventData["EventTimestamp"].(string)
+		if data.EventTimestamp == "" {
+			data.EventTimestamp = time.Now().Format(time.RFC3339)
+		}
+		data.MessageSeverity, _ = eventData["MessageSeverity"].(string)
+		data.Message, _ = eventData["Message"].(string)
+		data.MessageId, _ = eventData["MessageId"].(string)
+		if args, ok := eventData["MessageArgs"].([]interface{}); ok {
+			for _, a := range args {
+				if arg, ok := a.(string); ok {
+					data.MessageArgs = append(data.MessageArgs, arg)
+				}
+			}
+		}
+		if origin, ok := eventData["OriginOfCondition"].(map[string]interface{}); ok {
+			data.OriginOfCondition, _ = origin["@odata.id"].(string)
+		}
+		if strings.HasPrefix(data.OriginOfCondition, configStrings["inventoryurl"]+"/NetworkAdapters/") {
+			data.NIC = r.nicStats(data.OriginOfCondition)
+		}
+		data.Sled, data.Slot = r.sledOf(append([]string{data.OriginOfCondition}, data.MessageArgs...)...)
+		group.LifecycleEvents = append(group.LifecycleEvents, data)
+	}
+	r.recordEvents(group.ID, len(group.LifecycleEvents))
+	dataBusService.SendGroup(*group)
+
+	dataGroupsMu.Lock()
+	if dataGroups[r.SystemID] == nil {
+		dataGroups[r.SystemID] = make(map[string]*databus.DataGroup)
+	}
+	dataGroups[r.SystemID][group.ID] = group
+	dataGroupsMu.Unlock()
+}
+
+// nicStats reads the Dell OEM settings and port metrics of the NIC function at uri, or returns nil if it has none
+func (r *RedfishDevice) nicStats(uri string) *databus.NICStats {
+	function, err := r.Redfish.GetUri(uri)
+	if err != nil {
+		log.Printf("%s: Unable to get %s: %v\n", r.SystemID, uri, err)
+		return nil
+	}
+	oem, err := function.GetPropertyByName("Oem")
+	if err != nil {
+		return nil
+	}
+	dell, err := oem.GetPropertyByName("Dell")
+	if err != nil {
+		return nil
+	}
+	ret := new(databus.NICStats)
+	found := false
+	if nic, err := dell.GetPropertyByName("DellNIC"); err == nil {
+		found = true
+		ret.MaxBandwidthPercent, _ = nic.Object["MaxBandwidthPercent"].(float64)
+		ret.MinBandwidthPercent, _ = nic.Object["MinBandwidthPercent"].(float64)
+	}
+	if metrics, err := dell.GetPropertyByName("DellNICPortMetrics"); err == nil {
+		found = true
+		counter := func(names ...string) float64 {
+			for _, name := range names {
+				if value, ok := metrics.Object[name].(float64); ok {
+					return value
+				}
+			}
+			return 0
+		}
+		ret.ID, _ = metrics.Object["@odata.id"].(string)
+		ret.DiscardedPkts = counter("DiscardedPkts")
+		ret.RxBroadcast = counter("RxBroadcast")
+		ret.RxBytes = counter("RxBytes")
+		ret.RxErrorPktAlignmentErrors = counter("RxErrorPktAlignmentErrors")
+		// iDRACs misspell the multicast counters
+		ret.RxMulticastPackets = counter("RxMutlicastPackets", "RxMulticastPackets")
+		ret.RxUnicastPackets = counter("RxUnicastPackets")
+		ret.TxBroadcast = counter("TxBroadcast")
+		ret.TxBytes = counter("TxBytes")
+		ret.TxMulticastPackets = counter("TxMutlicastPackets", "TxMulticastPackets")
+		ret.TxUnicastPackets = counter("TxUnicastPackets")
+	}
+	if !found {
+		return nil
+	}
+	if ret.ID == "" {
+		ret.ID = uri
+	}
+	return ret
+}
@@ -0,0 +1,244 @@
+// Licensed to You under the Apache License, Version 2.0.
+// The poller stands in for the TelemetryService on systems that lack one, reading the sensors and inventory every
+// poll interval and sending them on as if they were metric reports.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
+)
+
+// pollMetric maps a numeric property of the members of a resource's array (e.g. Thermal's Temperatures) to a metric
+type pollMetric struct {
+	array    string
+	property string
+	id       string
+	units    string
+}
+
+// thermalMetrics and powerMetrics are sent as the groups of the iDRAC reports carrying the same readings
+var thermalMetrics = map[string][]pollMetric{
+	"ThermalSensor": {{"Temperatures", "ReadingCelsius", "TemperatureReading", "Cel"}},
+	"FanSensor":     {{"Fans", "Reading", "RPMReading", "{rev}/min"}},
+}
+
+var powerMetrics = map[string][]pollMetric{
+	"PowerMetrics": {{"PowerControl", "PowerConsumedWatts", "SystemInputPower", "W"}},
+	"PSUMetrics": {
+		{"PowerSupplies", "LineInputVoltage", "InputVoltage", "V"},
+		{"PowerSupplies", "PowerInputWatts", "InputPower", "W"},
+		{"PowerSupplies", "PowerOutputWatts", "OutputPower", "W"},
+	},
+	"VoltageSensor": {{"Voltages", "ReadingVolts", "VoltageReading", "V"}},
+}
+
+// sensorMetricIds names the readings of the Sensors collection by ReadingType
+var sensorMetricIds = map[string]string{
+	"Temperature": "TemperatureReading",
+	"Rotational":  "RPMReading",
+	"Voltage":     "VoltageReading",
+	"Current":     "CurrentReading",
+	"Power":       "PowerReading",
+	"Humidity":    "HumidityReading",
+}
+
+// inventoryMetrics are read from every member of a system's Processors and Memory collections
+var inventoryMetrics = map[string][]pollMetric{
+	"Processors": {
+		{"", "OperatingSpeedMHz", "OperatingSpeed", "MHz"},
+		{"", "TotalThreads", "TotalThreads", ""},
+	},
+	"Memory": {
+		{"", "CapacityMiB", "Capacity", "MiBy"},
+		{"", "OperatingSpeedMhz", "OperatingSpeed", "MHz"},
+	},
+}
+
+// pollInterval is how often the device is polled, from its pollinterval setting or POLL_INTERVAL. Both take a
+// duration such as "90s" or a number of seconds.
+func pollInterval(service *auth.Service) time.Duration {
+	for _, setting := range []string{service.Auth[auth.AuthPollInterval], configStrings["pollinterval"]} {
+		if setting == "" {
+			continue
+		}
+		if interval, ok := parseInterval(setting); ok {
+			return interval
+		}
+		log.Printf("%s: Ignoring bad poll interval %q", service.Ip, setting)
+	}
+	return time.Minute
+}
+
+// parseInterval parses a positive duration such as "90s" or a number of seconds
+func parseInterval(setting string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(setting); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if interval, err := time.ParseDuration(setting); err == nil && interval > 0 {
+		return interval, true
+	}
+	return 0, false
+}
+
+// StartPoller polls the device every PollInterval until its context is cancelled
+func (r *RedfishDevice) StartPoller(dataBusService *databus.DataBusService) {
+	log.Printf("%s: Polling every %s...\n", r.SystemID, r.PollInterval)
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		r.poll(dataBusService)
+		select {
+		case <-ticker.C:
+		case <-r.Ctx.Done():
+			return
+		}
+	}
+}
+
+// poll reads every chassis and system once and sends what it found
+func (r *RedfishDevice) poll(dataBusService *databus.DataBusService) {
+	timestamp := time.Now().Format(time.RFC3339)
+	groups := make(map[string]*databus.DataGroup)
+	add := func(groupID string, value *databus.DataValue) {
+		group, ok := groups[groupID]
+		if !ok {
+			group = r.newGroup()
+			group.ID = groupID
+			group.Label = groupID
+			group.Timestamp = timestamp
+			groups[groupID] = group
+		}
+		value.System = r.SystemID
+		value.HostName = r.HostName
+		value.Timestamp = timestamp
+		group.Values = append(group.Values, *value)
+	}
+
+	chassisCollection, err := r.Redfish.GetUri("/redfish/v1/Chassis")
+	if err != nil {
+		r.recordConnectError(err)
+		return
+	}
+	r.recordAlive()
+	r.forEachMember(chassisCollection, func(chassis *redfish.RedfishPayload) {
+		if thermal, err := r.getLink(chassis, "Thermal"); err == nil {
+			addArrayMetrics(thermal, thermalMetrics, add)
+		}
+		if power, err := r.getLink(chassis, "Power"); err == nil {
+			addArrayMetrics(power, powerMetrics, add)
+		}
+		if sensors, err := r.getLink(chassis, "Sensors"); err == nil {
+			r.forEachMember(sensors, func(sensor *redfish.RedfishPayload) {
+				id := sensorMetricIds[fmt.Sprint(sensor.Object["ReadingType"])]
+				if id == "" {
+					return
+				}
+				if value := newPolledValue(sensor.Object, "Reading", id); value != nil {
+					value.Units, _ = sensor.Object["ReadingUnits"].(string)
+					add("Sensor", value)
+				}
+			})
+		}
+	})
+
+	systemCollection, err := r.Redfish.GetUri("/redfish/v1/Systems")
+	if err == nil {
+		r.forEachMember(systemCollection, func(system *redfish.RedfishPayload) {
+			for groupID, metrics := range inventoryMetrics {
+				collection, err := r.getLink(system, groupID)
+				if err != nil {
+					continue
+				}
+				r.forEachMember(collection, func(member *redfish.RedfishPayload) {
+					for _, metric := range metrics {
+						if value := newPolledValue(member.Object, metric.property, metric.id); value != nil {
+							value.Units = metric.units
+							add(groupID, value)
+						}
+					}
+				})
+			}
+		})
+	}
+
+	for _, group := range groups {
+		r.publish(group, dataBusService)
+	}
+}
+
+// getLink follows the link in property name of payload, expanded if the service can expand it
+func (r *RedfishDevice) getLink(payload *redfish.RedfishPayload, name string) (*redfish.RedfishPayload, error) {
+	link, ok := payload.Object[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("No such element %s", name)
+	}
+	uri, ok := link["@odata.id"].(string)
+	if !ok {
+		return payload.GetPropertyByName(name)
+	}
+	expanded, err := r.Redfish.GetUri(uri + "?$expand=.($levels=1)")
+	if err == nil {
+		return expanded, nil
+	}
+	return r.Redfish.GetUri(uri)
+}
+
+// forEachMember calls member for every member of collection that can be read
+func (r *RedfishDevice) forEachMember(collection *redfish.RedfishPayload, member func(*redfish.RedfishPayload)) {
+	members, _ := collection.Object["Members"].([]interface{})
+	for i := range members {
+		payload, err := collection.GetPropertyByIndex(i)
+		if err != nil {
+			log.Printf("%s: Unable to get member %d of %v: %v", r.SystemID, i, collection.Object["@odata.id"], err)
+			continue
+		}
+		member(payload)
+	}
+}
+
+// addArrayMetrics adds the metrics found in the arrays of resource to their groups
+func addArrayMetrics(resource *redfish.RedfishPayload, groups map[string][]pollMetric, add func(string, *databus.DataValue)) {
+	for groupID, metrics := range groups {
+		for _, metric := range metrics {
+			members, _ := resource.Object[metric.array].([]interface{})
+			for _, member := range members {
+				member, ok := member.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if value := newPolledValue(member, metric.property, metric.id); value != nil {
+					value.Units = metric.units
+					add(groupID, value)
+				}
+			}
+		}
+	}
+}
+
+// newPolledValue makes a value of property of object, which is a sensor or component, or returns nil if it has no
+// reading. Like the iDRAC reports, the context is the component and the label combines the component and the metric.
+func newPolledValue(object map[string]interface{}, property string, id string) *databus.DataValue {
+	reading, ok := object[property].(float64)
+	if !ok {
+		return nil
+	}
+	value := new(databus.DataValue)
+	value.ID = id
+	for _, name := range []string{"Name", "MemberId", "Id"} {
+		if context, ok := object[name].(string); ok && context != "" {
+			value.Context = context
+			break
+		}
+	}
+	value.Label = value.Context + " " + id
+	value.Value = strconv.FormatFloat(reading, 'f', -1, 64)
+	value.ParseValue("")
+	return value
+}
@@ -0,0 +1,122 @@
+// Licensed to You under the Apache License, Version 2.0.
+// Pull mode is for devices behind firewalls that kill long-lived connections: instead of holding an SSE connection,
+// redfishread GETs every enabled metric report at its recurrence interval and hands on those it has not seen yet.
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
+)
+
+// pullRefresh is how often the report definitions are read again, so reports enabled or rescheduled meanwhile are
+// picked up
+const pullRefresh = 10 * time.Minute
+
+// pulledReport is when a report is due next and what it looked like when last read
+type pulledReport struct {
+	interval time.Duration
+	due      time.Time
+	last     string
+}
+
+// StartPuller reads the device's enabled metric reports on their schedules until its context is cancelled. Reports
+// without a recurrence interval are read every PollInterval.
+func (r *RedfishDevice) StartPuller(dataBusService *databus.DataBusService) {
+	log.Printf("%s: Pulling metric reports...\n", r.SystemID)
+	reports := make(map[string]*pulledReport)
+	var refreshed time.Time
+	for {
+		if time.Since(refreshed) >= pullRefresh {
+			if r.schedulePulls(reports) {
+				refreshed = time.Now()
+			}
+		}
+
+		next := time.Now().Add(pullRefresh)
+		for id, report := range reports {
+			if !time.Now().Before(report.due) {
+				r.pull(id, report, dataBusService)
+				report.due = report.due.Add(report.interval)
+				if report.due.Before(time.Now()) {
+					// fell behind, skip what was missed rather than reading the report over and over
+					report.due = time.Now().Add(report.interval)
+				}
+			}
+			if report.due.Before(next) {
+				next = report.due
+			}
+		}
+		if refreshed.IsZero() {
+			// the definitions could not be read, try again soon
+			next = time.Now().Add(r.PollInterval)
+		}
+		if !r.sleep(time.Until(next)) {
+			return
+		}
+	}
+}
+
+// schedulePulls brings reports in line with the enabled report definitions, keeping the state of those already
+// scheduled. It returns false if the definitions could not be read.
+func (r *RedfishDevice) schedulePulls(reports map[string]*pulledReport) bool {
+	defs, err := r.Redfish.GetMetricReportDefinitions()
+	if err != nil {
+		log.Printf("%s: Unable to get metric report definitions: %v\n", r.SystemID, err)
+		r.recordConnectError(err)
+		return false
+	}
+	enabled := make(map[string]bool)
+	for _, payload := range defs {
+		def := reportDefinition(payload)
+		if def.ID == "" || !def.Enabled {
+			continue
+		}
+		enabled[def.ID] = true
+		interval, err := databus.ParseRecurrenceInterval(def.RecurrenceInterval)
+		if err != nil || interval <= 0 {
+			interval = r.PollInterval
+		}
+		report, ok := reports[def.ID]
+		if !ok {
+			report = &pulledReport{due: time.Now()}
+			reports[def.ID] = report
+		}
+		report.interval = interval
+	}
+	for id := range reports {
+		if !enabled[id] {
+			delete(reports, id)
+		}
+	}
+	log.Printf("%s: Pulling %d metric reports\n", r.SystemID, len(reports))
+	return true
+}
+
+// pull reads report id and parses it unless it is the one read last time, going by its ReportSequence or else its
+// Timestamp
+func (r *RedfishDevice) pull(id string, report *pulledReport, dataBusService *databus.DataBusService) {
+	metricReport, err := r.Redfish.GetUri(redfish.MetricReportsUri + "/" + id)
+	if err != nil {
+		log.Printf("%s: Unable to get %s report: %v\n", r.SystemID, id, err)
+		r.recordConnectError(err)
+		return
+	}
+	r.recordAlive()
+	version, _ := metricReport.Object["Timestamp"].(string)
+	switch seq := metricReport.Object["ReportSequence"].(type) {
+	case string:
+		version = seq
+	case float64:
+		version = strconv.FormatInt(int64(seq), 10)
+	}
+	if version != "" && version == report.last {
+		return
+	}
+	report.last = version
+	parseReport(metricReport, r, dataBusService)
+}
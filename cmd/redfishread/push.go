@@ -0,0 +1,106 @@
+// Licensed to You under the Apache License, Version 2.0.
+// Push mode has the services POST their events to an HTTPS listener in redfishread through EventService
+// subscriptions, instead of redfishread holding an SSE connection to every one of them.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
+)
+
+// pushListener takes the events of the devices in push mode, nil unless PUSH_URL is set
+var pushListener *redfish.PushListener
+
+// startPushListener serves pushListener on PUSH_LISTEN with the PUSH_TLS_CERT and PUSH_TLS_KEY certificate, or a self
+// signed one if they are not set
+func startPushListener() {
+	pushListener = redfish.NewPushListener(configStrings["pushurl"])
+	server := &http.Server{
+		Addr:    configStrings["pushlisten"],
+		Handler: pushListener,
+	}
+	certFile, keyFile := configStrings["pushcert"], configStrings["pushkey"]
+	if certFile == "" || keyFile == "" {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			log.Fatalf("Failed to create a certificate for the push listener: %v", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	go func() {
+		log.Printf("Listening for pushed events on %s as %s", server.Addr, pushListener.URL)
+		err := server.ListenAndServeTLS(certFile, keyFile)
+		log.Fatalf("Push listener failed: %v", err)
+	}()
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "redfishread"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// chooseEventMode settles the event mode of the device: pull if asked for, push if asked for and there is a listener,
+// or if the device cannot do SSE, and SSE otherwise
+func (r *RedfishDevice) chooseEventMode() {
+	if r.EventMode == redfish.PULL {
+		return
+	}
+	if r.EventMode == redfish.PUSH && pushListener == nil {
+		log.Printf("%s: Push mode needs PUSH_URL to be set, using SSE", r.SystemID)
+		r.EventMode = redfish.SSE
+	}
+	if r.EventMode != redfish.PUSH && pushListener != nil {
+		if sse, err := r.Redfish.SupportsSSE(); err == nil && !sse {
+			log.Printf("%s: No SSE support, using push mode", r.SystemID)
+			r.EventMode = redfish.PUSH
+		}
+	}
+	if r.EventMode != redfish.PUSH {
+		r.EventMode = redfish.SSE
+	}
+}
+
+func (r *RedfishDevice) listenForMetricReports(ctx context.Context) {
+	if r.EventMode == redfish.PUSH {
+		r.Redfish.ListenForPush(ctx, r.Metrics, pushListener, redfish.MetricReportFormat)
+		return
+	}
+	r.Redfish.ListenForMetricReports(ctx, r.Metrics)
+}
+
+// listenForAlerts takes alerts the way the device sends them. Alerts cannot be pulled, so in pull mode they are pushed
+// if there is a listener.
+func (r *RedfishDevice) listenForAlerts(ctx context.Context) {
+	if r.EventMode == redfish.PUSH || (r.EventMode == redfish.PULL && pushListener != nil) {
+		r.Redfish.ListenForPush(ctx, r.Events, pushListener, redfish.EventFormat)
+		return
+	}
+	r.Redfish.ListenForAlerts(ctx, r.Events)
+}
@@ -0,0 +1,137 @@
+// Licensed to You under the Apache License, Version 2.0.
+// The listeners reconnect a failed event stream with a jittered exponential backoff, and an idle watchdog reconnects
+// the metric stream when it stays silent for much longer than the device's reports are apart.
+
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// backoff spaces out the reconnects of an event stream. The delay doubles with every failed attempt from reconnectmin
+// up to reconnectmax, and each is drawn at random from the upper half of that so devices that failed together do not
+// all come back at once.
+type backoff struct {
+	attempts int
+}
+
+// durationSetting is the duration in configStrings[name], or def if that is not one
+func durationSetting(name string, def time.Duration) time.Duration {
+	if interval, ok := parseInterval(configStrings[name]); ok {
+		return interval
+	}
+	log.Printf("Ignoring bad %s %q", name, configStrings[name])
+	return def
+}
+
+func (b *backoff) next() time.Duration {
+	min := durationSetting("reconnectmin", 5*time.Second)
+	max := durationSetting("reconnectmax", 5*time.Minute)
+	delay := max
+	if b.attempts < 32 && min<<b.attempts < max {
+		delay = min << b.attempts
+		b.attempts++
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// reset starts over from reconnectmin once the stream delivers again
+func (b *backoff) reset() {
+	b.attempts = 0
+}
+
+// sleep waits for delay, returning false if the device is deleted meanwhile
+func (r *RedfishDevice) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Ctx.Done():
+		return false
+	}
+}
+
+// startStream runs listen with a context of its own, cancelled to drop the stream before opening another
+func (r *RedfishDevice) startStream(listen func(context.Context)) context.CancelFunc {
+	ctx, cancel := context.WithCancel(r.Ctx)
+	r.spawn(func() { listen(ctx) })
+	return cancel
+}
+
+// spawn runs f in a goroutine that stopped waits for. Everything that talks to the device once its Ctx is done, like
+// a push listener deleting its subscription, must be started with it.
+func (r *RedfishDevice) spawn(f func()) {
+	r.routines.Add(1)
+	go func() {
+		defer r.routines.Done()
+		f()
+	}()
+}
+
+// stopped waits up to timeout for the goroutines started with spawn to return after the device's Ctx is done, and
+// reports whether they all did
+func (r *RedfishDevice) stopped(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		r.routines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// idleLimit is how long the metric stream may stay silent before the watchdog reconnects it: idlefactor times the
+// shortest interval of the device's enabled periodic reports, or 0 if there are none or the watchdog is off
+func (r *RedfishDevice) idleLimit() time.Duration {
+	factor, err := strconv.Atoi(configStrings["idlefactor"])
+	if err != nil || factor <= 0 {
+		return 0
+	}
+	return time.Duration(factor) * r.getCatalog().ReportInterval()
+}
+
+// watchdog notices when the metric stream has been silent for the idle limit. Until the limit is known, which it is
+// not before the catalog is loaded, it looks again every minute.
+type watchdog struct {
+	timer *time.Timer
+	since time.Time
+}
+
+func newWatchdog() *watchdog {
+	return &watchdog{timer: time.NewTimer(time.Minute), since: time.Now()}
+}
+
+// feed notes that the stream is alive
+func (w *watchdog) feed(r *RedfishDevice) {
+	w.since = time.Now()
+	w.arm(r)
+}
+
+func (w *watchdog) arm(r *RedfishDevice) {
+	wait := time.Minute
+	if limit := r.idleLimit(); limit > 0 {
+		wait = time.Until(w.since.Add(limit))
+	}
+	w.timer.Reset(wait)
+}
+
+// expired is called when the timer fires. It returns the idle limit if the stream has been silent for that long, and
+// rearms the timer otherwise.
+func (w *watchdog) expired(r *RedfishDevice) (time.Duration, bool) {
+	limit := r.idleLimit()
+	if limit > 0 && time.Since(w.since) >= limit {
+		return limit, true
+	}
+	w.arm(r)
+	return 0, false
+}
@@ -5,26 +5,60 @@ package main
 
 import (
 	"context"
-	//"encoding/json"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
 
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
 )
 
 var configStrings = map[string]string{
 	"mbhost":       "activemq",
 	"mbport":       "61613",
+	"mbtype":       "stomp",
 	"inventoryurl": "/redfish/v1/Chassis/System.Embedded.1",
+	"backfill":     "false",
+	// path to a JSON TelemetryConfig applied to every device when it is added
+	"telemetryprofile": "",
+	// how often devices without a TelemetryService are polled unless their pollinterval setting says otherwise
+	"pollinterval": "60s",
+	// sse, push or pull, unless a device's eventmode setting says otherwise
+	"eventmode": "sse",
+	// push mode is only available when pushurl, the URL devices reach pushlisten under, is set
+	"pushurl":    "",
+	"pushlisten": ":8443",
+	"pushcert":   "",
+	"pushkey":    "",
+	// true to use session authentication for username and password services too
+	"sessionauth": "false",
+	// true to add the iDRACs of an MSM chassis's sleds as devices, with the chassis's sledusername and sledpassword
+	// auth settings or else these
+	"sledautoregister": "false",
+	"sledusername":     "",
+	"sledpassword":     "",
+	// event streams reconnect after a delay doubling from reconnectmin to reconnectmax, and the metric stream is
+	// reconnected when no report came for idlefactor times the shortest report interval, 0 to never do that
+	"reconnectmin": "5s",
+	"reconnectmax": "5m",
+	"idlefactor":   "3",
+	// how certificates of devices without their own cacert, fingerprint or insecure setting are checked
+	"tlscacert":   "",
+	"tlsinsecure": "false",
 }
 
 type SystemDetail struct {
@@ -44,18 +78,52 @@ type RedfishDevice struct {
 	ChildDevices map[int]string
 	Events       chan *redfish.RedfishEvent
 	Metrics      chan *redfish.RedfishEvent
+	LceEvents    chan *redfish.RedfishEvent
 	State        string
 	LastEvent    time.Time
 	CtxCancel    context.CancelFunc
 	Ctx          context.Context
+	// routines are the goroutines reading the device, which stop once Ctx is done
+	routines sync.WaitGroup
+	// PollInterval is how often the device is polled if it has no TelemetryService
+	PollInterval time.Duration
+	// EventMode is redfish.SSE, redfish.PUSH or redfish.PULL
+	EventMode string
+
+	// service is what the auth service sent for the device
+	service *auth.Service
+
+	catalog   *databus.MetricCatalog
+	catalogMu sync.RWMutex
+
+	// statsMu guards LastEvent and the health statistics reported through GETPRODUCERS
+	statsMu        sync.Mutex
+	connectedSince time.Time
+	reports        map[string]int
+	events         map[string]int
+	parseErrors    int
+	reconnects     int
+	lastError      string
+	lastErrorTime  time.Time
+	sequences      map[string]reportSequence
+	gaps           int
+	missedReports  int
+	duplicates     int
 }
 
+// stopTimeout is how long a deleted device's listeners get to stop, and delete their push subscriptions, before its
+// session is logged out
+const stopTimeout = 30 * time.Second
+
 var devices map[string]*RedfishDevice
+var devicesMu sync.RWMutex
+var telemetryProfile *databus.TelemetryConfig
 var dataGroups map[string]map[string]*databus.DataGroup
 var dataGroupsMu sync.RWMutex
 
 // populateChildChassis If the device is a chassis, we also have to obtain IDs / info for all children in that chassis
 // and pull telemetry on them. This function will expand the chassis information and obtain the necessary information.
+// With SLED_AUTO_REGISTER set the sleds' iDRACs are added as devices too.
 func populateChildChassis(r *RedfishDevice, serviceRoot *redfish.RedfishPayload) {
 	chassisCollection, err := serviceRoot.GetPropertyByName("Chassis")
 	if err != nil {
@@ -68,57 +136,159 @@ func populateChildChassis(r *RedfishDevice, serviceRoot *redfish.RedfishPayload)
 		if err != nil {
 			continue
 		}
-		if chassis.Object["ChassisType"].(string) != "Enclosure" && chassis.Object["SKU"] != nil {
-			name := chassis.Object["Name"].(string)
+		chassisType, _ := chassis.Object["ChassisType"].(string)
+		sku, _ := chassis.Object["SKU"].(string)
+		if chassisType != "Enclosure" && sku != "" {
+			name, _ := chassis.Object["Name"].(string)
 			if strings.HasPrefix(name, "Sled-") {
 				split := strings.Split(name, "-")
-				i, _ := strconv.Atoi(split[1])
-				r.ChildDevices[i] = chassis.Object["SKU"].(string)
+				slot, _ := strconv.Atoi(split[1])
+				r.ChildDevices[slot] = sku
+				log.Printf("%s: Found sled %d with service tag %s\n", r.SystemID, slot, sku)
+				if configStrings["sledautoregister"] == "true" {
+					r.spawn(func() { r.registerSled(slot, chassis) })
+				}
 			}
 		}
 	}
 }
 
+// getValueIdContextAndLabel names the i-th value of a metric report by the rules of the profile of the service it
+// came from
 func getValueIdContextAndLabel(value *redfish.RedfishPayload, i int) (string, string, string) {
-	id := ""
-	if value.Object["MetricId"] != nil {
-		id = value.Object["MetricId"].(string)
-		if id == "" && value.Object["MetricProperty"] != nil {
-			id = value.Object["MetricProperty"].(string)
-			//get last part of MP, /abc/def#ghi => def_ghi
-			li := strings.LastIndex(id, "/")
-			if li != -1 {
-				id = id[li+1:]
-			}
-			id = strings.ReplaceAll(id, "#", "_")
-		}
-	}
+	id, context, label := value.Client.Profile().ValueIdentity(value.Object)
 	if id == "" {
 		id = fmt.Sprintf("Metric%d", i)
+		if label == "" {
+			label = id
+		}
 	}
+	return id, context, label
+}
 
-	if value.Object["Oem"] != nil {
-		oem := value.Object["Oem"].(map[string]interface{})
-		if oem["Dell"] != nil {
-			dell := oem["Dell"].(map[string]interface{})
-			if dell["ContextID"] != nil && dell["Label"] != nil {
-				return id, dell["ContextID"].(string), dell["Label"].(string)
-			}
-		}
+// recordAlive notes that the event stream delivered something
+func (r *RedfishDevice) recordAlive() {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.LastEvent = time.Now()
+	if r.connectedSince.IsZero() {
+		r.connectedSince = r.LastEvent
 	}
-	return id, "", id
+}
+
+func (r *RedfishDevice) recordReport(id string) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if r.reports == nil {
+		r.reports = make(map[string]int)
+	}
+	r.reports[id]++
+}
+
+func (r *RedfishDevice) recordEvents(id string, count int) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if r.events == nil {
+		r.events = make(map[string]int)
+	}
+	r.events[id] += count
+}
+
+func (r *RedfishDevice) recordParseError(err error) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.parseErrors++
+	r.lastError = err.Error()
+	r.lastErrorTime = time.Now()
+}
+
+// recordConnectError notes why the device could not be connected to or read. Unlike recordStreamError it leaves the
+// reconnect count alone, as there is no stream to reconnect.
+func (r *RedfishDevice) recordConnectError(err error) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.lastError = err.Error()
+	r.lastErrorTime = time.Now()
+}
+
+// recordStreamError notes that the event stream failed and is about to be reconnected
+func (r *RedfishDevice) recordStreamError(err error) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.reconnects++
+	r.connectedSince = time.Time{}
+	r.lastError = err.Error()
+	r.lastErrorTime = time.Now()
+}
+
+// producer returns the device as GETPRODUCERS reports it
+func (r *RedfishDevice) producer() *databus.DataProducer {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	ret := new(databus.DataProducer)
+	ret.Hostname = r.Redfish.Hostname
+	ret.Username = r.Redfish.Username
+	ret.State = r.State
+	ret.LastEvent = r.LastEvent
+	ret.Model = r.Model
+	ret.FwVer = r.FwVer
+	if r.State != databus.CONNFAILED && r.State != databus.TLSFAILED {
+		ret.Platform = r.Redfish.Profile().Name()
+	}
+	ret.ConnectedSince = r.connectedSince
+	ret.Reports = make(map[string]int, len(r.reports))
+	for id, count := range r.reports {
+		ret.Reports[id] = count
+	}
+	ret.Events = make(map[string]int, len(r.events))
+	for id, count := range r.events {
+		ret.Events[id] = count
+	}
+	ret.ParseErrors = r.parseErrors
+	ret.Reconnects = r.reconnects
+	ret.LastError = r.lastError
+	ret.LastErrorTime = r.lastErrorTime
+	ret.SequenceGaps = r.gaps
+	ret.MissedReports = r.missedReports
+	ret.DuplicateReports = r.duplicates
+	return ret
 }
 
 // Responsible for taking the report received from SSE, getting its component parts, and then sending it along the
-// data bus
+// data bus. Reports seen before are dropped, and when reports went missing since the last one the gap is backfilled
+// first if BACKFILL_ON_GAP is set.
 func parseReport(metricReport *redfish.RedfishPayload, r *RedfishDevice, dataBusService *databus.DataBusService) {
-	metricValues, err := metricReport.GetPropertyByName("MetricValues")
-	if err != nil {
-		log.Printf("%s: Unable to get metric report's MetricValues: %v %v", r.SystemID, err, metricReport)
+	group := buildGroup(metricReport, r)
+	if group == nil {
 		return
 	}
-	group := new(databus.DataGroup)
+	duplicate, gapSince := r.checkSequence(group)
+	if duplicate {
+		log.Printf("%s: Dropping duplicate %s report, sequence %s\n", r.SystemID, group.ID, group.Sequence)
+		return
+	}
+	if gapSince != "" && configStrings["backfill"] == "true" {
+		r.backfill(group, gapSince, dataBusService)
+	}
+	r.publish(group, dataBusService)
+}
+
+// publish sends group to the subscribers and keeps it as the latest of its ID for GET
+func (r *RedfishDevice) publish(group *databus.DataGroup, dataBusService *databus.DataBusService) {
+	r.recordReport(group.ID)
+	dataBusService.SendGroup(*group)
 
+	dataGroupsMu.Lock()
+	if dataGroups[r.SystemID] == nil {
+		dataGroups[r.SystemID] = make(map[string]*databus.DataGroup)
+	}
+	dataGroups[r.SystemID][group.ID] = group
+	dataGroupsMu.Unlock()
+}
+
+// newGroup returns an empty DataGroup describing the device
+func (r *RedfishDevice) newGroup() *databus.DataGroup {
+	group := new(databus.DataGroup)
 	group.HostName = r.HostName
 	group.FQDN = r.FQDN
 	group.System = r.SystemID
@@ -126,39 +296,365 @@ func parseReport(metricReport *redfish.RedfishPayload, r *RedfishDevice, dataBus
 	group.SKU = r.SKU
 	group.FwVer = r.FwVer
 	group.ImgID = r.ImgID
-	group.ID = metricReport.Object["Id"].(string)
-	group.Label = metricReport.Object["Name"].(string)
-	group.Timestamp = metricReport.Object["Timestamp"].(string)
+	return group
+}
+
+// buildGroup turns a metric report into a DataGroup, or returns nil if it cannot be parsed
+func buildGroup(metricReport *redfish.RedfishPayload, r *RedfishDevice) *databus.DataGroup {
+	metricValues, err := metricReport.GetPropertyByName("MetricValues")
+	if err != nil {
+		log.Printf("%s: Unable to get metric report's MetricValues: %v %v", r.SystemID, err, metricReport)
+		r.recordParseError(err)
+		return nil
+	}
+	group := r.newGroup()
+	group.ID, _ = metricReport.Object["Id"].(string)
+	if group.ID == "" {
+		log.Printf("%s: Metric report has no Id: %v", r.SystemID, metricReport)
+		r.recordParseError(errors.New("metric report has no Id"))
+		return nil
+	}
+	group.Label, _ = metricReport.Object["Name"].(string)
+	group.Timestamp, _ = metricReport.Object["Timestamp"].(string)
+	switch seq := metricReport.Object["ReportSequence"].(type) {
+	case string:
+		group.Sequence = seq
+	case float64:
+		group.Sequence = strconv.FormatInt(int64(seq), 10)
+	}
 	valuesSize := metricValues.GetArraySize()
 	for j := 0; j < valuesSize; j++ {
 		metricValue, err := metricValues.GetPropertyByIndex(j)
 		if err != nil {
 			log.Printf("Unable to get metric report MetricValue %d: %v", j, err)
+			r.recordParseError(err)
 			continue
 		}
 		if metricValue.Object["MetricValue"] != nil {
 			data := new(databus.DataValue)
 			data.ID, data.Context, data.Label = getValueIdContextAndLabel(metricValue, j)
-			data.Value = metricValue.Object["MetricValue"].(string)
-			if metricValue.Object["Timestamp"] == nil {
+			switch v := metricValue.Object["MetricValue"].(type) {
+			case string:
+				data.Value = v
+			default:
+				// not all services send the value as a string
+				data.Value = fmt.Sprint(v)
+			}
+			r.getCatalog().Describe(data)
+			data.Timestamp, _ = metricValue.Object["Timestamp"].(string)
+			if data.Timestamp == "" {
 				t := time.Now()
 				data.Timestamp = t.Format("2006-01-02T15:04:05-0700")
-			} else {
-				data.Timestamp = metricValue.Object["Timestamp"].(string)
 			}
 			data.System = r.SystemID
 			data.HostName = r.HostName
+			r.tagValue(data, metricValue)
 			group.Values = append(group.Values, *data)
 		}
 	}
-	dataBusService.SendGroup(*group)
+	return group
+}
 
-	dataGroupsMu.Lock()
-	if dataGroups[r.SystemID] == nil {
-		dataGroups[r.SystemID] = make(map[string]*databus.DataGroup)
+// loadCatalog reads the metric and report definitions of the device so parseReport can type the values, and sends
+// them to the subscribers. GETCATALOG hands them out on request. They are read again only when the firmware version
+// changes. Reports parsed before this finishes just go without units.
+func (r *RedfishDevice) loadCatalog(dataBusService *databus.DataBusService) {
+	if old := r.getCatalog(); old != nil && old.FwVer == r.FwVer {
+		return
+	}
+	catalog := new(databus.MetricCatalog)
+	catalog.System = r.SystemID
+	catalog.HostName = r.HostName
+	catalog.Model = r.Model
+	catalog.FwVer = r.FwVer
+	catalog.Loaded = time.Now()
+	catalog.MetricDefinitions = make(map[string]databus.MetricDefinition)
+	catalog.ReportDefinitions = make(map[string]databus.MetricReportDefinition)
+
+	r.getCollection("/redfish/v1/TelemetryService/MetricDefinitions?$expand=*($levels=1)", func(def *redfish.RedfishPayload) {
+		ret := databus.MetricDefinition{}
+		ret.ID, _ = def.Object["Id"].(string)
+		ret.Name, _ = def.Object["Name"].(string)
+		ret.Description, _ = def.Object["Description"].(string)
+		ret.MetricType, _ = def.Object["MetricType"].(string)
+		ret.DataType, _ = def.Object["MetricDataType"].(string)
+		ret.Units, _ = def.Object["Units"].(string)
+		ret.Accuracy, _ = def.Object["Accuracy"].(float64)
+		if ret.ID != "" {
+			catalog.MetricDefinitions[ret.ID] = ret
+		}
+	})
+	r.getCollection(redfish.MetricReportDefinitionsUri+"?$expand=*($levels=1)", func(def *redfish.RedfishPayload) {
+		ret := reportDefinition(def)
+		if ret.ID != "" {
+			catalog.ReportDefinitions[ret.ID] = ret
+		}
+	})
+	log.Printf("%s: Loaded %d metric definitions and %d report definitions\n", r.SystemID,
+		len(catalog.MetricDefinitions), len(catalog.ReportDefinitions))
+
+	r.catalogMu.Lock()
+	r.catalog = catalog
+	r.catalogMu.Unlock()
+	dataBusService.SendCatalog(*catalog)
+}
+
+// reportDefinition picks what the catalog keeps out of a Redfish MetricReportDefinition
+func reportDefinition(def *redfish.RedfishPayload) databus.MetricReportDefinition {
+	ret := databus.MetricReportDefinition{}
+	ret.ID, _ = def.Object["Id"].(string)
+	ret.Name, _ = def.Object["Name"].(string)
+	ret.Description, _ = def.Object["Description"].(string)
+	ret.Type, _ = def.Object["MetricReportDefinitionType"].(string)
+	if schedule, ok := def.Object["Schedule"].(map[string]interface{}); ok {
+		ret.RecurrenceInterval, _ = schedule["RecurrenceInterval"].(string)
+	}
+	if enabled, ok := def.Object["MetricReportDefinitionEnabled"].(bool); ok {
+		ret.Enabled = enabled
+	} else if status, ok := def.Object["Status"].(map[string]interface{}); ok {
+		ret.Enabled = status["State"] == "Enabled"
+	}
+	metrics, _ := def.Object["Metrics"].([]interface{})
+	for _, metric := range metrics {
+		metric, _ := metric.(map[string]interface{})
+		if id, _ := metric["MetricId"].(string); id != "" {
+			ret.Metrics = append(ret.Metrics, id)
+		}
+	}
+	return ret
+}
+
+// getCollection calls member for every member of the collection at uri
+func (r *RedfishDevice) getCollection(uri string, member func(*redfish.RedfishPayload)) {
+	collection, err := r.Redfish.GetUri(uri)
+	if err != nil {
+		log.Printf("%s: Unable to get %s: %v", r.SystemID, uri, err)
+		return
+	}
+	size := collection.GetCollectionSize()
+	for i := 0; i < size; i++ {
+		// members that were not expanded are fetched one by one
+		payload, err := collection.GetPropertyByIndex(i)
+		if err != nil {
+			log.Printf("%s: Unable to get member %d of %s: %v", r.SystemID, i, uri, err)
+			continue
+		}
+		member(payload)
 	}
-	dataGroups[r.SystemID][group.ID] = group
-	dataGroupsMu.Unlock()
+}
+
+func (r *RedfishDevice) getCatalog() *databus.MetricCatalog {
+	r.catalogMu.RLock()
+	defer r.catalogMu.RUnlock()
+	return r.catalog
+}
+
+// telemetryState reads the TelemetryService state and the report definitions of the device, and refreshes the
+// catalog's copy of the latter, sending the catalog to the subscribers again if they changed
+func (r *RedfishDevice) telemetryState(dataBusService *databus.DataBusService) *databus.TelemetryState {
+	ret := new(databus.TelemetryState)
+	ret.ServiceIP = r.Redfish.Hostname
+	ret.Reports = []databus.MetricReportDefinition{}
+	enabled, err := r.Redfish.GetTelemetryServiceEnabled()
+	if err != nil {
+		ret.Errors = append(ret.Errors, err.Error())
+	}
+	ret.ServiceEnabled = enabled
+	defs, err := r.Redfish.GetMetricReportDefinitions()
+	if err != nil {
+		ret.Errors = append(ret.Errors, err.Error())
+		return ret
+	}
+	reports := make(map[string]databus.MetricReportDefinition, len(defs))
+	for _, def := range defs {
+		report := reportDefinition(def)
+		ret.Reports = append(ret.Reports, report)
+		reports[report.ID] = report
+	}
+
+	var changed *databus.MetricCatalog
+	r.catalogMu.Lock()
+	if r.catalog != nil && !reflect.DeepEqual(r.catalog.ReportDefinitions, reports) {
+		catalog := *r.catalog
+		catalog.ReportDefinitions = reports
+		r.catalog = &catalog
+		changed = &catalog
+	}
+	r.catalogMu.Unlock()
+	if changed != nil {
+		dataBusService.SendCatalog(*changed)
+	}
+	return ret
+}
+
+// applyTelemetry brings the device to the telemetry state in config, changing only what differs, and returns the
+// state it is left in
+func (r *RedfishDevice) applyTelemetry(config *databus.TelemetryConfig,
+	dataBusService *databus.DataBusService) *databus.TelemetryState {
+	state := r.telemetryState(dataBusService)
+	if len(state.Errors) > 0 {
+		return state
+	}
+	errs := []string{}
+	if config.ServiceEnabled != nil && *config.ServiceEnabled != state.ServiceEnabled {
+		log.Printf("%s: Setting TelemetryService enabled to %v\n", r.SystemID, *config.ServiceEnabled)
+		err := r.Redfish.SetTelemetryServiceEnabled(*config.ServiceEnabled)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, change := range config.ReportChanges(state.Reports) {
+		log.Printf("%s: Changing report %s (enabled %v, interval %q)\n", r.SystemID, change.ID,
+			change.Enabled != nil && *change.Enabled, change.RecurrenceInterval)
+		err := r.Redfish.SetMetricReportDefinition(change.ID, change.Enabled, change.RecurrenceInterval)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	state = r.telemetryState(dataBusService)
+	state.Errors = append(errs, state.Errors...)
+	return state
+}
+
+// loadTelemetryProfile reads the TelemetryConfig every device is brought to when it is added
+func loadTelemetryProfile(path string) (*databus.TelemetryConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := new(databus.TelemetryConfig)
+	err = json.Unmarshal(data, ret)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ret, nil
+}
+
+// duplicateWindow is how far back a ReportSequence may be and still be taken for a re-delivered report. Anything
+// further back means the sequence started over, e.g. because the iDRAC rebooted.
+const duplicateWindow = 16
+
+// reportSequence is the last report received for a report ID
+type reportSequence struct {
+	seq       int64
+	timestamp string
+}
+
+// sequenceCheck is how a report's ReportSequence relates to the last one received for the same report
+type sequenceCheck int
+
+const (
+	sequenceNext      sequenceCheck = iota // the report follows the last one
+	sequenceGap                            // reports were skipped
+	sequenceDuplicate                      // the report was received before
+	sequenceRestart                        // the sequence started over
+)
+
+// compareSequence compares a report with seq and timestamp to last. A sequence that goes back is only a duplicate
+// if it is within duplicateWindow and the report is no newer than last, a newer report means the sequence started
+// over, even if it did so soon after the previous restart.
+func compareSequence(last reportSequence, seq int64, timestamp string) sequenceCheck {
+	switch {
+	case seq > last.seq+1:
+		return sequenceGap
+	case seq > last.seq:
+		return sequenceNext
+	case last.seq-seq < duplicateWindow && !newerTimestamp(timestamp, last.timestamp):
+		return sequenceDuplicate
+	default:
+		return sequenceRestart
+	}
+}
+
+// newerTimestamp reports whether timestamp a is later than b. Timestamps that are not RFC3339 are compared as
+// strings, which orders those of the same format.
+func newerTimestamp(a string, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA == nil && errB == nil {
+		return ta.After(tb)
+	}
+	return a > b
+}
+
+// checkSequence compares the group's ReportSequence to the last one seen for the same report. duplicate is true if
+// the report was received before. If reports were skipped, gapSince is the timestamp of the last report before the
+// gap. When the sequence started over it is followed from the new start. Reports without a numeric sequence are
+// never flagged.
+func (r *RedfishDevice) checkSequence(group *databus.DataGroup) (duplicate bool, gapSince string) {
+	seq, err := strconv.ParseInt(group.Sequence, 10, 64)
+	if err != nil {
+		return false, ""
+	}
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if r.sequences == nil {
+		r.sequences = make(map[string]reportSequence)
+	}
+	last, ok := r.sequences[group.ID]
+	check := sequenceNext
+	if ok {
+		check = compareSequence(last, seq, group.Timestamp)
+	}
+	if check == sequenceDuplicate {
+		r.duplicates++
+		return true, ""
+	}
+	r.sequences[group.ID] = reportSequence{seq: seq, timestamp: group.Timestamp}
+	switch check {
+	case sequenceGap:
+		missed := int(seq - last.seq - 1)
+		r.gaps++
+		r.missedReports += missed
+		log.Printf("%s: Missed %d %s report(s) between sequence %d and %d\n", r.SystemID, missed, group.ID, last.seq, seq)
+		return false, last.timestamp
+	case sequenceRestart:
+		log.Printf("%s: %s report sequence started over at %d after %d\n", r.SystemID, group.ID, seq, last.seq)
+	}
+	return false, ""
+}
+
+// backfill fetches the report from the device and sends the values it holds from between since and the first value
+// of group, i.e. what was lost in a gap. Only reports that keep their history (AppendWrapsWhenFull or
+// AppendStopsWhenFull) have anything to give.
+func (r *RedfishDevice) backfill(group *databus.DataGroup, since string, dataBusService *databus.DataBusService) {
+	from, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return
+	}
+	var until time.Time
+	for _, value := range group.Values {
+		t, err := time.Parse(time.RFC3339, value.Timestamp)
+		if err == nil && (until.IsZero() || t.Before(until)) {
+			until = t
+		}
+	}
+	if until.IsZero() {
+		return
+	}
+
+	metricReport, err := r.Redfish.GetUri(redfish.MetricReportsUri + "/" + group.ID)
+	if err != nil {
+		log.Printf("%s: Unable to get %s report for backfill: %v", r.SystemID, group.ID, err)
+		return
+	}
+	missed := buildGroup(metricReport, r)
+	if missed == nil {
+		return
+	}
+	values := missed.Values
+	missed.Values = nil
+	for _, value := range values {
+		t, err := time.Parse(time.RFC3339, value.Timestamp)
+		if err == nil && t.After(from) && t.Before(until) {
+			missed.Values = append(missed.Values, value)
+		}
+	}
+	if len(missed.Values) == 0 {
+		return
+	}
+	log.Printf("%s: Backfilled %d %s value(s)\n", r.SystemID, len(missed.Values), group.ID)
+	dataBusService.SendGroup(*missed)
 }
 
 func parseRedfishEvents(events *redfish.RedfishPayload, r *RedfishDevice, dataBusService *databus.DataBusService) {
@@ -166,6 +662,7 @@ func parseRedfishEvents(events *redfish.RedfishPayload, r *RedfishDevice, dataBu
 	eventData, err := events.GetPropertyByName("Events")
 	if err != nil {
 		log.Printf("%s: Unable to get eventData: %v", id, err)
+		r.recordParseError(err)
 		return
 	}
 	log.Printf("RedFish Events Found for parsing: %v\n", eventData)
@@ -179,13 +676,19 @@ func parseRedfishEvents(events *redfish.RedfishPayload, r *RedfishDevice, dataBu
 	group.FwVer = r.FwVer
 	group.ImgID = r.ImgID
 
-	group.ID = events.Object["Id"].(string)
+	group.ID, _ = events.Object["Id"].(string)
+	if group.ID == "" {
+		log.Printf("%s: Event record has no Id: %v", id, events)
+		r.recordParseError(errors.New("event record has no Id"))
+		return
+	}
 	//group.Label = events.Object["Name"].(string)
 	size := eventData.GetArraySize()
 	for j := 0; j < size; j++ {
 		eventData, err := events.GetEventByIndex(j)
 		if err != nil {
 			log.Printf("Unable to retrieve the redfish events\n")
+			r.recordParseError(err)
 			return
 		}
 		if eventData.Object["EventId"] != nil {
@@ -195,25 +698,30 @@ func parseRedfishEvents(events *redfish.RedfishPayload, r *RedfishDevice, dataBu
 			if err != nil {
 				log.Printf("Unable to get property %v\n", err)
 			} else {
-				data.OriginOfCondition = originCondition.Object["@odata.id"].(string)
+				data.OriginOfCondition, _ = originCondition.Object["@odata.id"].(string)
 			}
-			data.EventId = eventData.Object["EventId"].(string)
-			data.EventType = eventData.Object["EventType"].(string)
-			data.EventTimestamp = eventData.Object["EventTimestamp"].(string)
-			data.MemberId = eventData.Object["MemberId"].(string)
-			data.MessageSeverity = eventData.Object["MessageSeverity"].(string)
-			data.Message = eventData.Object["Message"].(string)
-			data.MessageId = eventData.Object["MessageId"].(string)
-			if args, ok := eventData.Object["MessageArgs"]; ok {
-				if args != nil {
-					for _, a := range args.([]interface{}) {
-						data.MessageArgs = append(data.MessageArgs, a.(string))
-					}
-				}
+			data.EventId, _ = eventData.Object["EventId"].(string)
+			if data.EventId == "" {
+				log.Printf("%s: Event %d has no EventId: %v", id, j, eventData.Object)
+				r.recordParseError(errors.New("event has no EventId"))
+				continue
 			}
+			data.EventType, _ = eventData.Object["EventType"].(string)
+			data.EventTimestamp, _ = eventData.Object["EventTimestamp"].(string)
+			data.MemberId, _ = eventData.Object["MemberId"].(string)
+			data.MessageSeverity, _ = eventData.Object["MessageSeverity"].(string)
+			data.Message, _ = eventData.Object["Message"].(string)
+			data.MessageId, _ = eventData.Object["MessageId"].(string)
+			args, _ := eventData.Object["MessageArgs"].([]interface{})
+			for _, a := range args {
+				arg, _ := a.(string)
+				data.MessageArgs = append(data.MessageArgs, arg)
+			}
+			r.tagEvent(data)
 			group.Events = append(group.Events, *data)
 		}
 	}
+	r.recordEvents(group.ID, len(group.Events))
 	dataBusService.SendGroup(*group)
 
 	dataGroupsMu.Lock()
@@ -224,153 +732,68 @@ func parseRedfishEvents(events *redfish.RedfishPayload, r *RedfishDevice, dataBu
 	dataGroupsMu.Unlock()
 }
 
-/*
-// Responsible for taking the lifecycle events received from SSE, getting its events, and then sending it along the
-// data bus
-func parseRedfishLce(lceevents *redfish.RedfishPayload, r *RedfishDevice, dataBusService *databus.DataBusService) {
-	id := r.SystemID
-	eventData, err := lceevents.GetPropertyByName("Events")
-	if err != nil {
-		log.Printf("%s: Unable to get eventData: %v %v", id, err, lceevents)
-		return
-	}
-	log.Printf("RedFish LifeCycle Events Found for parsing: %v\n", eventData)
-
-	group := new(databus.DataGroup)
-
-	group.ID = lceevents.Object["Id"].(string)
-	group.Label = lceevents.Object["Name"].(string)
-	size := lceevents.GetEventSize()
-	for j := 0; j < size; j++ {
-		eventData, err := lceevents.GetEventByIndex(j)
-		if err != nil {
-			log.Printf("Unable to retrieve the redfish lifecycle events\n")
-			return
-		}
-		if eventData.Object["EventId"] != nil {
-			data := new(databus.DataValue)
-			originCondition, err := eventData.GetPropertyByName("OriginOfCondition")
-			if err != nil {
-				log.Printf("Unable to get property %v\n", err)
-			}
-			data.Value = fmt.Sprint(originCondition)
-			data.MessageId = eventData.Object["MessageId"].(string)
-			data.EventType = eventData.Object["EventType"].(string)
-			if eventData.Object["EventTimestamp"] == nil {
-				t := time.Now()
-				data.Timestamp = t.Format("2006-01-02T15:04:05-0700")
-			} else {
-				data.Timestamp = eventData.Object["EventTimestamp"].(string)
-			}
-			if originCondition != nil {
-				if strings.Contains(originCondition.Object["@odata.id"].(string), configStrings["inventoryurl"]) {
-					map_oc, err := r.Redfish.GetUri(originCondition.Object["@odata.id"].(string))
-					if err != nil {
-						log.Printf("ERROR: %s\n", err)
-					}
-					inv_oem, err := map_oc.GetPropertyByName("Oem")
-					if err != nil {
-						log.Printf("Unable to get OEM %s\n", err)
-					}
-					if inv_oem != nil {
-						inv_DellOem, err := inv_oem.GetPropertyByName("Dell")
-						if err != nil {
-							log.Printf("Unable to get DELL metrics %s\n", err)
-						}
-						if inv_DellOem != nil {
-							inv_DellNIC, err := inv_DellOem.GetPropertyByName("DellNIC")
-							if err != nil {
-								log.Printf("Unable to get DellNIC metrics %s\n", err)
-							} else {
-								data.MaxBandwidthPercent = inv_DellNIC.Object["MaxBandwidthPercent"].(float64)
-								data.MinBandwidthPercent = inv_DellNIC.Object["MinBandwidthPercent"].(float64)
-							}
-							inv_DellNICPortMetrics, err := inv_DellOem.GetPropertyByName("DellNICPortMetrics")
-							if err != nil {
-								log.Printf("Unable to get NICPortMetrics%s\n", err)
-							} else {
-								data.Context = inv_DellNICPortMetrics.Object["@odata.context"].(string)
-								data.Label = inv_DellNICPortMetrics.Object["@odata.type"].(string)
-								data.ID = inv_DellNICPortMetrics.Object["@odata.id"].(string)
-								data.DiscardedPkts = inv_DellNICPortMetrics.Object["DiscardedPkts"].(float64)
-								broadcast := inv_DellNICPortMetrics.Object["RxBroadcast"]
-								data.RxBroadcast = broadcast.(float64)
-								data.RxBytes = inv_DellNICPortMetrics.Object["RxBytes"].(float64)
-								data.RxErrorPktAlignmentErrors = inv_DellNICPortMetrics.Object["RxErrorPktAlignmentErrors"].(float64)
-								data.RxMulticastPackets = inv_DellNICPortMetrics.Object["RxMutlicastPackets"].(float64)
-								data.RxUnicastPackets = inv_DellNICPortMetrics.Object["RxUnicastPackets"].(float64)
-								data.TxBroadcast = inv_DellNICPortMetrics.Object["TxBroadcast"].(float64)
-								data.TxBytes = inv_DellNICPortMetrics.Object["TxBytes"].(float64)
-								data.TxMutlicastPackets = inv_DellNICPortMetrics.Object["TxMutlicastPackets"].(float64)
-								data.TxUnicastPackets = inv_DellNICPortMetrics.Object["TxUnicastPackets"].(float64)
-							}
-						}
-					}
-				}
-			}
-			data.System = r.SystemID
-			group.Values = append(group.Values, *data)
-		}
-	}
-
-	dataBusService.SendGroup(*group)
-
-	dataGroupsMu.Lock()
-	if dataGroups[id] == nil {
-		dataGroups[id] = make(map[string]*databus.DataGroup)
-	}
-	dataGroups[id][group.ID] = group
-	dataGroupsMu.Unlock()
-}
-*/
-
-func (r *RedfishDevice) RestartMetricListener() {
-	go r.Redfish.ListenForMetricReports(r.Ctx, r.Metrics)
-}
-
-func (r *RedfishDevice) RestartAlertListener() {
-	go r.Redfish.ListenForAlerts(r.Ctx, r.Events)
-}
-
-func (r *RedfishDevice) RestartLceEventListener() {
-	go r.Redfish.ListenForLceEvents(r.Ctx, r.Events)
-}
-
 // StartMetricListener Directly responsible for receiving SSE events from iDRAC. Will parse received reports or issue a
-// message in the log indicating it received an unknown SSE event.
+// message in the log indicating it received an unknown SSE event. A failed stream is reconnected with backoff, and a
+// silent one when the idle watchdog expires, until the device is deleted.
 func (r *RedfishDevice) StartMetricListener(dataBusService *databus.DataBusService) {
 	if r.Metrics == nil {
 		r.Metrics = make(chan *redfish.RedfishEvent, 10)
 	}
-	//timer := time.AfterFunc(time.Minute*5, r.RestartAlertListener)
 	log.Printf("%s: Starting metric listener...\n", r.SystemID)
-	go r.Redfish.ListenForMetricReports(r.Ctx, r.Metrics)
+	var retry backoff
+	idle := newWatchdog()
+	defer idle.timer.Stop()
+	cancel := r.startStream(r.listenForMetricReports)
 	for {
-		event := <-r.Metrics
+		var event *redfish.RedfishEvent
+		select {
+		case event = <-r.Metrics:
+		case <-idle.timer.C:
+			limit, expired := idle.expired(r)
+			if !expired {
+				continue
+			}
+			log.Printf("%s: No metric report for %s, reconnecting\n", r.SystemID, limit)
+			r.recordStreamError(fmt.Errorf("no metric report for %s", limit))
+			cancel()
+			cancel = r.startStream(r.listenForMetricReports)
+			idle.feed(r)
+			continue
+		case <-r.Ctx.Done():
+			cancel()
+			return
+		}
 		if event == nil {
 			log.Printf("%s: Got SSE nil event \n", r.SystemID)
 			continue
 		}
 		if event.Err != nil { // SSE connect failure , retry connection
 			log.Printf("%s: Got SSE error %s\n", r.SystemID, event.Err)
-			if strings.Contains(event.Err.Error(), "connection error") {
-				// Wait for 5 minutes before restarting, so that the iDRAC can be rebooted
-				// and SSE connection can be re-established
-
-				log.Printf("Sleep 5 minutes before restarting SSE connection for %s\n", r.SystemID)
-				time.Sleep(time.Minute * 5)
+			r.recordStreamError(event.Err)
+			cancel()
+			delay := retry.next()
+			log.Printf("%s: Reconnecting metric stream in %s\n", r.SystemID, delay.Round(time.Second))
+			if !r.sleep(delay) {
+				return
 			}
-			r.RestartMetricListener()
+			cancel = r.startStream(r.listenForMetricReports)
+			idle.feed(r)
 			continue
 		}
-		r.LastEvent = time.Now()
+		retry.reset()
+		idle.feed(r)
+		r.recordAlive()
 		if event.Payload != nil {
 			if ot, ok := event.Payload.Object["@odata.type"].(string); ok {
 				switch {
 				case strings.Contains(ot, ".MetricReport"):
-					log.Printf("%s: Got new report for %s\n", r.SystemID, event.Payload.Object["@odata.id"].(string))
+					report, _ := event.Payload.Object["@odata.id"].(string)
+					log.Printf("%s: Got new report for %s\n", r.SystemID, report)
 					parseReport(event.Payload, r, dataBusService)
 					continue
+				case strings.Contains(ot, ".Event"):
+					// Services whose profile has no SSE filter send their alerts on the metric stream too
+					continue
 				default:
 					log.Printf("%s: Got unknown event type %s\n", r.SystemID, ot)
 				}
@@ -378,6 +801,7 @@ func (r *RedfishDevice) StartMetricListener(dataBusService *databus.DataBusServi
 		}
 		//log.Printf("%s: Got unknown SSE event %v\n", r.SystemID, event.Payload)
 		log.Printf("%s: Got bad SSE event \n", r.SystemID)
+		r.recordParseError(errors.New("bad SSE event"))
 	}
 }
 
@@ -385,28 +809,35 @@ func (r *RedfishDevice) StartAlertListener(dataBusService *databus.DataBusServic
 	if r.Events == nil {
 		r.Events = make(chan *redfish.RedfishEvent, 10)
 	}
-	//timer := time.AfterFunc(time.Minute*5, r.RestartAlertListener)
 	log.Printf("%s: Starting event listener...\n", r.SystemID)
-	go r.Redfish.ListenForAlerts(r.Ctx, r.Events)
+	var retry backoff
+	cancel := r.startStream(r.listenForAlerts)
 	for {
-		event := <-r.Events
+		var event *redfish.RedfishEvent
+		select {
+		case event = <-r.Events:
+		case <-r.Ctx.Done():
+			cancel()
+			return
+		}
 		if event == nil {
 			log.Printf("%s: Got SSE nil event \n", r.SystemID)
 			continue
 		}
 		if event.Err != nil { // SSE connect failure , retry connection
 			log.Printf("%s: Got SSE error %s\n", r.SystemID, event.Err)
-			if strings.Contains(event.Err.Error(), "connection error") {
-				// Wait for 5 minutes before restarting, so that the iDRAC can be rebooted
-				// and SSE connection can be re-established
-
-				log.Printf("Sleep 5 minutes before restarting SSE connection for %s\n", r.SystemID)
-				time.Sleep(time.Minute * 5)
+			r.recordStreamError(event.Err)
+			cancel()
+			delay := retry.next()
+			log.Printf("%s: Reconnecting event stream in %s\n", r.SystemID, delay.Round(time.Second))
+			if !r.sleep(delay) {
+				return
 			}
-			r.RestartAlertListener()
+			cancel = r.startStream(r.listenForAlerts)
 			continue
 		}
-		r.LastEvent = time.Now()
+		retry.reset()
+		r.recordAlive()
 		if event.Payload != nil {
 			if ot, ok := event.Payload.Object["@odata.type"].(string); ok {
 				switch {
@@ -414,6 +845,9 @@ func (r *RedfishDevice) StartAlertListener(dataBusService *databus.DataBusServic
 					log.Printf("%s: Got new event\n", r.SystemID)
 					parseRedfishEvents(event.Payload, r, dataBusService)
 					continue
+				case strings.Contains(ot, ".MetricReport"):
+					// Services whose profile has no SSE filter send their metric reports on the alert stream too
+					continue
 				default:
 					log.Printf("%s: Got unknown event type %s\n", r.SystemID, ot)
 				}
@@ -421,67 +855,39 @@ func (r *RedfishDevice) StartAlertListener(dataBusService *databus.DataBusServic
 		}
 		//log.Printf("%s: Got unknown SSE event %v\n", r.SystemID, event.Payload)
 		log.Printf("%s: Got bad SSE event \n", r.SystemID)
+		r.recordParseError(errors.New("bad SSE event"))
 	}
 }
 
-/*
-// StartLceEventListener Directly responsible for receiving Redfish LifeCycleEvents from iDRAC. Will parse received reports or issue a
-// message in the log indicating it received an unknown event.
-func (r *RedfishDevice) StartLceEventListener(dataBusService *databus.DataBusService) {
-	if r.Events == nil {
-		r.Events = make(chan *redfish.RedfishEvent, 10)
-	}
-	timer := time.AfterFunc(time.Minute*5, r.RestartLceEventListener)
-	log.Printf("%s: Starting event listener...\n", r.SystemID)
-	go r.Redfish.ListenForLceEvents(r.Ctx, r.Events)
-	for {
-		lceevent := <-r.Events
-		timer.Reset(time.Minute * 5)
-		r.LastEvent = time.Now()
-		if lceevent != nil {
-			log.Printf("%s: Got new event with id %s\n", r.SystemID, lceevent.Payload.Object["Id"].(string))
-			parseRedfishLce(lceevent.Payload, r, dataBusService)
-		} else {
-			log.Printf("%s: Got unknown LCE event %v\n", r.SystemID, lceevent.Payload)
-		}
-	}
-}
-*/
-// getTelemetry Starts the service which will listen for SSE reports from the iDRAC
+// getTelemetry Starts the service which will listen for SSE reports from the iDRAC, or pull them in pull mode
 func getTelemetry(r *RedfishDevice, telemetryService *redfish.RedfishPayload, dataBusService *databus.DataBusService) {
 	r.State = databus.RUNNING
+	r.spawn(func() { r.loadCatalog(dataBusService) })
+	r.chooseEventMode()
 	inclAlerts := os.Getenv("INCLUDE_ALERTS")
 	if inclAlerts == "true" {
-		go r.StartAlertListener(dataBusService)
+		r.spawn(func() { r.StartAlertListener(dataBusService) })
 	}
-	go r.StartMetricListener(dataBusService)
-
-}
-
-/*
-// getRedfishLce Starts the service which will listens for Redfish LifeCycle Events from the iDRAC
-func getRedfishLce(r *RedfishDevice, eventService *redfish.RedfishPayload, dataBusService *databus.DataBusService) {
-	eventsIn := make(chan *redfish.RedfishEvent, 10)
-	//contxt := r.Ctx
-	go r.Redfish.GetLceSSE(r.Ctx, eventsIn, "https://"+r.Redfish.Hostname+"/redfish/v1/SSE") //nolint: errcheck
-	//eventsOut := new(redfish.RedfishEvent)
-	eventsOut := <-eventsIn
-	eventSvc := eventsOut.Payload
-	size := eventSvc.GetEventSize()
-	if size == 0 {
-		log.Printf("%s: No Redfish LifeCycle Events!\n", r.SystemID)
+	if os.Getenv("INCLUDE_LCE") == "true" {
+		if r.Redfish.Profile().SSEFilter(redfish.LifecycleFormat) != "" {
+			r.spawn(func() { r.StartLceEventListener(dataBusService) })
+		} else {
+			// Without a filter the lifecycle events cannot be told apart from the alerts
+			log.Printf("%s: %s has no lifecycle event filter, not listening for lifecycle events\n", r.SystemID,
+				r.Redfish.Profile().Name())
+		}
 	}
-	log.Printf("%s: Found %d Redfish LifeCycle Events\n", r.Redfish.Hostname, size)
-	for i := 0; i < size; i++ {
-		parseRedfishLce(eventSvc, r, dataBusService)
+	if r.EventMode == redfish.PULL {
+		r.spawn(func() { r.StartPuller(dataBusService) })
+		return
 	}
-	r.State = databus.RUNNING
-	r.StartLceEventListener(dataBusService)
+	r.spawn(func() { r.StartMetricListener(dataBusService) })
+
 }
-*/
+
 // Take an instance of a Redfish device, get its system ID, get any child devices if it is a chassis, and then start
-// listening for SSE events. NOTE: This expects that someone has enabled Telemetry reports and started the telemetry
-// service externally.
+// listening for SSE events. NOTE: Unless TELEMETRY_PROFILE is set, this expects that someone has enabled Telemetry
+// reports and started the telemetry service externally.
 func redfishMonitorStart(r *RedfishDevice, dataBusService *databus.DataBusService) {
 	systemID, err := r.Redfish.GetSystemId()
 	if err != nil || systemID == "" {
@@ -517,11 +923,17 @@ func redfishMonitorStart(r *RedfishDevice, dataBusService *databus.DataBusServic
 	//Does this system support Telemetry?
 	telemetryService, err := serviceRoot.GetPropertyByName("TelemetryService")
 	if err != nil {
-		log.Println("TODO: Fake some basic telemetry...") // TODO
-		r.State = databus.TELNOTFOUND
+		log.Printf("%s: No Telemetry Service, polling sensors instead...\n", r.Redfish.Hostname)
+		r.State = databus.POLLING
+		r.spawn(func() { r.StartPoller(dataBusService) })
 	} else {
 		log.Printf("%s: Using Telemetry Service...\n", r.Redfish.Hostname)
-		//go getRedfishLce(r, telemetryService, dataBusService)
+		if telemetryProfile != nil {
+			state := r.applyTelemetry(telemetryProfile, dataBusService)
+			for _, err := range state.Errors {
+				log.Printf("%s: Failed to apply telemetry profile: %s\n", r.Redfish.Hostname, err)
+			}
+		}
 		getTelemetry(r, telemetryService, dataBusService)
 	}
 }
@@ -535,7 +947,7 @@ func handleAuthServiceChannel(serviceIn chan *auth.Service, dataBusService *data
 			log.Println("Service IP is empty")
 			continue
 		}
-		if devices[service.Ip] != nil {
+		if getDevice(service.Ip) != nil {
 			log.Printf("Device with IP %s already exists", service.Ip)
 			continue
 		}
@@ -544,10 +956,13 @@ func handleAuthServiceChannel(serviceIn chan *auth.Service, dataBusService *data
 		var r *redfish.RedfishClient
 		var err error
 		//log.Println(service)
-		if service.AuthType == auth.AuthTypeUsernamePassword {
-			r, err = redfish.Init(service.Ip, service.Auth["username"], service.Auth["password"])
+		tlsOpts := tlsOptions(service)
+		if service.AuthType == auth.AuthTypeUsernamePassword && configStrings["sessionauth"] != "true" {
+			r, err = redfish.Init(service.Ip, service.Auth["username"], service.Auth["password"], tlsOpts)
+		} else if service.AuthType == auth.AuthTypeUsernamePassword || service.AuthType == auth.AuthTypeXAuthToken {
+			r, err = redfish.InitSession(service.Ip, service.Auth["username"], service.Auth["password"], tlsOpts)
 		} else if service.AuthType == auth.AuthTypeBearerToken {
-			r, err = redfish.InitBearer(service.Ip, service.Auth["token"])
+			r, err = redfish.InitBearer(service.Ip, service.Auth["token"], tlsOpts)
 		}
 		//log.Print(r)
 		device := new(RedfishDevice)
@@ -559,25 +974,88 @@ func handleAuthServiceChannel(serviceIn chan *auth.Service, dataBusService *data
 			r.Username = service.Auth["username"]
 			r.Password = service.Auth["password"]
 			device.State = databus.CONNFAILED
+			if redfish.IsCertificateError(err) {
+				device.State = databus.TLSFAILED
+			}
+			device.recordConnectError(err)
 		} else {
 			device.State = databus.STARTING
 		}
 		device.Redfish = r
+		device.service = service
 		device.HasChildren = service.ServiceType == auth.MSM
+		device.PollInterval = pollInterval(service)
+		device.EventMode = service.Auth[auth.AuthEventMode]
+		if device.EventMode == "" {
+			device.EventMode = configStrings["eventmode"]
+		}
 		ctx, cancel := context.WithCancel(context.Background())
 		device.Ctx = ctx
 		device.CtxCancel = cancel
+		devicesMu.Lock()
 		if devices == nil {
 			devices = make(map[string]*RedfishDevice)
 		}
 		devices[service.Ip] = device
+		devicesMu.Unlock()
 		// Only want validated devices to be started
 		if err == nil {
-			go redfishMonitorStart(device, dataBusService)
+			device.spawn(func() { redfishMonitorStart(device, dataBusService) })
 		}
 	}
 }
 
+// tlsOptions is how the service's certificate is checked, from its own settings or else REDFISH_TLS_CACERT and
+// REDFISH_TLS_INSECURE
+func tlsOptions(service *auth.Service) redfish.TLSOptions {
+	ret := redfish.TLSOptions{
+		CACert:      service.Auth[auth.AuthCACert],
+		Fingerprint: service.Auth[auth.AuthFingerprint],
+		Insecure:    service.Auth[auth.AuthInsecure] == "true",
+	}
+	if ret.CACert == "" && ret.Fingerprint == "" && service.Auth[auth.AuthInsecure] == "" {
+		ret.CACert = configStrings["tlscacert"]
+		ret.Insecure = configStrings["tlsinsecure"] == "true"
+	}
+	return ret
+}
+
+// logout ends the device's session if it uses session authentication
+func logout(r *RedfishDevice) {
+	if r == nil || r.Redfish == nil {
+		return
+	}
+	err := r.Redfish.Logout()
+	if err != nil {
+		log.Printf("%s: Failed to log out: %v", r.Redfish.Hostname, err)
+	}
+}
+
+// logoutAll ends the sessions of all devices, so they do not linger on the iDRACs after redfishread exits
+func logoutAll() {
+	for _, dev := range allDevices() {
+		logout(dev)
+	}
+}
+
+// getDevice returns the device at ip, or nil if there is none
+func getDevice(ip string) *RedfishDevice {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	return devices[ip]
+}
+
+// allDevices returns the devices, for going through without holding devicesMu
+func allDevices() []*RedfishDevice {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	ret := make([]*RedfishDevice, 0, len(devices))
+	for _, dev := range devices {
+		ret = append(ret, dev)
+	}
+	return ret
+}
+
 // getEnvSettings Retrieve settings from the environment. Notice that configStrings has a set of defaults but those
 // can be overridden by environment variables via this function.
 func getEnvSettings() {
@@ -589,6 +1067,53 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
+	backfill := os.Getenv("BACKFILL_ON_GAP")
+	if len(backfill) > 0 {
+		configStrings["backfill"] = backfill
+	}
+	pollEvery := os.Getenv("POLL_INTERVAL")
+	if len(pollEvery) > 0 {
+		configStrings["pollinterval"] = pollEvery
+	}
+	for env, name := range map[string]string{
+		"EVENT_MODE":    "eventmode",
+		"PUSH_URL":      "pushurl",
+		"PUSH_LISTEN":   "pushlisten",
+		"PUSH_TLS_CERT": "pushcert",
+		"PUSH_TLS_KEY":  "pushkey",
+
+		"RECONNECT_MIN_DELAY":  "reconnectmin",
+		"RECONNECT_MAX_DELAY":  "reconnectmax",
+		"IDLE_WATCHDOG_FACTOR": "idlefactor",
+		"SLED_AUTO_REGISTER":   "sledautoregister",
+		"SLED_USERNAME":        "sledusername",
+		"SLED_PASSWORD":        "sledpassword",
+	} {
+		value := os.Getenv(env)
+		if len(value) > 0 {
+			configStrings[name] = value
+		}
+	}
+	tlsCACert := os.Getenv("REDFISH_TLS_CACERT")
+	if len(tlsCACert) > 0 {
+		configStrings["tlscacert"] = tlsCACert
+	}
+	tlsInsecure := os.Getenv("REDFISH_TLS_INSECURE")
+	if len(tlsInsecure) > 0 {
+		configStrings["tlsinsecure"] = tlsInsecure
+	}
+	sessionAuth := os.Getenv("REDFISH_SESSION_AUTH")
+	if len(sessionAuth) > 0 {
+		configStrings["sessionauth"] = sessionAuth
+	}
+	telemetryProfile := os.Getenv("TELEMETRY_PROFILE")
+	if len(telemetryProfile) > 0 {
+		configStrings["telemetryprofile"] = telemetryProfile
+	}
 }
 
 func main() {
@@ -596,12 +1121,22 @@ func main() {
 	getEnvSettings()
 
 	dataGroups = make(map[string]map[string]*databus.DataGroup)
-	authClient := new(auth.AuthorizationClient)
+	if configStrings["telemetryprofile"] != "" {
+		var err error
+		telemetryProfile, err = loadTelemetryProfile(configStrings["telemetryprofile"])
+		if err != nil {
+			log.Fatalf("Failed to load telemetry profile: %v", err)
+		}
+	}
+	if configStrings["pushurl"] != "" {
+		startPushListener()
+	}
+	authClient = new(auth.AuthorizationClient)
 	dataBusService := new(databus.DataBusService)
 
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -622,6 +1157,13 @@ func main() {
 	go authClient.GetService(serviceIn)
 	go handleAuthServiceChannel(serviceIn, dataBusService) // THIS FUNCTION ADDS SERVICE
 	go dataBusService.ReceiveCommand(commands)             //nolint: errcheck
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		logoutAll()
+		os.Exit(0)
+	}()
 	for {
 		command := <-commands
 		log.Printf("Received command in redfishread: %s", command.Command)
@@ -635,27 +1177,66 @@ func main() {
 			}
 			dataGroupsMu.Unlock()
 		case databus.GETPRODUCERS:
-			producers := make([]*databus.DataProducer, len(devices))
-			i := 0
-			for _, dev := range devices {
-				producer := new(databus.DataProducer)
-				producer.Hostname = dev.Redfish.Hostname
-				producer.Username = dev.Redfish.Username
-				producer.State = dev.State
-				producer.LastEvent = dev.LastEvent
-				producers[i] = producer
-				i = i + 1
+			devs := allDevices()
+			producers := make([]*databus.DataProducer, 0, len(devs))
+			for _, dev := range devs {
+				producers = append(producers, dev.producer())
 			}
-			err := dataBusService.SendProducersToQueue(producers, command.ReceiveQueue)
+			linkSleds(devs, producers)
+			err := dataBusService.Reply(command, "DataProducer", producers)
 			if err != nil {
-				log.Printf("aft SendProducersToQueue got error,so continue")
+				log.Printf("Failed to send producers to %s: %v", command.ReceiveQueue, err)
 			}
+		case databus.GETCATALOG:
+			catalogs := []*databus.MetricCatalog{}
+			for _, dev := range allDevices() {
+				if catalog := dev.getCatalog(); catalog != nil {
+					catalogs = append(catalogs, catalog)
+				}
+			}
+			err := dataBusService.Reply(command, "MetricCatalog", catalogs)
+			if err != nil {
+				log.Printf("Failed to send metric catalogs to %s: %v", command.ReceiveQueue, err)
+			}
+		case databus.GETTELEMETRY, databus.SETTELEMETRY:
+			dev := getDevice(command.ServiceIP)
+			if dev == nil || dev.Redfish == nil {
+				dataBusService.Reply(command, "Error", "unknown system "+command.ServiceIP)
+				continue
+			}
+			// reading and patching the device takes a while, keep serving other commands meanwhile
+			go func(command *databus.Command) {
+				var state *databus.TelemetryState
+				if command.Command == databus.SETTELEMETRY && command.Telemetry != nil {
+					state = dev.applyTelemetry(command.Telemetry, dataBusService)
+				} else {
+					state = dev.telemetryState(dataBusService)
+				}
+				err := dataBusService.Reply(command, "TelemetryState", state)
+				if err != nil {
+					log.Printf("Failed to send telemetry state to %s: %v", command.ReceiveQueue, err)
+				}
+			}(command)
 		case databus.DELETEPRODUCER:
-			devices[command.ServiceIP].CtxCancel()
-			log.Printf("service has been cancelled, Ctx = %v", devices[command.ServiceIP].Ctx)
-			time.Sleep(2 * time.Second)
+			devicesMu.Lock()
+			dev := devices[command.ServiceIP]
 			delete(devices, command.ServiceIP)
+			devicesMu.Unlock()
+			if dev == nil {
+				dataBusService.Reply(command, "Error", "unknown system "+command.ServiceIP)
+				continue
+			}
+			dev.CtxCancel()
+			log.Printf("service has been cancelled, Ctx = %v", dev.Ctx)
+			// the listeners still delete their push subscriptions, log out once they are done
+			go func() {
+				if !dev.stopped(stopTimeout) {
+					log.Printf("%s: Gave up waiting for the listeners to stop after %v", dev.Redfish.Hostname, stopTimeout)
+				}
+				logout(dev)
+			}()
 		case auth.TERMINATE:
+			logoutAll()
 			os.Exit(0)
 		}
 	}
@@ -0,0 +1,176 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
+)
+
+func TestCompareSequence(t *testing.T) {
+	last := reportSequence{seq: 10, timestamp: "2024-01-01T00:10:00Z"}
+	for _, tc := range []struct {
+		name      string
+		last      reportSequence
+		seq       int64
+		timestamp string
+		want      sequenceCheck
+	}{
+		{"next", last, 11, "2024-01-01T00:11:00Z", sequenceNext},
+		{"gap", last, 14, "2024-01-01T00:14:00Z", sequenceGap},
+		{"same report again", last, 10, "2024-01-01T00:10:00Z", sequenceDuplicate},
+		{"older report again", last, 8, "2024-01-01T00:08:00Z", sequenceDuplicate},
+		{"redelivered with another offset", last, 9, "2024-01-01T01:09:00+01:00", sequenceDuplicate},
+		{"restart within the window", last, 1, "2024-01-01T00:12:00Z", sequenceRestart},
+		{"restart at the same sequence", last, 10, "2024-01-01T00:12:00Z", sequenceRestart},
+		{"restart beyond the window", reportSequence{seq: 40}, 1, "", sequenceRestart},
+		{"no timestamps", reportSequence{seq: 10}, 9, "", sequenceDuplicate},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := compareSequence(tc.last, tc.seq, tc.timestamp); got != tc.want {
+				t.Errorf("compareSequence(%d, %q) = %d, want %d", tc.seq, tc.timestamp, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckSequence(t *testing.T) {
+	type report struct {
+		seq       string
+		timestamp string
+		duplicate bool
+		gapSince  string
+	}
+	for _, tc := range []struct {
+		name       string
+		reports    []report
+		duplicates int
+		gaps       int
+		missed     int
+	}{
+		{
+			name: "gap",
+			reports: []report{
+				{"1", "2024-01-01T00:01:00Z", false, ""},
+				{"2", "2024-01-01T00:02:00Z", false, ""},
+				{"5", "2024-01-01T00:05:00Z", false, "2024-01-01T00:02:00Z"},
+			},
+			gaps:   1,
+			missed: 2,
+		},
+		{
+			name: "duplicate after reconnect",
+			reports: []report{
+				{"7", "2024-01-01T00:07:00Z", false, ""},
+				{"8", "2024-01-01T00:08:00Z", false, ""},
+				{"8", "2024-01-01T00:08:00Z", true, ""},
+				{"7", "2024-01-01T00:07:00Z", true, ""},
+				{"9", "2024-01-01T00:09:00Z", false, ""},
+			},
+			duplicates: 2,
+		},
+		{
+			name: "restart soon after a restart",
+			reports: []report{
+				{"3", "2024-01-01T00:03:00Z", false, ""},
+				{"4", "2024-01-01T00:04:00Z", false, ""},
+				{"1", "2024-01-01T00:10:00Z", false, ""},
+				{"2", "2024-01-01T00:11:00Z", false, ""},
+				{"3", "2024-01-01T00:12:00Z", false, ""},
+				{"3", "2024-01-01T00:12:00Z", true, ""},
+			},
+			duplicates: 1,
+		},
+		{
+			name: "no sequence",
+			reports: []report{
+				{"", "2024-01-01T00:01:00Z", false, ""},
+				{"", "2024-01-01T00:01:00Z", false, ""},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(RedfishDevice)
+			for i, rep := range tc.reports {
+				group := &databus.DataGroup{ID: "ThermalMetrics", Sequence: rep.seq, Timestamp: rep.timestamp}
+				duplicate, gapSince := r.checkSequence(group)
+				if duplicate != rep.duplicate || gapSince != rep.gapSince {
+					t.Errorf("report %d (sequence %s): got %v %q, want %v %q", i, rep.seq, duplicate, gapSince,
+						rep.duplicate, rep.gapSince)
+				}
+			}
+			if r.duplicates != tc.duplicates || r.gaps != tc.gaps || r.missedReports != tc.missed {
+				t.Errorf("got %d duplicates, %d gaps, %d missed, want %d, %d, %d", r.duplicates, r.gaps,
+					r.missedReports, tc.duplicates, tc.gaps, tc.missed)
+			}
+		})
+	}
+}
+
+func TestSledCredentials(t *testing.T) {
+	configStrings["sledusername"] = "sledadmin"
+	configStrings["sledpassword"] = "sledsecret"
+	defer func() {
+		configStrings["sledusername"] = ""
+		configStrings["sledpassword"] = ""
+	}()
+
+	chassis := &RedfishDevice{service: &auth.Service{Auth: map[string]string{"username": "msm", "password": "msmsecret"}}}
+	if username, password := chassis.sledCredentials(); username != "sledadmin" || password != "sledsecret" {
+		t.Errorf("got %s/%s, want the sled settings and never the chassis's credentials", username, password)
+	}
+	chassis.service.Auth[auth.AuthSledUsername] = "slot"
+	chassis.service.Auth[auth.AuthSledPassword] = "slotsecret"
+	if username, password := chassis.sledCredentials(); username != "slot" || password != "slotsecret" {
+		t.Errorf("got %s/%s, want the chassis's sled credentials", username, password)
+	}
+}
+
+func TestBuildGroupMissingFields(t *testing.T) {
+	r := new(RedfishDevice)
+	report := &redfish.RedfishPayload{Object: map[string]interface{}{
+		"Name": "Thermal",
+		"MetricValues": []interface{}{
+			map[string]interface{}{"MetricId": "TempReading", "MetricValue": "40", "Timestamp": 12},
+		},
+	}}
+	if group := buildGroup(report, r); group != nil || r.parseErrors != 1 {
+		t.Errorf("report without Id: got %v and %d parse errors, want nil and 1", group, r.parseErrors)
+	}
+
+	report.Object["Id"] = "ThermalMetrics"
+	report.Object["Name"] = 7
+	group := buildGroup(report, r)
+	if group == nil || group.ID != "ThermalMetrics" || group.Label != "" || len(group.Values) != 1 {
+		t.Fatalf("got %+v, want ThermalMetrics with one value and no label", group)
+	}
+	if group.Values[0].Timestamp == "" {
+		t.Errorf("value with a bad timestamp was not stamped")
+	}
+	if r.parseErrors != 1 {
+		t.Errorf("got %d parse errors, want 1", r.parseErrors)
+	}
+}
+
+func TestStopped(t *testing.T) {
+	r := new(RedfishDevice)
+	r.Ctx, r.CtxCancel = context.WithCancel(context.Background())
+	cleanedUp := false
+	r.startStream(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		cleanedUp = true
+	})
+	if r.stopped(10 * time.Millisecond) {
+		t.Fatal("stopped before the device was cancelled")
+	}
+	r.CtxCancel()
+	if !r.stopped(time.Second) || !cleanedUp {
+		t.Error("stopped returned before the stream had cleaned up")
+	}
+}
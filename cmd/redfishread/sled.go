@@ -0,0 +1,159 @@
+// Licensed to You under the Apache License, Version 2.0.
+// An MSM chassis reports for the sleds it holds. Each sled is treated as a device of its own: the chassis's values and
+// events are tagged with the sled they belong to, the sleds' iDRACs can be registered as devices as well, and
+// GETPRODUCERS shows which device sits in which chassis.
+
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/redfish"
+)
+
+// sledRef finds the slot in a chassis URI such as /redfish/v1/Chassis/Sled-1 or a context such as Sled.Slot.1
+var sledRef = regexp.MustCompile(`(?i)sled[-.](?:slot\.)?(\d+)`)
+
+// authClient registers the sleds' iDRACs with the auth service
+var authClient *auth.AuthorizationClient
+
+// sledOf returns the service tag and slot of the sled the first of refs that names one belongs to, or "" and 0 if
+// none does
+func (r *RedfishDevice) sledOf(refs ...string) (string, int) {
+	if len(r.ChildDevices) == 0 {
+		return "", 0
+	}
+	for _, ref := range refs {
+		match := sledRef.FindStringSubmatch(ref)
+		if match == nil {
+			continue
+		}
+		slot, _ := strconv.Atoi(match[1])
+		if tag, ok := r.ChildDevices[slot]; ok {
+			return tag, slot
+		}
+	}
+	return "", 0
+}
+
+// tagValue tags value with its sled, going by the metric property and context of the metric value it came from
+func (r *RedfishDevice) tagValue(value *databus.DataValue, metricValue *redfish.RedfishPayload) {
+	property, _ := metricValue.Object["MetricProperty"].(string)
+	value.Sled, value.Slot = r.sledOf(property, value.Context)
+}
+
+// tagEvent tags event with its sled, going by its origin of condition and message arguments
+func (r *RedfishDevice) tagEvent(event *databus.EventValue) {
+	event.Sled, event.Slot = r.sledOf(append([]string{event.OriginOfCondition}, event.MessageArgs...)...)
+}
+
+// registerSled adds the iDRAC of the sled in chassis to the auth service, unless it is a device already. The iDRAC is
+// found through the managers the sled is ManagedBy. The sleds' iDRACs rarely share the chassis's credentials, so they
+// are only registered when sled credentials are configured.
+func (r *RedfishDevice) registerSled(slot int, chassis *redfish.RedfishPayload) {
+	ip := sledManagerAddress(chassis)
+	if ip == "" {
+		log.Printf("%s: No iDRAC address found for sled %d", r.SystemID, slot)
+		return
+	}
+	if getDevice(ip) != nil || r.service == nil {
+		return
+	}
+	username, password := r.sledCredentials()
+	if username == "" {
+		log.Printf("%s: Not registering the iDRAC of sled %d at %s, no sled credentials are set", r.SystemID, slot, ip)
+		return
+	}
+	service := auth.Service{
+		ServiceType: auth.IDRAC,
+		Ip:          ip,
+		AuthType:    auth.AuthTypeUsernamePassword,
+		Auth:        map[string]string{"username": username, "password": password},
+	}
+	// the chassis's certificate is not the sled's, but how it is checked and read applies to its sleds as well
+	for _, name := range []string{auth.AuthCACert, auth.AuthInsecure, auth.AuthEventMode, auth.AuthPollInterval} {
+		if value, ok := r.service.Auth[name]; ok {
+			service.Auth[name] = value
+		}
+	}
+	log.Printf("%s: Registering the iDRAC of sled %d at %s", r.SystemID, slot, ip)
+	err := authClient.AddService(service)
+	if err != nil {
+		log.Printf("%s: Failed to register sled %d: %v", r.SystemID, slot, err)
+	}
+}
+
+// sledCredentials are the chassis's own sled credentials, or else the sledusername and sledpassword settings
+func (r *RedfishDevice) sledCredentials() (string, string) {
+	if r.service != nil && r.service.Auth[auth.AuthSledUsername] != "" {
+		return r.service.Auth[auth.AuthSledUsername], r.service.Auth[auth.AuthSledPassword]
+	}
+	return configStrings["sledusername"], configStrings["sledpassword"]
+}
+
+// sledManagerAddress is the first IPv4 address of the managers of the sled in chassis, or "" if it has none
+func sledManagerAddress(chassis *redfish.RedfishPayload) string {
+	links, err := chassis.GetPropertyByName("Links")
+	if err != nil {
+		return ""
+	}
+	managers, err := links.GetPropertyByName("ManagedBy")
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < managers.GetArraySize(); i++ {
+		manager, err := managers.GetPropertyByIndex(i)
+		if err != nil {
+			continue
+		}
+		interfaces, err := manager.GetPropertyByName("EthernetInterfaces")
+		if err != nil {
+			continue
+		}
+		for j := 0; j < interfaces.GetCollectionSize(); j++ {
+			nic, err := interfaces.GetPropertyByIndex(j)
+			if err != nil {
+				continue
+			}
+			addresses, _ := nic.Object["IPv4Addresses"].([]interface{})
+			for _, address := range addresses {
+				address, _ := address.(map[string]interface{})
+				if ip, _ := address["Address"].(string); ip != "" && ip != "0.0.0.0" {
+					return ip
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// linkSleds fills in the sleds of the chassis among producers and the parent and slot of the devices sitting in them.
+// producers are those of devs, in the same order.
+func linkSleds(devs []*RedfishDevice, producers []*databus.DataProducer) {
+	bySystem := make(map[string]*databus.DataProducer)
+	for i, dev := range devs {
+		if dev.SystemID != "" {
+			bySystem[dev.SystemID] = producers[i]
+		}
+	}
+	for i, dev := range devs {
+		if len(dev.ChildDevices) == 0 {
+			continue
+		}
+		for slot, tag := range dev.ChildDevices {
+			sled := databus.Sled{Slot: slot, ServiceTag: tag}
+			if child := bySystem[tag]; child != nil {
+				sled.Hostname = child.Hostname
+				child.Parent = producers[i].Hostname
+				child.Slot = slot
+			}
+			producers[i].Sleds = append(producers[i].Sleds, sled)
+		}
+		sort.Slice(producers[i].Sleds, func(a, b int) bool { return producers[i].Sleds[a].Slot < producers[i].Sleds[b].Slot })
+	}
+}
@@ -17,12 +17,15 @@ import (
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/auth"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/disc"
 
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	"mbhost": "activemq",
 	"mbport": "61613",
+	"mbtype": "stomp",
 }
 
 var authServices map[string]auth.Service
@@ -98,11 +101,20 @@ func handleDiscServiceChannel(serviceIn chan *disc.Service, config *ini.File, au
 				//TODO get token
 			} else {
 				authService.AuthType = auth.AuthTypeUsernamePassword
+				if devconfig.Key("authtype").String() == "session" {
+					authService.AuthType = auth.AuthTypeXAuthToken
+				}
 				authService.Auth = make(map[string]string)
 				authService.Auth["username"] = key.String()
 				authService.Auth["password"] = devconfig.Key("password").MustString("")
 			}
 		}
+		for _, setting := range []string{auth.AuthPollInterval, auth.AuthEventMode, auth.AuthCACert, auth.AuthFingerprint,
+			auth.AuthInsecure} {
+			if authService.Auth != nil && devconfig.HasKey(setting) {
+				authService.Auth[setting] = devconfig.Key(setting).String()
+			}
+		}
 		//log.Print("Got Service = ", *authService)
 		_ = authorizationService.SendService(*authService)
 		if authServices == nil {
@@ -121,6 +133,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 }
 
 func main() {
@@ -140,8 +156,8 @@ func main() {
 	authorizationService := new(auth.AuthorizationService)
 
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -19,7 +19,7 @@ import (
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
 
 	//"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/amqp"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 type SplunkEventFields struct {
@@ -40,6 +40,7 @@ var configStringsMu sync.RWMutex
 var configStrings = map[string]string{
 	"mbhost":    "activemq",
 	"mbport":    "61613",
+	"mbtype":    "stomp",
 	"splunkURL": "http://splunkhost:8088",
 	"splunkKey": "",
 }
@@ -105,6 +106,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 	splunkURL := os.Getenv("SPLUNK_HEC_URL")
 	if len(splunkURL) > 0 {
 		configStrings["splunkURL"] = splunkURL
@@ -155,8 +160,8 @@ func logToSplunk(events []*SplunkEvent) {
 func handleGroups(groupsChan chan *databus.DataGroup) {
 	for {
 		group := <-groupsChan // If you are new to GoLang see https://golangdocs.com/channels-in-golang
-		events := make([]*SplunkEvent, len(group.Values))
-		for index, value := range group.Values {
+		events := make([]*SplunkEvent, 0, len(group.Values))
+		for _, value := range group.Values {
 			timestamp, err := time.Parse(time.RFC3339, value.Timestamp)
 			if err != nil {
 				// For why we do this see https://datatracker.ietf.org/doc/html/rfc3339#section-4.3
@@ -172,7 +177,10 @@ func handleGroups(groupsChan chan *databus.DataGroup) {
 			event.Time = timestamp.Unix()
 			event.Event = "metric"
 			event.Host = value.System
-			floatVal, _ := strconv.ParseFloat(value.Value, 64)
+			floatVal, ok := value.Float()
+			if !ok {
+				continue
+			}
 			event.Fields.Value = floatVal
 			event.Fields.MetricName = value.Context + "_" + value.ID
 
@@ -180,7 +188,7 @@ func handleGroups(groupsChan chan *databus.DataGroup) {
 			//fmt.Println("url, key, metricIndex", configStrings["splunkURL"], configStrings["splunkKey"], configStrings["splunkIndex"])
 			event.Fields.Source = "http:" + configStrings["splunkIndex"]
 			configStringsMu.RUnlock()
-			events[index] = event
+			events = append(events, event)
 		}
 		logToSplunk(events)
 	}
@@ -239,13 +247,14 @@ func main() {
 		configStrings["splunkIndex"] = *splunkindex
 	}
 
+	mbType := configStrings["mbtype"]
 	host := configStrings["mbhost"]
 	port, _ = strconv.Atoi(configStrings["mbport"])
 	configStringsMu.Unlock()
 
 	var mb messagebus.Messagebus
 	for {
-		mb, err = stomp.NewStompMessageBus(host, port)
+		mb, err = messagebus.New(messagebus.NewConfig(mbType, host, port))
 		if err == nil {
 			defer mb.Close()
 			break
@@ -262,7 +271,7 @@ func main() {
 	groupsIn := make(chan *databus.DataGroup, 10)
 
 	// Queue used to send metric data by redfishread.go - /splunk
-	dbClient.Subscribe("/spunk")
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/spunk", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/spunk")
 
 	log.Printf("Entering processing loop")
@@ -16,12 +16,14 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
 	"mbhost":              "activemq",
 	"mbport":              "61613",
+	"mbtype":              "stomp",
 	"timescaleuser":       "postgres",
 	"timescalepwd":        "postgres",
 	"timescaleDBHost":     "localhost",
@@ -129,6 +131,10 @@ func getEnvSettings() {
 	if len(mbPort) > 0 {
 		configStrings["mbport"] = mbPort
 	}
+	mbType := os.Getenv("MESSAGEBUS_TYPE")
+	if len(mbType) > 0 {
+		configStrings["mbtype"] = mbType
+	}
 
 	//Read postgres/timescale db settings
 	username := os.Getenv("POSTGRES_USER")
@@ -158,8 +164,8 @@ func main() {
 
 	dbClient := new(databus.DataBusClient)
 	for {
-		stompPort, _ := strconv.Atoi(configStrings["mbport"])
-		mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+		mbPort, _ := strconv.Atoi(configStrings["mbport"])
+		mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
 		if err != nil {
 			log.Printf("Could not connect to message bus: %s", err)
 			time.Sleep(5 * time.Second)
@@ -171,7 +177,7 @@ func main() {
 	}
 
 	groupsIn := make(chan *databus.DataGroup, 10)
-	dbClient.Subscribe("/tscalestack")
+	dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/tscalestack", databus.FilterFromEnv(), databus.DefaultLease)
 	dbClient.Get("/tscalestack")
 	go dbClient.GetGroup(groupsIn, "/tscalestack")
 
@@ -15,12 +15,14 @@ import (
         "github.com/prometheus/common/expfmt"
 
         "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/databus"
-        "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+        "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+        _ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/backends"
 )
 
 var configStrings = map[string]string{
         "mbhost":        "activemq",
         "mbport":        "61613",
+        "mbtype":        "stomp",
         "victoria_url":  "",
         "victoria_user": "",
         "victoria_pass": "",
@@ -38,23 +40,12 @@ func sanitizeMetricName(name string) string {
         return replacer.Replace(name)
 }
 
-// parseFloatOrString converts numeric strings or certain status strings to float64
-func parseFloatOrString(value string) float64 {
-        if f, err := strconv.ParseFloat(value, 64); err == nil {
-                return f
-        }
-        switch value {
-        case "Up", "Operational":
-                return 1
-        case "Down", "Degraded", "Disabled":
-                return 0
-        default:
-                return 0
-        }
-}
-
 // createOrUpdateGauge handles both FQDD and non-FQDD metrics
 func createOrUpdateGauge(value databus.DataValue, registry *prometheus.Registry) {
+        floatVal, ok := value.Float()
+        if !ok {
+                return
+        }
         isFQDD := strings.Contains(value.Context, ".")
         subsystem := ""
         labels := []string{"ServiceTag", "HostName"}
@@ -92,9 +83,9 @@ func createOrUpdateGauge(value databus.DataValue, registry *prometheus.Registry)
 
         gauge := collectors[contextKey][metricName]
         if isFQDD {
-                gauge.WithLabelValues(value.System, value.HostName, value.Context).Set(parseFloatOrString(value.Value))
+                gauge.WithLabelValues(value.System, value.HostName, value.Context).Set(floatVal)
         } else {
-                gauge.WithLabelValues(value.System, value.HostName).Set(parseFloatOrString(value.Value))
+                gauge.WithLabelValues(value.System, value.HostName).Set(floatVal)
         }
 }
 
@@ -162,6 +153,9 @@ func getEnvSettings() {
         if val := os.Getenv("MESSAGEBUS_PORT"); val != "" {
                 configStrings["mbport"] = val
         }
+        if val := os.Getenv("MESSAGEBUS_TYPE"); val != "" {
+                configStrings["mbtype"] = val
+        }
         if val := os.Getenv("VICTORIA_METRICS_URL"); val != "" {
                 configStrings["victoria_url"] = val
         }
@@ -177,19 +171,19 @@ func main() {
         getEnvSettings()
 
         dbClient := new(databus.DataBusClient)
-        stompPort, _ := strconv.Atoi(configStrings["mbport"])
+        mbPort, _ := strconv.Atoi(configStrings["mbport"])
         maxRetries := 20
 
         for i := 1; i <= maxRetries; i++ {
-                log.Printf("Attempt %d: Connecting to message bus at %s:%d", i, configStrings["mbhost"], stompPort)
-                mb, err := stomp.NewStompMessageBus(configStrings["mbhost"], stompPort)
+                log.Printf("Attempt %d: Connecting to message bus at %s:%d", i, configStrings["mbhost"], mbPort)
+                mb, err := messagebus.New(messagebus.NewConfig(configStrings["mbtype"], configStrings["mbhost"], mbPort))
                 if err != nil {
                         log.Printf("Connection failed: %s", err)
                         time.Sleep(5 * time.Second)
                 } else {
                         dbClient.Bus = mb
                         defer mb.Close()
-                        log.Printf("Connected to message bus at %s:%d", configStrings["mbhost"], stompPort)
+                        log.Printf("Connected to message bus at %s:%d", configStrings["mbhost"], mbPort)
                         break
                 }
 
@@ -199,7 +193,7 @@ func main() {
         }
 
         groupsIn := make(chan *databus.DataGroup, 10)
-        dbClient.Subscribe("/prometheus")
+        dbClient.SubscribeWithLease(dbClient.ExitOnSignal(), "/prometheus", databus.FilterFromEnv(), databus.DefaultLease)
         dbClient.Get("/prometheus")
         go dbClient.GetGroup(groupsIn, "/prometheus")
 
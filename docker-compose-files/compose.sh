@@ -286,6 +286,27 @@ fi
 if [ -z $INCLUDE_ALERTS ]; then
     export INCLUDE_ALERTS=
 fi
+if [ -z $INCLUDE_LCE ]; then
+    export INCLUDE_LCE=
+fi
+if [ -z $BACKFILL_ON_GAP ]; then
+    export BACKFILL_ON_GAP=
+fi
+if [ -z $EVENT_MODE ]; then
+    export EVENT_MODE=
+fi
+if [ -z $PUSH_URL ]; then
+    export PUSH_URL=
+fi
+if [ -z $SLED_AUTO_REGISTER ]; then
+    export SLED_AUTO_REGISTER=
+fi
+if [ -z $REDFISH_TLS_CACERT ]; then
+    export REDFISH_TLS_CACERT=
+fi
+if [ -z $REDFISH_TLS_INSECURE ]; then
+    export REDFISH_TLS_INSECURE=
+fi
 
  # remove dependency on setup influx-test-db
 touch $topdir/docker-compose-files/container-info-influx-pump.txt
@@ -3,7 +3,9 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/disc"
@@ -16,6 +18,23 @@ const (
 	AuthTypeBearerToken      = 3
 )
 
+// Keys of Service.Auth that hold per device settings rather than credentials
+const (
+	// AuthPollInterval is how often a device without a TelemetryService is polled, e.g. "90s"
+	AuthPollInterval = "pollinterval"
+	// AuthEventMode is how a device delivers its reports and events, "sse", "push" or "pull"
+	AuthEventMode = "eventmode"
+	// AuthCACert is a PEM file with the CAs that signed the device's certificate
+	AuthCACert = "cacert"
+	// AuthFingerprint is the SHA-256 fingerprint the device's certificate must have
+	AuthFingerprint = "fingerprint"
+	// AuthInsecure set to "true" skips checking the device's certificate
+	AuthInsecure = "insecure"
+	// AuthSledUsername and AuthSledPassword are the credentials of the iDRACs of an MSM chassis's sleds
+	AuthSledUsername = "sledusername"
+	AuthSledPassword = "sledpassword"
+)
+
 const (
 	UNKNOWN = disc.UNKNOWN
 	MSM     = disc.MSM
@@ -49,6 +68,16 @@ type Command struct {
 	Command      string       `json:"command"`
 	SplunkConfig SplunkConfig `json:"Splunkconfig",omitempty"`
 	Service      Service      `json:"service,omitempty"`
+	// ReceiveQueue and CorrelationID are set on commands that expect a Response
+	ReceiveQueue  string `json:"ReceiveQueue,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+type Response struct {
+	Command       string         `json:"command"`
+	CorrelationID string         `json:"correlationId,omitempty"`
+	SplunkConfigs []SplunkConfig `json:"splunkConfigs,omitempty"`
+	Error         string         `json:"error,omitempty"`
 }
 
 const (
@@ -61,6 +90,8 @@ type AuthorizationService struct {
 }
 type AuthorizationClient struct {
 	Bus messagebus.Messagebus
+
+	requesters messagebus.Requesters
 }
 
 func (d *AuthorizationService) SendService(service Service) error {
@@ -86,17 +117,47 @@ func (d *AuthorizationService) ReceiveCommand(commands chan<- *Command) error {
 		command := new(Command)
 		err := json.Unmarshal([]byte(message), command)
 		if err != nil {
-			log.Print("Error reading command queue: ", err)
-			log.Printf("Message %#v\n", message)
-			return err
+			messagebus.SendToDeadLetter(d.Bus, CommandQueue, message, err)
+			continue
 		}
 		commands <- command
 	}
 	return nil
 }
 
-func (d *AuthorizationClient) GetHECConfig() {
-	d.SendCommandString(GETHECCONFIG)
+// GetHECConfig asks for the stored Splunk HTTP event collector configurations, with the answer sent to queue
+func (d *AuthorizationClient) GetHECConfig(ctx context.Context, queue string) ([]SplunkConfig, error) {
+	c := new(Command)
+	c.Command = GETHECCONFIG
+	c.ReceiveQueue = queue
+	message, err := d.requesters.Get(d.Bus, queue).Request(ctx, func(correlationID string) error {
+		c.CorrelationID = correlationID
+		return d.SendCommand(*c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := new(Response)
+	err = json.Unmarshal([]byte(message), resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.SplunkConfigs, nil
+}
+
+// Reply answers command on its receive queue, echoing its correlation ID
+func (d *AuthorizationService) Reply(command *Command, resp Response) error {
+	resp.Command = command.Command
+	resp.CorrelationID = command.CorrelationID
+	jsonStr, _ := json.Marshal(resp)
+	err := d.Bus.SendMessage(jsonStr, command.ReceiveQueue)
+	if err != nil {
+		log.Printf("Failed to send response %v", err)
+	}
+	return err
 }
 
 func (d *AuthorizationService) Sendconfig(config SplunkConfig) error {
@@ -163,7 +224,8 @@ func (d *AuthorizationClient) GetService(services chan<- *Service) {
 		service := new(Service)
 		err := json.Unmarshal([]byte(message), service)
 		if err != nil {
-			log.Print("Error reading auth queue: ", err)
+			messagebus.SendToDeadLetter(d.Bus, EventQueue, message, err)
+			continue
 		}
 		services <- service
 	}
@@ -3,6 +3,7 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -31,13 +32,15 @@ type Command struct {
 	ResponseQueue string      `json:"ReceiveQueue"`
 	Property      string      `json:"property,omitempty"`
 	Value         interface{} `json:"value,omitempty"`
+	CorrelationID string      `json:"correlationId,omitempty"`
 }
 
 type Response struct {
-	Command  string      `json:"command"`
-	Property string      `json:"property,omitempty"`
-	Value    interface{} `json:"value,omitempty"`
-	Error    error       `json:"error,omitempty"`
+	Command       string      `json:"command"`
+	Property      string      `json:"property,omitempty"`
+	Value         interface{} `json:"value,omitempty"`
+	Error         error       `json:"error,omitempty"`
+	CorrelationID string      `json:"correlationId,omitempty"`
 }
 
 type ConfigService struct {
@@ -50,6 +53,8 @@ type ConfigClient struct {
 	Bus           messagebus.Messagebus
 	CommandQueue  string
 	ResponseQueue string
+
+	requesters messagebus.Requesters
 }
 
 func NewConfigService(bus messagebus.Messagebus, commandQueue string, entries map[string]*ConfigEntry) *ConfigService {
@@ -83,7 +88,8 @@ func (d *ConfigService) Run() {
 		command := new(Command)
 		err := json.Unmarshal([]byte(message), command)
 		if err != nil {
-			log.Print("Error reading config command queue: ", err)
+			messagebus.SendToDeadLetter(d.Bus, d.CommandQueue, message, err)
+			continue
 		}
 		switch command.Command {
 		default:
@@ -105,14 +111,21 @@ func (d *ConfigService) GetProperties(command *Command) {
 	for k := range d.Entries {
 		keys = append(keys, k)
 	}
-	jsonStr, _ := json.Marshal(keys)
+	resp := new(Response)
+	resp.Command = command.Command
+	resp.Value = keys
+	resp.CorrelationID = command.CorrelationID
+	jsonStr, _ := json.Marshal(resp)
 	err := d.Bus.SendMessage(jsonStr, command.ResponseQueue)
-	log.Printf("Failed to send response %v", err)
+	if err != nil {
+		log.Printf("Failed to send response %v", err)
+	}
 }
 
 func (d *ConfigService) Get(command *Command) {
 	resp := new(Response)
 	resp.Command = command.Command
+	resp.CorrelationID = command.CorrelationID
 	entry, ok := d.Entries[command.Property]
 	if !ok {
 		resp.Error = fmt.Errorf("Could not find property named %s", command.Property)
@@ -135,6 +148,7 @@ func (d *ConfigService) Get(command *Command) {
 func (d *ConfigService) Set(command *Command) {
 	resp := new(Response)
 	resp.Command = command.Command
+	resp.CorrelationID = command.CorrelationID
 	entry, ok := d.Entries[command.Property]
 	if !ok {
 		resp.Error = fmt.Errorf("Could not find property named %s", command.Property)
@@ -156,6 +170,7 @@ func (d *ConfigService) Set(command *Command) {
 func (d *ConfigService) Reset(command *Command) {
 	resp := new(Response)
 	resp.Command = command.Command
+	resp.CorrelationID = command.CorrelationID
 	entry, ok := d.Entries[command.Property]
 	if !ok {
 		resp.Error = fmt.Errorf("Could not find property named %s", command.Property)
@@ -179,87 +194,59 @@ func (d *ConfigClient) SendCommand(command Command) error {
 	return d.Bus.SendMessage(jsonStr, d.CommandQueue)
 }
 
-func (d *ConfigClient) ReadOneMessage() string {
-	messages := make(chan string)
-	sub, err := d.Bus.ReceiveMessage(messages, d.ResponseQueue)
+// request sends command and waits for the response to it until ctx is done
+func (d *ConfigClient) request(ctx context.Context, command Command) (*Response, error) {
+	command.ResponseQueue = d.ResponseQueue
+	message, err := d.requesters.Get(d.Bus, d.ResponseQueue).Request(ctx, func(correlationID string) error {
+		command.CorrelationID = correlationID
+		return d.SendCommand(command)
+	})
 	if err != nil {
-		log.Println("Error receiving message: ", err)
-		return ""
+		return nil, err
+	}
+	resp := new(Response)
+	err = json.Unmarshal([]byte(message), resp)
+	if err != nil {
+		return nil, err
 	}
-	message := <-messages
-	//log.Println("Got message: ", message)
-	sub.Close()
-	return message
+	return resp, nil
 }
 
-func (d *ConfigClient) GetProperties() ([]string, error) {
+func (d *ConfigClient) GetProperties(ctx context.Context) ([]string, error) {
 	var command Command
 	command.Command = GETPROPS
-	command.ResponseQueue = d.ResponseQueue
-	err := d.SendCommand(command)
+	resp, err := d.request(ctx, command)
 	if err != nil {
 		return nil, err
 	}
-	message := d.ReadOneMessage()
-	var props []string
-	err = json.Unmarshal([]byte(message), &props)
-	if err != nil {
-		return nil, err
+	values, _ := resp.Value.([]interface{})
+	props := make([]string, 0, len(values))
+	for _, v := range values {
+		if prop, ok := v.(string); ok {
+			props = append(props, prop)
+		}
 	}
 	return props, nil
 }
 
-func (d *ConfigClient) Get(name string) (*Response, error) {
+func (d *ConfigClient) Get(ctx context.Context, name string) (*Response, error) {
 	var command Command
 	command.Command = GET
-	command.ResponseQueue = d.ResponseQueue
 	command.Property = name
-	err := d.SendCommand(command)
-	if err != nil {
-		return nil, err
-	}
-	message := d.ReadOneMessage()
-	prop := new(Response)
-	err = json.Unmarshal([]byte(message), prop)
-	if err != nil {
-		return nil, err
-	}
-	return prop, nil
+	return d.request(ctx, command)
 }
 
-func (d *ConfigClient) Set(name string, value interface{}) (*Response, error) {
+func (d *ConfigClient) Set(ctx context.Context, name string, value interface{}) (*Response, error) {
 	var command Command
 	command.Command = SET
-	command.ResponseQueue = d.ResponseQueue
 	command.Property = name
 	command.Value = value
-	err := d.SendCommand(command)
-	if err != nil {
-		return nil, err
-	}
-	message := d.ReadOneMessage()
-	prop := new(Response)
-	err = json.Unmarshal([]byte(message), prop)
-	if err != nil {
-		return nil, err
-	}
-	return prop, nil
+	return d.request(ctx, command)
 }
 
-func (d *ConfigClient) Reset(name string) (*Response, error) {
+func (d *ConfigClient) Reset(ctx context.Context, name string) (*Response, error) {
 	var command Command
 	command.Command = RESET
-	command.ResponseQueue = d.ResponseQueue
 	command.Property = name
-	err := d.SendCommand(command)
-	if err != nil {
-		return nil, err
-	}
-	message := d.ReadOneMessage()
-	prop := new(Response)
-	err = json.Unmarshal([]byte(message), prop)
-	if err != nil {
-		return nil, err
-	}
-	return prop, nil
+	return d.request(ctx, command)
 }
@@ -0,0 +1,166 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package databus
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// MetricDefinition is the part of a Redfish MetricDefinition that describes a metric's values
+type MetricDefinition struct {
+	ID          string
+	Name        string
+	Description string  `json:",omitempty"`
+	MetricType  string  `json:",omitempty"`
+	DataType    string  `json:",omitempty"`
+	Units       string  `json:",omitempty"`
+	Accuracy    float64 `json:",omitempty"`
+}
+
+// MetricReportDefinition is the part of a Redfish MetricReportDefinition that says what a report holds and how often
+// it is sent
+type MetricReportDefinition struct {
+	ID                 string
+	Name               string
+	Description        string `json:",omitempty"`
+	Type               string `json:",omitempty"`
+	RecurrenceInterval string `json:",omitempty"`
+	Enabled            bool
+	Metrics            []string `json:",omitempty"`
+}
+
+// MetricCatalog holds the metric and report definitions of one device, keyed by ID. They only change with the
+// firmware, so FwVer is the version they were read from.
+type MetricCatalog struct {
+	System            string
+	HostName          string
+	Model             string
+	FwVer             string
+	Loaded            time.Time
+	MetricDefinitions map[string]MetricDefinition
+	ReportDefinitions map[string]MetricReportDefinition
+}
+
+// Describe types value according to its metric definition and fills in the units. Descriptions and accuracy stay in
+// the catalog. Values of metrics the catalog does not define are typed from their contents alone. A nil catalog
+// defines nothing.
+func (c *MetricCatalog) Describe(value *DataValue) {
+	def, _ := c.Definition(value.ID)
+	value.ParseValue(def.DataType)
+	value.Units = def.Units
+}
+
+// Definition returns the definition of the metric id, reporting whether the catalog has one. A nil catalog has none.
+func (c *MetricCatalog) Definition(id string) (MetricDefinition, bool) {
+	if c == nil {
+		return MetricDefinition{}, false
+	}
+	def, ok := c.MetricDefinitions[id]
+	return def, ok
+}
+
+// ReportInterval is how often the device sends reports, the shortest recurrence interval of its enabled periodic
+// reports, or 0 if it sends none or the catalog is nil
+func (c *MetricCatalog) ReportInterval() time.Duration {
+	var ret time.Duration
+	if c == nil {
+		return ret
+	}
+	for _, report := range c.ReportDefinitions {
+		if !report.Enabled || report.Type != "Periodic" {
+			continue
+		}
+		interval, err := ParseRecurrenceInterval(report.RecurrenceInterval)
+		if err != nil || interval <= 0 {
+			continue
+		}
+		if ret == 0 || interval < ret {
+			ret = interval
+		}
+	}
+	return ret
+}
+
+var recurrenceInterval = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseRecurrenceInterval parses the ISO 8601 durations Redfish uses for intervals, such as "PT0H1M0S" or "P1DT12H"
+func ParseRecurrenceInterval(interval string) (time.Duration, error) {
+	match := recurrenceInterval.FindStringSubmatch(interval)
+	if match == nil || interval == "P" || interval[len(interval)-1] == 'T' {
+		return 0, fmt.Errorf("bad recurrence interval %q", interval)
+	}
+	var ret time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+1] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(match[i+1], 64)
+		if err != nil {
+			return 0, err
+		}
+		ret += time.Duration(value * float64(unit))
+	}
+	return ret, nil
+}
+
+// GetCatalogs asks for the metric catalog of every device, with the answer sent to queue
+func (d *DataBusClient) GetCatalogs(ctx context.Context, queue string) ([]MetricCatalog, error) {
+	var command Command
+	command.Command = GETCATALOG
+	resp, err := d.Request(ctx, command, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogs := []MetricCatalog{}
+	err = decodeCatalog(resp.Data, &catalogs)
+	if err != nil {
+		return nil, err
+	}
+	return catalogs, nil
+}
+
+// Catalog returns the last metric catalog GetGroup received for system, or nil if none came yet
+func (d *DataBusClient) Catalog(system string) *MetricCatalog {
+	d.catalogsMu.Lock()
+	defer d.catalogsMu.Unlock()
+	return d.catalogs[system]
+}
+
+// setCatalog keeps a catalog sent to the subscribers alongside their data groups
+func (d *DataBusClient) setCatalog(data interface{}) error {
+	catalog := new(MetricCatalog)
+	err := decodeCatalog(data, catalog)
+	if err != nil {
+		return err
+	}
+	d.catalogsMu.Lock()
+	defer d.catalogsMu.Unlock()
+	if d.catalogs == nil {
+		d.catalogs = make(map[string]*MetricCatalog)
+	}
+	d.catalogs[catalog.System] = catalog
+	return nil
+}
+
+func decodeCatalog(data interface{}, result interface{}) error {
+	decoder, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339Nano),
+		Result:     result,
+	})
+	return decoder.Decode(data)
+}
+
+// SendCatalog sends the metric catalog of a device to every subscriber, whatever its filter, when it is loaded or
+// changes
+func (d *DataBusService) SendCatalog(catalog MetricCatalog) {
+	for _, rec := range d.Subscribers() {
+		d.SendResponse(rec.Queue, SUBSCRIBE, "MetricCatalog", catalog)
+	}
+}
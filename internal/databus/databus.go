@@ -3,9 +3,11 @@
 package databus
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -22,20 +24,16 @@ type DataValue struct {
 	System    string
 	HostName  string
 	Timestamp string
-	// MessageId                 string
-	// EventType                 string
-	// MaxBandwidthPercent       float64
-	// MinBandwidthPercent       float64
-	// DiscardedPkts             float64
-	// RxBroadcast               float64
-	// RxBytes                   float64
-	// RxErrorPktAlignmentErrors float64
-	// RxMulticastPackets        float64
-	// RxUnicastPackets          float64
-	// TxBroadcast               float64
-	// TxBytes                   float64
-	// TxMutlicastPackets        float64
-	// TxUnicastPackets          float64
+	// Kind, Number and Int are Value typed by ParseValue. Units (e.g. "Cel", "W") come from the metric definition
+	// when known, the rest of the definition is in the MetricCatalog. Value stays the raw string for consumers that
+	// predate them.
+	Kind   string  `json:",omitempty"`
+	Number float64 `json:",omitempty"`
+	Int    int64   `json:",omitempty"`
+	Units  string  `json:",omitempty"`
+	// Sled and Slot are the service tag and slot of the sled a chassis's value belongs to
+	Sled string `json:",omitempty"`
+	Slot int    `json:",omitempty"`
 }
 
 type EventValue struct {
@@ -48,6 +46,43 @@ type EventValue struct {
 	MessageId         string
 	MessageArgs       []string
 	OriginOfCondition string
+	// Sled and Slot are the service tag and slot of the sled a chassis's event belongs to
+	Sled string `json:",omitempty"`
+	Slot int    `json:",omitempty"`
+}
+
+// LifecycleEvent is an event of the Lifecycle Controller log. Events about a NIC carry the NIC's bandwidth settings and
+// port statistics, read from their origin of condition when the event came in.
+type LifecycleEvent struct {
+	EventType         string
+	EventId           string
+	EventTimestamp    string
+	MessageSeverity   string
+	Message           string
+	MessageId         string
+	MessageArgs       []string
+	OriginOfCondition string
+	NIC               *NICStats `json:",omitempty"`
+	// Sled and Slot are the service tag and slot of the sled a chassis's event belongs to
+	Sled string `json:",omitempty"`
+	Slot int    `json:",omitempty"`
+}
+
+// NICStats are the Dell OEM settings and counters of a NIC port. ID is the port metrics resource they were read from.
+type NICStats struct {
+	ID                        string
+	MaxBandwidthPercent       float64
+	MinBandwidthPercent       float64
+	DiscardedPkts             float64
+	RxBroadcast               float64
+	RxBytes                   float64
+	RxErrorPktAlignmentErrors float64
+	RxMulticastPackets        float64
+	RxUnicastPackets          float64
+	TxBroadcast               float64
+	TxBytes                   float64
+	TxMulticastPackets        float64
+	TxUnicastPackets          float64
 }
 
 type DataGroup struct {
@@ -64,6 +99,8 @@ type DataGroup struct {
 	Timestamp string
 	Values    []DataValue
 	Events    []EventValue
+	// LifecycleEvents are only sent by devices with INCLUDE_LCE set
+	LifecycleEvents []LifecycleEvent `json:",omitempty"`
 }
 
 type DataProducer struct {
@@ -71,45 +108,101 @@ type DataProducer struct {
 	Username  string
 	State     string
 	LastEvent time.Time
+	Model     string
+	FwVer     string
+	// Platform is the vendor and generation of the device, e.g. "Dell iDRAC9"
+	Platform string `json:",omitempty"`
+	// ConnectedSince is when the event stream last came up, zero while it is down
+	ConnectedSince time.Time
+	// Reports and Events count what was received per report ID and per event group ID
+	Reports     map[string]int
+	Events      map[string]int
+	ParseErrors int
+	// Reconnects counts event stream failures, LastError is the latest of them or of the parse errors
+	Reconnects    int
+	LastError     string
+	LastErrorTime time.Time
+	// SequenceGaps counts the breaks in ReportSequence, MissedReports the reports lost in them, and DuplicateReports
+	// the reports dropped because they had been received before
+	SequenceGaps     int
+	MissedReports    int
+	DuplicateReports int
+	// Parent is the Hostname of the chassis the device sits in at Slot, Sleds are the sleds of a chassis
+	Parent string `json:",omitempty"`
+	Slot   int    `json:",omitempty"`
+	Sleds  []Sled `json:",omitempty"`
+}
+
+// Sled is a sled of a chassis. Hostname is set if its iDRAC is a producer too.
+type Sled struct {
+	Slot       int
+	ServiceTag string
+	Hostname   string `json:",omitempty"`
 }
 
 const (
 	STARTING    = "Starting"
 	RUNNING     = "Running"
 	TELNOTFOUND = "Telemetry Service Not Found"
+	POLLING     = "Polling"
 	CONNFAILED  = "Connection Failed"
+	TLSFAILED   = "Certificate Verification Failed"
 )
 
 const (
 	GET            = "get"
 	SUBSCRIBE      = "subscribe"
+	UNSUBSCRIBE    = "unsubscribe"
+	GETSUBSCRIBERS = "getsubscribers"
 	GETPRODUCERS   = "getproducers"
+	GETCATALOG     = "getcatalog"
+	GETTELEMETRY   = "gettelemetry"
+	SETTELEMETRY   = "settelemetry"
 	DELETEPRODUCER = "deleteproducers"
 	TERMINATE      = "terminate"
 )
 
 type Command struct {
-	Command      string `json:"command"`
-	ReceiveQueue string `json:"ReceiveQueue"`
-	ReportData   string `json:"reportdata,omitempty"`
-	ServiceIP    string `json:"serviceIP,omitempty"`
+	Command      string  `json:"command"`
+	ReceiveQueue string  `json:"ReceiveQueue"`
+	ReportData   string  `json:"reportdata,omitempty"`
+	ServiceIP    string  `json:"serviceIP,omitempty"`
+	Filter       *Filter `json:"filter,omitempty"`
+	// Lease is how many seconds a SUBSCRIBE lasts unless it is sent again, 0 for no expiry
+	Lease int `json:"lease,omitempty"`
+	// CorrelationID is echoed back in the response so the client can match it to the command
+	CorrelationID string `json:"correlationId,omitempty"`
+	// Telemetry is the state SETTELEMETRY applies to the device at ServiceIP
+	Telemetry *TelemetryConfig `json:"telemetry,omitempty"`
 }
 
 type Response struct {
-	Command  string      `json:"command"`
-	DataType string      `json:"dataType"`
-	Data     interface{} `json:"data"`
+	Command       string      `json:"command"`
+	DataType      string      `json:"dataType"`
+	Data          interface{} `json:"data"`
+	CorrelationID string      `json:"correlationId,omitempty"`
 }
 
 const CommandQueue = "/databus"
 
 type DataBusService struct {
-	Recievers []string
-	Bus       messagebus.Messagebus
+	Bus messagebus.Messagebus
+
+	receiversMu sync.Mutex
+	receivers   []*Subscriber
 }
 
 type DataBusClient struct {
 	Bus messagebus.Messagebus
+
+	requesters messagebus.Requesters
+
+	// catalogs are the metric catalogs GetGroup received, by system
+	catalogs   map[string]*MetricCatalog
+	catalogsMu sync.Mutex
+
+	// leases counts the SubscribeWithLease subscriptions that have not unsubscribed yet
+	leases sync.WaitGroup
 }
 
 func (d *DataBusService) SendResponse(queue string, command string, dataType string, data interface{}) error {
@@ -125,31 +218,51 @@ func (d *DataBusService) SendResponse(queue string, command string, dataType str
 	return err
 }
 
+// Reply answers command on its receive queue, echoing its correlation ID
+func (d *DataBusService) Reply(command *Command, dataType string, data interface{}) error {
+	res := new(Response)
+	res.Command = command.Command
+	res.DataType = dataType
+	res.Data = data
+	res.CorrelationID = command.CorrelationID
+	jsonStr, _ := json.Marshal(res)
+	err := d.Bus.SendMessage(jsonStr, command.ReceiveQueue)
+	if err != nil {
+		log.Printf("Failed to send response %v", err)
+	}
+	return err
+}
+
 func (d *DataBusService) SendMultipleResponses(command string, dataType string, data interface{}) {
 	res := new(Response)
 	res.Command = command
 	res.DataType = dataType
 	res.Data = data
 	jsonStr, _ := json.Marshal(res)
-	for _, queue := range d.Recievers {
-		err := d.Bus.SendMessage(jsonStr, queue)
+	for _, rec := range d.Subscribers() {
+		err := d.Bus.SendMessage(jsonStr, rec.Queue)
 		if err != nil {
 			log.Printf("Failed to send response %v", err)
 		}
 	}
 }
 
+// SendGroup sends group to every receiver, trimmed down to what the receiver's filter lets through
 func (d *DataBusService) SendGroup(group DataGroup) {
-	d.SendMultipleResponses(SUBSCRIBE, "DataGroup", group)
+	for _, rec := range d.Subscribers() {
+		filtered, ok := rec.Filter.Apply(&group)
+		if ok {
+			d.SendResponse(rec.Queue, SUBSCRIBE, "DataGroup", *filtered)
+		}
+	}
 }
 
 func (d *DataBusService) SendGroupToQueue(group DataGroup, queue string) {
-	d.SendResponse(queue, GET, "DataGroup", group)
-}
-
-func (d *DataBusService) SendProducersToQueue(producer []*DataProducer, queue string) error {
-	err := d.SendResponse(queue, GETPRODUCERS, "DataProducer", producer)
-	return err
+	rec, _ := d.getSubscriber(queue)
+	filtered, ok := rec.Filter.Apply(&group)
+	if ok {
+		d.SendResponse(queue, GET, "DataGroup", *filtered)
+	}
 }
 
 func (d *DataBusService) ReceiveCommand(commands chan<- *Command) error {
@@ -166,20 +279,17 @@ func (d *DataBusService) ReceiveCommand(commands chan<- *Command) error {
 		command := new(Command)
 		err := json.Unmarshal([]byte(message), command)
 		if err != nil {
-			log.Printf("Error reading command queue: ", err)
-			//return err
+			messagebus.SendToDeadLetter(d.Bus, CommandQueue, message, err)
+			continue
 		}
-		if command.Command == SUBSCRIBE {
-			found := false
-			for _, rec := range d.Recievers {
-				if rec == command.ReceiveQueue {
-					found = true
-				}
-			}
-			if !found {
-				d.Recievers = append(d.Recievers, command.ReceiveQueue)
-			}
-		} else {
+		switch command.Command {
+		case SUBSCRIBE:
+			d.subscribe(command)
+		case UNSUBSCRIBE:
+			d.unsubscribe(command.ReceiveQueue)
+		case GETSUBSCRIBERS:
+			d.Reply(command, "Subscriber", d.Subscribers())
+		default:
 			commands <- command
 		}
 	}
@@ -202,34 +312,35 @@ func (d *DataBusClient) Get(queue string) {
 }
 
 func (d *DataBusClient) Subscribe(queue string) {
+	d.SubscribeWithFilter(queue, nil)
+}
+
+// SubscribeWithFilter subscribes queue to the groups that pass filter. A nil filter subscribes to everything.
+func (d *DataBusClient) SubscribeWithFilter(queue string, filter *Filter) {
 	var command Command
 	command.Command = SUBSCRIBE
 	command.ReceiveQueue = queue
+	command.Filter = filter
 	d.SendCommand(command)
 }
 
-func (d *DataBusClient) ReadOneMessage(queue string) string {
-	messages := make(chan string)
-	sub, err := d.Bus.ReceiveMessage(messages, queue)
+// Request sends command with its response going to queue and waits for the response until ctx is done
+func (d *DataBusClient) Request(ctx context.Context, command Command, queue string) (*Response, error) {
+	command.ReceiveQueue = queue
+	message, err := d.requesters.Get(d.Bus, queue).Request(ctx, func(correlationID string) error {
+		command.CorrelationID = correlationID
+		jsonStr, _ := json.Marshal(command)
+		return d.Bus.SendMessage(jsonStr, CommandQueue)
+	})
 	if err != nil {
-		log.Println("Error receiving message: ", err)
-		return ""
+		return nil, err
 	}
-	message := <-messages
-	//log.Println("Got message: ", message)
-	sub.Close()
-	return message
-}
-
-func (d *DataBusClient) GetResponse(queue string) *Response {
-	message := d.ReadOneMessage(queue)
 	resp := new(Response)
-	err := json.Unmarshal([]byte(message), resp)
+	err = json.Unmarshal([]byte(message), resp)
 	if err != nil {
-		log.Print("Error reading queue: ", err)
+		return nil, err
 	}
-
-	return resp
+	return resp, nil
 }
 
 func (d *DataBusClient) DeleteProducer(queue string, service auth.Service) {
@@ -241,19 +352,24 @@ func (d *DataBusClient) DeleteProducer(queue string, service auth.Service) {
 	d.SendCommand(command)
 }
 
-func (d *DataBusClient) GetProducers(queue string) []DataProducer {
+func (d *DataBusClient) GetProducers(ctx context.Context, queue string) ([]DataProducer, error) {
 	var command Command
 	command.Command = GETPRODUCERS
-	command.ReceiveQueue = queue
-	d.SendCommand(command)
-
-	resp := d.GetResponse(queue)
-	fmt.Printf("%+v", resp)
+	resp, err := d.Request(ctx, command, queue)
+	if err != nil {
+		return nil, err
+	}
 
 	producers := []DataProducer{}
-	mapstructure.Decode(resp.Data, &producers)
-	//	return resp.Data.([]DataProducer)
-	return producers
+	decoder, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339Nano),
+		Result:     &producers,
+	})
+	err = decoder.Decode(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	return producers, nil
 }
 
 func (d *DataBusClient) GetGroup(groups chan<- *DataGroup, queue string) {
@@ -267,15 +383,64 @@ func (d *DataBusClient) GetGroup(groups chan<- *DataGroup, queue string) {
 	}()
 	for {
 		message := <-messages
-		resp := new(Response)
-		err := json.Unmarshal([]byte(message), resp)
-		if err != nil {
-			log.Print("Error reading queue: ", err)
+		group := d.parseGroup(queue, message)
+		if group == nil {
+			continue
+		}
+		groups <- group
+	}
+}
+
+// ReceiveGroups calls handle with every group sent to queue until ctx is done. A message is acked once handle returns
+// nil, so on a bus with acknowledgements a group is only gone for good once it has been stored. If handle fails the
+// message is nacked and ReceiveGroups stops, returning the error.
+func (d *DataBusClient) ReceiveGroups(ctx context.Context, queue string, handle func(*DataGroup) error) error {
+	sub, err := messagebus.AsV2(d.Bus).Subscribe(ctx, queue)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	for msg := range sub.Messages() {
+		group := d.parseGroup(queue, string(msg.Body))
+		if group != nil {
+			if err := handle(group); err != nil {
+				_ = msg.Nack()
+				return err
+			}
+		}
+		if err := msg.Ack(); err != nil {
+			log.Printf("Failed to ack message on %s: %v", queue, err)
+		}
+	}
+	return sub.Err()
+}
+
+// parseGroup returns the group in message. A metric catalog is stored instead, and anything else is moved to the
+// dead letter queue, in which case nil is returned.
+func (d *DataBusClient) parseGroup(queue string, message string) *DataGroup {
+	resp := new(Response)
+	err := json.Unmarshal([]byte(message), resp)
+	if err == nil && resp.DataType == "MetricCatalog" {
+		err = d.setCatalog(resp.Data)
+		if err == nil {
+			return nil
 		}
+	}
+	if err == nil && resp.DataType != "DataGroup" {
+		err = fmt.Errorf("expected a DataGroup response, got data type %q", resp.DataType)
+	}
+	if err != nil {
+		messagebus.SendToDeadLetter(d.Bus, queue, message, err)
+		return nil
+	}
 
-		group := DataGroup{}
-		mapstructure.Decode(resp.Data, &group)
-		//		group := resp.Data.(DataGroup)
-		groups <- &group
+	group := DataGroup{}
+	err = mapstructure.Decode(resp.Data, &group)
+	if err != nil {
+		messagebus.SendToDeadLetter(d.Bus, queue, message, err)
+		return nil
 	}
+	//		group := resp.Data.(DataGroup)
+	return &group
 }
@@ -0,0 +1,489 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package databus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/memory"
+)
+
+func TestSubscribeFanOut(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	service := &DataBusService{Bus: mb}
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan *Command, 10)
+	go service.ReceiveCommand(commands) //nolint: errcheck
+
+	client.Subscribe("/prometheus")
+	client.Subscribe("/influx")
+	client.Subscribe("/influx")
+	client.Get("/influx")
+	select {
+	case command := <-commands:
+		if command.Command != GET {
+			t.Fatalf("got command %s, want %s", command.Command, GET)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for GET command")
+	}
+
+	prometheus := make(chan *DataGroup, 10)
+	influx := make(chan *DataGroup, 10)
+	go client.GetGroup(prometheus, "/prometheus")
+	go client.GetGroup(influx, "/influx")
+
+	service.SendGroup(DataGroup{ID: "PowerMetrics", System: "ABC1234"})
+	for _, groups := range []chan *DataGroup{prometheus, influx} {
+		select {
+		case group := <-groups:
+			if group.ID != "PowerMetrics" || group.System != "ABC1234" {
+				t.Errorf("got group %+v", group)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for group")
+		}
+	}
+	select {
+	case group := <-influx:
+		t.Errorf("duplicate subscription delivered group twice: %+v", group)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMalformedGroupDeadLettered(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	client := &DataBusClient{Bus: mb}
+	groups := make(chan *DataGroup, 10)
+	go client.GetGroup(groups, "/splunk")
+	deadLetters := make(chan string, 10)
+	_, _ = mb.ReceiveMessage(deadLetters, messagebus.DeadLetterQueue)
+
+	_ = mb.SendMessage([]byte(`{"command": "subscribe", "dataType": "DataGroup", "data": `), "/splunk")
+	_ = mb.SendMessage([]byte(`{"command": "subscribe", "dataType": "DataGroup", "data": {"ID": "ThermalMetrics"}}`), "/splunk")
+
+	select {
+	case message := <-deadLetters:
+		letter := new(messagebus.DeadLetter)
+		if err := json.Unmarshal([]byte(message), letter); err != nil {
+			t.Fatal(err)
+		}
+		if letter.Queue != "/splunk" || letter.Error == "" || letter.Timestamp.IsZero() ||
+			letter.Body != `{"command": "subscribe", "dataType": "DataGroup", "data": ` {
+			t.Errorf("got dead letter %+v", letter)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for dead letter")
+	}
+	select {
+	case group := <-groups:
+		if group.ID != "ThermalMetrics" {
+			t.Errorf("got group %+v, want only the well formed one", group)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for group")
+	}
+	if got := messagebus.MalformedMessages.Get("/splunk"); got == nil || got.String() != "1" {
+		t.Errorf("malformed counter is %v, want 1", got)
+	}
+}
+
+// A group the handler fails on is nacked and comes back on the next receive
+func TestReceiveGroupsAckAfterHandle(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	service := &DataBusService{Bus: mb}
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan *Command, 10)
+	go service.ReceiveCommand(commands) //nolint: errcheck
+	client.Subscribe("/kafka")
+	client.Get("/kafka")
+	select {
+	case <-commands:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for GET command")
+	}
+	service.SendGroup(DataGroup{ID: "PowerMetrics", System: "ABC1234"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := client.ReceiveGroups(ctx, "/kafka", func(group *DataGroup) error {
+		return context.DeadlineExceeded
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want the handler's", err)
+	}
+
+	var got []string
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = client.ReceiveGroups(ctx, "/kafka", func(group *DataGroup) error {
+		got = append(got, group.ID)
+		cancel()
+		return nil
+	})
+	if len(got) != 1 || got[0] != "PowerMetrics" {
+		t.Errorf("got groups %v after nack, want PowerMetrics once", got)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	for _, tc := range []struct {
+		value, dataType string
+		kind            string
+		number          float64
+		numeric         bool
+	}{
+		{"42", "", INT, 42, true},
+		{"42", "Decimal", FLOAT, 42, true},
+		{" 21.5 ", "", FLOAT, 21.5, true},
+		{"NaN", "", STRING, 0, false},
+		{"true", "", BOOL, 1, true},
+		{"False", "Boolean", BOOL, 0, true},
+		{"Up", "", ENUM, 1, true},
+		{"Degraded", "Enumeration", ENUM, 0, true},
+		{"Critical", "Enumeration", ENUM, 0, false},
+		{"Critical", "", STRING, 0, false},
+		{"", "", STRING, 0, false},
+	} {
+		v := DataValue{Value: tc.value}
+		v.ParseValue(tc.dataType)
+		if v.Kind != tc.kind || v.Number != tc.number {
+			t.Errorf("ParseValue(%q, %q) = %s %v, want %s %v", tc.value, tc.dataType, v.Kind, v.Number, tc.kind, tc.number)
+		}
+		if f, ok := v.Float(); f != tc.number || ok != tc.numeric {
+			t.Errorf("Float() of %q = %v %v, want %v %v", tc.value, f, ok, tc.number, tc.numeric)
+		}
+	}
+
+	// values from producers that do not type them are interpreted the same way
+	if f, ok := (DataValue{Value: "Operational"}).Float(); f != 1 || !ok {
+		t.Errorf("Float() of untyped Operational = %v %v, want 1 true", f, ok)
+	}
+}
+
+func TestCatalogDescribe(t *testing.T) {
+	catalog := &MetricCatalog{MetricDefinitions: map[string]MetricDefinition{
+		"CPUUsage": {ID: "CPUUsage", DataType: "Decimal", Units: "%", Accuracy: 0.5, Description: "CPU usage"},
+	}}
+
+	v := DataValue{ID: "CPUUsage", Value: "12"}
+	catalog.Describe(&v)
+	if v.Kind != FLOAT || v.Units != "%" {
+		t.Errorf("Describe(CPUUsage) = %+v", v)
+	}
+
+	var none *MetricCatalog
+	v = DataValue{ID: "CPUUsage", Value: "12"}
+	none.Describe(&v)
+	if v.Kind != INT || v.Units != "" {
+		t.Errorf("Describe on a nil catalog = %+v", v)
+	}
+}
+
+func TestSendCatalog(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	service := &DataBusService{Bus: mb}
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan *Command, 10)
+	go service.ReceiveCommand(commands) //nolint: errcheck
+
+	// the catalog goes to every subscriber, even those filtering out all reports
+	client.SubscribeWithFilter("/events", &Filter{EventsOnly: true})
+	client.Get("/events")
+	<-commands
+
+	groups := make(chan *DataGroup, 10)
+	go client.GetGroup(groups, "/events")
+
+	loaded := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	service.SendCatalog(MetricCatalog{System: "ABC1234", FwVer: "7.00.00.00", Loaded: loaded,
+		MetricDefinitions: map[string]MetricDefinition{
+			"CPUUsage": {ID: "CPUUsage", DataType: "Decimal", Units: "%", Description: "CPU usage"},
+		}})
+	service.SendGroup(DataGroup{ID: "Alert", System: "ABC1234", Events: []EventValue{{EventId: "1"}}})
+
+	select {
+	case group := <-groups:
+		if group.ID != "Alert" {
+			t.Errorf("got group %+v, want only the alert", group)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for group")
+	}
+	catalog := client.Catalog("ABC1234")
+	if catalog == nil || catalog.FwVer != "7.00.00.00" || !catalog.Loaded.Equal(loaded) ||
+		catalog.MetricDefinitions["CPUUsage"].Description != "CPU usage" {
+		t.Errorf("got catalog %+v", catalog)
+	}
+	if catalog := client.Catalog("XYZ9876"); catalog != nil {
+		t.Errorf("got catalog %+v for a system that sent none", catalog)
+	}
+}
+
+func TestCatalogReportInterval(t *testing.T) {
+	for interval, want := range map[string]time.Duration{
+		"PT0H1M0S": time.Minute,
+		"PT5S":     5 * time.Second,
+		"PT0.5S":   500 * time.Millisecond,
+		"P1DT12H":  36 * time.Hour,
+	} {
+		got, err := ParseRecurrenceInterval(interval)
+		if err != nil || got != want {
+			t.Errorf("ParseRecurrenceInterval(%q) = %v, %v, want %v", interval, got, err, want)
+		}
+	}
+	for _, interval := range []string{"", "P", "PT", "60s", "PT1X"} {
+		if _, err := ParseRecurrenceInterval(interval); err == nil {
+			t.Errorf("ParseRecurrenceInterval(%q) succeeded", interval)
+		}
+	}
+
+	catalog := &MetricCatalog{ReportDefinitions: map[string]MetricReportDefinition{
+		"Thermal": {ID: "Thermal", Type: "Periodic", RecurrenceInterval: "PT0H1M0S", Enabled: true},
+		"Power":   {ID: "Power", Type: "Periodic", RecurrenceInterval: "PT0H0M10S", Enabled: false},
+		"Fan":     {ID: "Fan", Type: "OnChange", RecurrenceInterval: "PT0H0M5S", Enabled: true},
+		"CPU":     {ID: "CPU", Type: "Periodic", RecurrenceInterval: "PT0H0M30S", Enabled: true},
+	}}
+	if got := catalog.ReportInterval(); got != 30*time.Second {
+		t.Errorf("ReportInterval() = %v, want 30s", got)
+	}
+	var none *MetricCatalog
+	if got := none.ReportInterval(); got != 0 {
+		t.Errorf("ReportInterval() on a nil catalog = %v", got)
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	service := &DataBusService{Bus: mb}
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan *Command, 10)
+	go service.ReceiveCommand(commands) //nolint: errcheck
+
+	client.Subscribe("/all")
+	client.SubscribeWithFilter("/thermal", &Filter{
+		IncludeReports: []string{"Thermal*"},
+		ExcludeMetrics: []string{"*Fan*"},
+		ExcludeSystems: []string{"XYZ9876"},
+	})
+	client.SubscribeWithFilter("/events", &Filter{EventsOnly: true})
+	client.Get("/thermal")
+	<-commands
+
+	all := make(chan *DataGroup, 10)
+	thermal := make(chan *DataGroup, 10)
+	events := make(chan *DataGroup, 10)
+	go client.GetGroup(all, "/all")
+	go client.GetGroup(thermal, "/thermal")
+	go client.GetGroup(events, "/events")
+
+	service.SendGroup(DataGroup{ID: "PowerMetrics", System: "ABC1234", Values: []DataValue{{ID: "SystemInputPower"}}})
+	service.SendGroup(DataGroup{ID: "ThermalSensor", System: "XYZ9876", Values: []DataValue{{ID: "TemperatureReading"}}})
+	service.SendGroup(DataGroup{ID: "ThermalSensor", System: "ABC1234", Values: []DataValue{{ID: "TemperatureReading"}, {ID: "FanSpeed"}}})
+	service.SendGroup(DataGroup{ID: "ThermalMetrics", System: "ABC1234", Values: []DataValue{{ID: "FanSpeed"}}})
+	service.SendGroup(DataGroup{ID: "Alert", System: "ABC1234", Events: []EventValue{{EventId: "1"}}})
+	service.SendGroup(DataGroup{ID: "Lifecycle", System: "ABC1234", LifecycleEvents: []LifecycleEvent{{EventId: "2",
+		NIC: &NICStats{ID: "NIC.Integrated.1-1-1", RxBytes: 1024}}}})
+	// filters apply to groups sent in reply to GET as well
+	service.SendGroupToQueue(DataGroup{ID: "PowerMetrics", System: "ABC1234"}, "/thermal")
+
+	receive := func(groups chan *DataGroup, want int) []*DataGroup {
+		var ret []*DataGroup
+		for len(ret) < want {
+			select {
+			case group := <-groups:
+				ret = append(ret, group)
+			case <-time.After(time.Second):
+				t.Fatalf("timed out after %d of %d groups", len(ret), want)
+			}
+		}
+		select {
+		case group := <-groups:
+			t.Errorf("got unexpected group %+v", group)
+		case <-time.After(50 * time.Millisecond):
+		}
+		return ret
+	}
+
+	receive(all, 6)
+	got := receive(thermal, 1)
+	if got[0].ID != "ThermalSensor" || got[0].System != "ABC1234" || len(got[0].Values) != 1 ||
+		got[0].Values[0].ID != "TemperatureReading" {
+		t.Errorf("got thermal group %+v", got[0])
+	}
+	got = receive(events, 2)
+	if got[0].ID != "Alert" {
+		t.Errorf("got events group %+v", got[0])
+	}
+	if got[1].ID != "Lifecycle" || len(got[1].LifecycleEvents) != 1 || got[1].LifecycleEvents[0].NIC == nil ||
+		got[1].LifecycleEvents[0].NIC.RxBytes != 1024 {
+		t.Errorf("got lifecycle group %+v", got[1])
+	}
+}
+
+func TestSubscriberLeases(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	service := &DataBusService{Bus: mb}
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan *Command, 10)
+	go service.ReceiveCommand(commands) //nolint: errcheck
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.Subscribe("/forever")
+	client.Subscribe("/gone")
+	client.SubscribeWithLease(ctx, "/leased", &Filter{EventsOnly: true}, time.Minute)
+	client.SubscribeWithLease(context.Background(), "/lapsed", nil, time.Minute)
+	client.Unsubscribe("/gone")
+
+	subscribers, err := client.GetSubscribers(context.Background(), "/subscribers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscribers) != 3 {
+		t.Fatalf("got subscribers %+v, want /forever, /leased and /lapsed", subscribers)
+	}
+	leased := subscribers[1]
+	if leased.Queue != "/leased" || leased.Lease != 60 || leased.Filter == nil || !leased.Filter.EventsOnly ||
+		time.Since(leased.LastRenewal) > time.Minute {
+		t.Errorf("got leased subscriber %+v", leased)
+	}
+
+	// let /lapsed miss its renewals, and stop /leased, which unsubscribes it
+	service.receiversMu.Lock()
+	service.receivers[2].LastRenewal = time.Now().Add(-2 * time.Minute)
+	service.receiversMu.Unlock()
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for len(service.Subscribers()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if subscribers := service.Subscribers(); len(subscribers) != 1 || subscribers[0].Queue != "/forever" {
+		t.Errorf("got subscribers %+v, want only /forever", subscribers)
+	}
+}
+
+func TestWaitUnsubscribed(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan string, 10)
+	_, _ = mb.ReceiveMessage(commands, CommandQueue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.SubscribeWithLease(ctx, "/influx", nil, time.Minute)
+	if client.WaitUnsubscribed(50 * time.Millisecond) {
+		t.Fatal("WaitUnsubscribed returned true while the subscription is still running")
+	}
+	cancel()
+	if !client.WaitUnsubscribed(time.Second) {
+		t.Fatal("timed out waiting to unsubscribe")
+	}
+
+	for {
+		select {
+		case message := <-commands:
+			var command Command
+			_ = json.Unmarshal([]byte(message), &command)
+			if command.Command == UNSUBSCRIBE && command.ReceiveQueue == "/influx" {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no UNSUBSCRIBE of /influx was sent")
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	mb, _ := memory.NewMemoryMessageBus()
+	defer mb.Close()
+
+	service := &DataBusService{Bus: mb}
+	client := &DataBusClient{Bus: mb}
+	commands := make(chan *Command, 10)
+	go service.ReceiveCommand(commands) //nolint: errcheck
+
+	// answer GETPRODUCERS in reverse order, and leave the last one unanswered
+	go func() {
+		var pending []*Command
+		for command := range commands {
+			pending = append(pending, command)
+			if len(pending) == 3 {
+				break
+			}
+		}
+		for i := 1; i >= 0; i-- {
+			service.Reply(pending[i], "DataProducer", []DataProducer{{Hostname: pending[i].CorrelationID}})
+		}
+	}()
+
+	results := make(chan []DataProducer, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			producers, err := client.GetProducers(context.Background(), "/configui/databus_in")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- producers
+		}()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.GetProducers(ctx, "/configui/databus_in"); err != context.DeadlineExceeded {
+		t.Errorf("unanswered request returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case producers := <-results:
+			if len(producers) != 1 || producers[0].Hostname == "" || seen[producers[0].Hostname] {
+				t.Errorf("got producers %+v", producers)
+			} else {
+				seen[producers[0].Hostname] = true
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for producers")
+		}
+	}
+}
+
+func TestTelemetryReportChanges(t *testing.T) {
+	on, off := true, false
+	config := TelemetryConfig{Reports: []ReportConfig{
+		{ID: "*", Enabled: &off},
+		{ID: "Thermal*", Enabled: &on, RecurrenceInterval: "PT60S"},
+	}}
+	changes := config.ReportChanges([]MetricReportDefinition{
+		{ID: "PowerMetrics", Enabled: true},
+		{ID: "ThermalSensor", Enabled: true, RecurrenceInterval: "PT60S"},
+		{ID: "ThermalMetrics", Enabled: false, RecurrenceInterval: "PT30S"},
+		{ID: "CPUMemMetrics", Enabled: false},
+	})
+	if len(changes) != 2 {
+		t.Fatalf("ReportChanges() = %+v, want 2 changes", changes)
+	}
+	if changes[0].ID != "PowerMetrics" || changes[0].Enabled == nil || *changes[0].Enabled || changes[0].RecurrenceInterval != "" {
+		t.Errorf("PowerMetrics change = %+v, want disabled", changes[0])
+	}
+	if changes[1].ID != "ThermalMetrics" || changes[1].Enabled == nil || !*changes[1].Enabled || changes[1].RecurrenceInterval != "PT60S" {
+		t.Errorf("ThermalMetrics change = %+v, want enabled every PT60S", changes[1])
+	}
+}
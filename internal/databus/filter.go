@@ -0,0 +1,105 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package databus
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Filter limits what a SUBSCRIBE receives. Every pattern is a glob as understood by path.Match, so plain IDs match
+// themselves. A group is sent when it matches at least one pattern of each non-empty include list and no pattern of
+// the exclude lists. The metric lists drop values rather than groups; a group left without values or events is not
+// sent at all.
+type Filter struct {
+	IncludeReports []string `json:"includeReports,omitempty"`
+	ExcludeReports []string `json:"excludeReports,omitempty"`
+	IncludeMetrics []string `json:"includeMetrics,omitempty"`
+	ExcludeMetrics []string `json:"excludeMetrics,omitempty"`
+	IncludeSystems []string `json:"includeSystems,omitempty"`
+	ExcludeSystems []string `json:"excludeSystems,omitempty"`
+	IncludeModels  []string `json:"includeModels,omitempty"`
+	ExcludeModels  []string `json:"excludeModels,omitempty"`
+	EventsOnly     bool     `json:"eventsOnly,omitempty"`
+}
+
+// FilterFromEnv builds a Filter from the DATABUS_INCLUDE_* and DATABUS_EXCLUDE_* comma separated lists (REPORTS,
+// METRICS, SYSTEMS and MODELS) and DATABUS_EVENTS_ONLY. It returns nil when none of them is set.
+func FilterFromEnv() *Filter {
+	ret := new(Filter)
+	set := false
+	for name, list := range map[string]*[]string{
+		"DATABUS_INCLUDE_REPORTS": &ret.IncludeReports,
+		"DATABUS_EXCLUDE_REPORTS": &ret.ExcludeReports,
+		"DATABUS_INCLUDE_METRICS": &ret.IncludeMetrics,
+		"DATABUS_EXCLUDE_METRICS": &ret.ExcludeMetrics,
+		"DATABUS_INCLUDE_SYSTEMS": &ret.IncludeSystems,
+		"DATABUS_EXCLUDE_SYSTEMS": &ret.ExcludeSystems,
+		"DATABUS_INCLUDE_MODELS":  &ret.IncludeModels,
+		"DATABUS_EXCLUDE_MODELS":  &ret.ExcludeModels,
+	} {
+		for _, pattern := range strings.Split(os.Getenv(name), ",") {
+			pattern = strings.TrimSpace(pattern)
+			if len(pattern) > 0 {
+				*list = append(*list, pattern)
+				set = true
+			}
+		}
+	}
+	if os.Getenv("DATABUS_EVENTS_ONLY") == "true" {
+		ret.EventsOnly = true
+		set = true
+	}
+	if !set {
+		return nil
+	}
+	return ret
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func included(include []string, exclude []string, s string) bool {
+	if len(include) > 0 && !matchAny(include, s) {
+		return false
+	}
+	return !matchAny(exclude, s)
+}
+
+// Apply returns the part of group that passes the filter and whether anything is left to send. group itself is not
+// modified. A nil Filter passes everything.
+func (f *Filter) Apply(group *DataGroup) (*DataGroup, bool) {
+	if f == nil {
+		return group, true
+	}
+	if f.EventsOnly && len(group.Events) == 0 && len(group.LifecycleEvents) == 0 {
+		return nil, false
+	}
+	if !included(f.IncludeReports, f.ExcludeReports, group.ID) ||
+		!included(f.IncludeSystems, f.ExcludeSystems, group.System) ||
+		!included(f.IncludeModels, f.ExcludeModels, group.Model) {
+		return nil, false
+	}
+	if len(f.IncludeMetrics) == 0 && len(f.ExcludeMetrics) == 0 {
+		return group, true
+	}
+
+	ret := *group
+	ret.Values = nil
+	for _, value := range group.Values {
+		if included(f.IncludeMetrics, f.ExcludeMetrics, value.ID) {
+			ret.Values = append(ret.Values, value)
+		}
+	}
+	if len(ret.Values) == 0 && len(ret.Events) == 0 && len(ret.LifecycleEvents) == 0 {
+		return nil, false
+	}
+	return &ret, true
+}
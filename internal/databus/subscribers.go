@@ -0,0 +1,187 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package databus
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// DefaultLease is the lease SubscribeWithLease asks for. Subscriptions without a lease never expire.
+const DefaultLease = 5 * time.Minute
+
+// UnsubscribeTimeout is how long ExitOnSignal waits for the lease subscriptions to unsubscribe
+const UnsubscribeTimeout = 5 * time.Second
+
+// Subscriber is a queue receiving groups from DataBusService
+type Subscriber struct {
+	Queue  string
+	Filter *Filter `json:",omitempty"`
+	// Lease is how many seconds the subscription lasts without being renewed, 0 if it never expires
+	Lease       int
+	LastRenewal time.Time
+}
+
+func (s *Subscriber) expired(now time.Time) bool {
+	return s.Lease > 0 && now.Sub(s.LastRenewal) > time.Duration(s.Lease)*time.Second
+}
+
+// subscribe adds the command's queue to the receivers, or renews it and replaces its filter and lease if it is
+// already there
+func (d *DataBusService) subscribe(command *Command) {
+	d.receiversMu.Lock()
+	defer d.receiversMu.Unlock()
+	for _, rec := range d.receivers {
+		if rec.Queue == command.ReceiveQueue {
+			rec.Filter = command.Filter
+			rec.Lease = command.Lease
+			rec.LastRenewal = time.Now()
+			return
+		}
+	}
+	d.receivers = append(d.receivers, &Subscriber{
+		Queue:       command.ReceiveQueue,
+		Filter:      command.Filter,
+		Lease:       command.Lease,
+		LastRenewal: time.Now(),
+	})
+}
+
+func (d *DataBusService) unsubscribe(queue string) {
+	d.receiversMu.Lock()
+	defer d.receiversMu.Unlock()
+	for i, rec := range d.receivers {
+		if rec.Queue == queue {
+			d.receivers = append(d.receivers[:i], d.receivers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Subscribers returns a copy of the current receivers, dropping any whose lease has lapsed
+func (d *DataBusService) Subscribers() []Subscriber {
+	d.receiversMu.Lock()
+	defer d.receiversMu.Unlock()
+	now := time.Now()
+	live := d.receivers[:0]
+	ret := []Subscriber{}
+	for _, rec := range d.receivers {
+		if rec.expired(now) {
+			log.Printf("Subscription lease for %s expired, last renewed %s", rec.Queue, rec.LastRenewal.Format(time.RFC3339))
+			continue
+		}
+		live = append(live, rec)
+		ret = append(ret, *rec)
+	}
+	for i := len(live); i < len(d.receivers); i++ {
+		d.receivers[i] = nil
+	}
+	d.receivers = live
+	return ret
+}
+
+func (d *DataBusService) getSubscriber(queue string) (Subscriber, bool) {
+	for _, rec := range d.Subscribers() {
+		if rec.Queue == queue {
+			return rec, true
+		}
+	}
+	return Subscriber{}, false
+}
+
+func (d *DataBusClient) Unsubscribe(queue string) {
+	var command Command
+	command.Command = UNSUBSCRIBE
+	command.ReceiveQueue = queue
+	d.SendCommand(command)
+}
+
+// SubscribeWithLease subscribes queue with a lease and renews it every third of the lease until ctx is done, then
+// unsubscribes. If the process dies without unsubscribing the subscription lapses on its own.
+func (d *DataBusClient) SubscribeWithLease(ctx context.Context, queue string, filter *Filter, lease time.Duration) {
+	var command Command
+	command.Command = SUBSCRIBE
+	command.ReceiveQueue = queue
+	command.Filter = filter
+	command.Lease = int(lease / time.Second)
+	d.SendCommand(command)
+
+	d.leases.Add(1)
+	go func() {
+		defer d.leases.Done()
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.SendCommand(command)
+			case <-ctx.Done():
+				d.Unsubscribe(queue)
+				return
+			}
+		}
+	}()
+}
+
+// WaitUnsubscribed waits up to timeout for every SubscribeWithLease subscription to have unsubscribed, which they do
+// once their context is done, and reports whether they all did
+func (d *DataBusClient) WaitUnsubscribed(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.leases.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// ExitOnSignal returns the context to subscribe with. On SIGINT or SIGTERM it is cancelled, and the process exits
+// once the subscriptions made with it have unsubscribed, or UnsubscribeTimeout has passed, so a pump that is stopped
+// does not leave its queue filling up until the lease runs out.
+func (d *DataBusClient) ExitOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, unsubscribing before exit", sig)
+		cancel()
+		if !d.WaitUnsubscribed(UnsubscribeTimeout) {
+			log.Printf("Gave up waiting to unsubscribe after %v", UnsubscribeTimeout)
+		}
+		d.Bus.Close()
+		os.Exit(0)
+	}()
+	return ctx
+}
+
+// GetSubscribers asks DataBusService for its receivers, with the answer sent to queue
+func (d *DataBusClient) GetSubscribers(ctx context.Context, queue string) ([]Subscriber, error) {
+	var command Command
+	command.Command = GETSUBSCRIBERS
+	resp, err := d.Request(ctx, command, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers := []Subscriber{}
+	decoder, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339Nano),
+		Result:     &subscribers,
+	})
+	err = decoder.Decode(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
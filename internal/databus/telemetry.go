@@ -0,0 +1,110 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package databus
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ReportConfig is the wanted state of the reports whose ID matches the glob ID. Enabled nil or an empty
+// RecurrenceInterval leave that setting as it is.
+type ReportConfig struct {
+	ID                 string `json:"id"`
+	Enabled            *bool  `json:"enabled,omitempty"`
+	RecurrenceInterval string `json:"recurrenceInterval,omitempty"`
+}
+
+// TelemetryConfig is the wanted telemetry state of a device, as sent with SETTELEMETRY or read from a profile.
+// Reports are applied in order, so a catch all such as {"id": "*", "enabled": false} goes first.
+type TelemetryConfig struct {
+	ServiceEnabled *bool          `json:"serviceEnabled,omitempty"`
+	Reports        []ReportConfig `json:"reports,omitempty"`
+}
+
+// TelemetryState is the telemetry state of a device as GETTELEMETRY and SETTELEMETRY report it. Errors lists what
+// could not be read or changed.
+type TelemetryState struct {
+	ServiceIP      string
+	ServiceEnabled bool
+	Reports        []MetricReportDefinition
+	Errors         []string `json:",omitempty"`
+}
+
+// ReportChanges works out the changes needed to bring reports to the configured state, one per report that differs,
+// sorted by report ID
+func (c *TelemetryConfig) ReportChanges(reports []MetricReportDefinition) []ReportConfig {
+	wanted := make(map[string]MetricReportDefinition)
+	for _, report := range reports {
+		wanted[report.ID] = report
+	}
+	for _, config := range c.Reports {
+		for id, report := range wanted {
+			if ok, _ := path.Match(config.ID, id); !ok {
+				continue
+			}
+			if config.Enabled != nil {
+				report.Enabled = *config.Enabled
+			}
+			if config.RecurrenceInterval != "" {
+				report.RecurrenceInterval = config.RecurrenceInterval
+			}
+			wanted[id] = report
+		}
+	}
+
+	ret := []ReportConfig{}
+	for _, report := range reports {
+		want := wanted[report.ID]
+		change := ReportConfig{ID: report.ID}
+		if want.Enabled != report.Enabled {
+			change.Enabled = &want.Enabled
+		}
+		if want.RecurrenceInterval != report.RecurrenceInterval {
+			change.RecurrenceInterval = want.RecurrenceInterval
+		}
+		if change.Enabled != nil || change.RecurrenceInterval != "" {
+			ret = append(ret, change)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+func (d *DataBusClient) telemetryRequest(ctx context.Context, command Command, queue string) (*TelemetryState, error) {
+	resp, err := d.Request(ctx, command, queue)
+	if err != nil {
+		return nil, err
+	}
+	if resp.DataType != "TelemetryState" {
+		return nil, fmt.Errorf("%s: %v", command.ServiceIP, resp.Data)
+	}
+	state := new(TelemetryState)
+	err = mapstructure.Decode(resp.Data, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// GetTelemetry asks for the telemetry state of the device at serviceIP, with the answer sent to queue
+func (d *DataBusClient) GetTelemetry(ctx context.Context, queue string, serviceIP string) (*TelemetryState, error) {
+	var command Command
+	command.Command = GETTELEMETRY
+	command.ServiceIP = serviceIP
+	return d.telemetryRequest(ctx, command, queue)
+}
+
+// SetTelemetry applies config to the device at serviceIP and returns the state it is left in, with the answer sent
+// to queue
+func (d *DataBusClient) SetTelemetry(ctx context.Context, queue string, serviceIP string, config TelemetryConfig) (*TelemetryState, error) {
+	var command Command
+	command.Command = SETTELEMETRY
+	command.ServiceIP = serviceIP
+	command.Telemetry = &config
+	return d.telemetryRequest(ctx, command, queue)
+}
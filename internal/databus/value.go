@@ -0,0 +1,88 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package databus
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value kinds, set in DataValue.Kind by ParseValue
+const (
+	FLOAT  = "Float"
+	INT    = "Int"
+	BOOL   = "Bool"
+	ENUM   = "Enum"
+	STRING = "String"
+)
+
+// enumNumbers maps the two state enumeration values found in metric reports to a number, compared case insensitively.
+// Anything not listed here has no numeric interpretation.
+var enumNumbers = map[string]float64{
+	"up":          1,
+	"down":        0,
+	"operational": 1,
+	"degraded":    0,
+	"enabled":     1,
+	"disabled":    0,
+	"on":          1,
+	"off":         0,
+	"linkup":      1,
+	"linkdown":    0,
+}
+
+// ParseValue sets Kind, Number and Int from Value. dataType is the MetricDataType from the metric definition
+// ("Integer", "Decimal", "Boolean", "Enumeration", "String" or "DateTime") and may be empty. It only settles what the
+// value alone cannot tell: a whole number of a Decimal metric stays a FLOAT and an unknown word of an Enumeration
+// metric is an ENUM rather than a STRING.
+func (v *DataValue) ParseValue(dataType string) {
+	v.Kind = STRING
+	v.Number = 0
+	v.Int = 0
+
+	raw := strings.TrimSpace(v.Value)
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil && dataType != "Decimal" {
+		v.Kind = INT
+		v.Int = i
+		v.Number = float64(i)
+		return
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		v.Kind = FLOAT
+		v.Number = f
+		return
+	}
+	if b, err := strconv.ParseBool(strings.ToLower(raw)); err == nil && (dataType == "Boolean" || len(raw) > 1) {
+		v.Kind = BOOL
+		if b {
+			v.Number = 1
+		}
+		return
+	}
+	if n, ok := enumNumbers[strings.ToLower(raw)]; ok {
+		v.Kind = ENUM
+		v.Number = n
+		return
+	}
+	if dataType == "Enumeration" {
+		v.Kind = ENUM
+	}
+}
+
+// Float returns the numeric interpretation of the value shared by all pumps, and false when it has none, in which
+// case the value should be skipped rather than reported as 0. Values from producers that do not set Kind are parsed
+// here.
+func (v DataValue) Float() (float64, bool) {
+	if v.Kind == "" {
+		v.ParseValue("")
+	}
+	switch v.Kind {
+	case FLOAT, INT, BOOL:
+		return v.Number, true
+	case ENUM:
+		n, ok := enumNumbers[strings.ToLower(strings.TrimSpace(v.Value))]
+		return n, ok
+	}
+	return 0, false
+}
@@ -65,7 +65,8 @@ func (d *DiscoveryService) ReceiveCommand(commands chan<- *Command) {
 		command := new(Command)
 		err := json.Unmarshal([]byte(message), command)
 		if err != nil {
-			log.Print("Error reading command queue: ", err)
+			messagebus.SendToDeadLetter(d.Bus, CommandQueue, message, err)
+			continue
 		}
 		commands <- command
 	}
@@ -103,7 +104,8 @@ func (d *DiscoveryClient) GetService(services chan<- *Service) {
 		service := new(Service)
 		err := json.Unmarshal([]byte(message), service)
 		if err != nil {
-			log.Print("Error reading discovery queue: ", err)
+			messagebus.SendToDeadLetter(d.Bus, EventQueue, message, err)
+			continue
 		}
 		services <- service
 	}
@@ -4,7 +4,9 @@ package amqp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 
@@ -19,12 +21,41 @@ type AmqpMessagebus struct {
 	session *amqp.Session
 }
 
+type AmqpSubscription struct {
+	receiver *amqp.Receiver
+	cancel   context.CancelFunc
+}
+
+func init() {
+	messagebus.Register(messagebus.AMQP, func(cfg *messagebus.Config) (messagebus.Messagebus, error) {
+		var tlsConfig *tls.Config
+		if cfg.TLS != nil {
+			var err error
+			tlsConfig, err = cfg.TLS.ClientConfig()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewAmqpMessageBusTLS(cfg.Host, cfg.Port, tlsConfig)
+	})
+}
+
 func NewAmqpMessageBus(host string, port int) (messagebus.Messagebus, error) {
+	return NewAmqpMessageBusTLS(host, port, nil)
+}
+
+// NewAmqpMessageBusTLS is NewAmqpMessageBus over amqps. A nil tlsConfig means plain TCP.
+func NewAmqpMessageBusTLS(host string, port int, tlsConfig *tls.Config) (messagebus.Messagebus, error) {
 	ret := new(AmqpMessagebus)
 
 	amqpAddress := fmt.Sprintf("amqp://%s:%d", host, port)
+	opts := []amqp.ConnOption{amqp.ConnSASLAnonymous()}
+	if tlsConfig != nil {
+		amqpAddress = fmt.Sprintf("amqps://%s:%d", host, port)
+		opts = append(opts, amqp.ConnTLSConfig(tlsConfig))
+	}
 
-	client, err := amqp.Dial(amqpAddress, amqp.ConnSASLAnonymous())
+	client, err := amqp.Dial(amqpAddress, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -93,28 +124,47 @@ func (m *AmqpMessagebus) ReceiveMessage(message chan<- string, queue string) (me
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		ctx, cancel := context.WithTimeout(m.ctx, 1*time.Second)
-		receiver.Close(ctx)
-		cancel()
-	}()
 
+	mySub := new(AmqpSubscription)
+	mySub.receiver = receiver
+	ctx, cancel := context.WithCancel(m.ctx)
+	mySub.cancel = cancel
+	go m.RecieveLoop(ctx, receiver, message)
+	return messagebus.Subscription(mySub), nil
+}
+
+func (m *AmqpMessagebus) RecieveLoop(ctx context.Context, receiver *amqp.Receiver, message chan<- string) {
 	for {
 		// Receive next message
-		msg, err := receiver.Receive(m.ctx)
+		msg, err := receiver.Receive(ctx)
 		if err != nil {
-			return nil, err
+			if ctx.Err() == nil {
+				log.Printf("Failed to receive message %v", err)
+			}
+			return
 		}
 
 		// Accept message
 		err = msg.Accept()
 		if err != nil {
-			return nil, err
+			log.Printf("Failed to accept message %v", err)
+			return
+		}
+		select {
+		case message <- string(msg.GetData()):
+		case <-ctx.Done():
+			return
 		}
-		message <- string(msg.GetData())
 	}
 }
 
 func (m *AmqpMessagebus) Close() error {
 	return m.client.Close()
 }
+
+func (s *AmqpSubscription) Close() error {
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	return s.receiver.Close(ctx)
+}
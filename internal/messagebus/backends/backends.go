@@ -0,0 +1,13 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+// Package backends registers every message bus implementation with messagebus.New. Services import it for its side
+// effects so MESSAGEBUS_TYPE can select any backend without code changes.
+package backends
+
+import (
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/amqp"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/kafka"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/memory"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/mqtt"
+	_ "github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus/stomp"
+)
@@ -0,0 +1,206 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package messagebus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	STOMP  = "stomp"
+	AMQP   = "amqp"
+	KAFKA  = "kafka"
+	MEMORY = "memory"
+	MQTT   = "mqtt"
+)
+
+const DefaultType = STOMP
+
+type TLSConfig struct {
+	ServerCA   string
+	ClientCert string
+	ClientKey  string
+	SkipVerify bool // skip hostname check
+}
+
+// ClientConfig builds the tls.Config for a client connection. Without a ServerCA the system roots are used.
+func (t *TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.SkipVerify,
+	}
+	if t.ServerCA != "" {
+		ca, err := os.ReadFile(t.ServerCA)
+		if err != nil {
+			log.Println("failed to load server CA cert", err)
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(ca); !ok {
+			log.Println("Unable to append cert to pool")
+		}
+		config.RootCAs = pool
+	}
+
+	// Client Authentication - optional
+	if t.ClientCert != "" && t.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			log.Println("failed to load client cert/key", err)
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &config, nil
+}
+
+// SASL mechanisms understood by the backends that support SASL
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// SASLConfig holds broker credentials. An empty Mechanism disables SASL.
+type SASLConfig struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// Config selects a message bus backend and carries the options it needs. Fields a backend does not use are ignored.
+type Config struct {
+	Type string
+	Host string
+	Port int
+	TLS  *TLSConfig
+	SASL *SASLConfig
+
+	// Username and Password authenticate to brokers that take plain credentials, such as MQTT
+	Username string
+	Password string
+
+	// Kafka only. Topic and Partition pick the connection opened up front; queues map to topics on demand.
+	// Subscriptions join GroupID, or a consumer group named after the topic when it is empty. A new group starts at
+	// the end of its topic so it never replays old commands or replies, except for the queues in ReplayQueues, which
+	// are read from the oldest retained message.
+	Topic        string
+	Partition    int
+	GroupID      string
+	ReplayQueues []string
+}
+
+// NewConfig returns a Config for the given backend with its options loaded from the environment
+func NewConfig(busType string, host string, port int) *Config {
+	ret := new(Config)
+	ret.Type = busType
+	ret.Host = host
+	ret.Port = port
+	ret.LoadOptionsFromEnv()
+	return ret
+}
+
+// Factory creates a message bus of one backend type
+type Factory func(cfg *Config) (Messagebus, error)
+
+var factoriesMu sync.RWMutex
+var factories = map[string]Factory{}
+
+// Register makes a backend available to New. Backend packages call this from init, so a binary only needs to import
+// the backends it wants, usually through the messagebus/backends package.
+func Register(busType string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[strings.ToLower(busType)] = factory
+}
+
+// New creates a message bus of the type named in cfg, defaulting to STOMP
+func New(cfg *Config) (Messagebus, error) {
+	busType := strings.ToLower(cfg.Type)
+	if busType == "" {
+		busType = DefaultType
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[busType]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown message bus type %q, registered types are %v", cfg.Type, registeredTypes())
+	}
+	bus, err := factory(cfg)
+	if stateful, ok := bus.(StatefulMessagebus); ok && err == nil {
+		publishState(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), stateful)
+	}
+	return bus, err
+}
+
+func registeredTypes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	ret := make([]string, 0, len(factories))
+	for busType := range factories {
+		ret = append(ret, busType)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// LoadOptionsFromEnv fills the backend specific options from MESSAGEBUS_* environment variables. Type, host and
+// port are left to each service's own getEnvSettings so its usual precedence rules still apply.
+func (c *Config) LoadOptionsFromEnv() {
+	caCert := os.Getenv("MESSAGEBUS_TLS_CACERT")
+	clientCert := os.Getenv("MESSAGEBUS_TLS_CLIENT_CERT")
+	clientKey := os.Getenv("MESSAGEBUS_TLS_CLIENT_KEY")
+	skipVerify := os.Getenv("MESSAGEBUS_TLS_SKIP_VERIFY")
+	if len(caCert) > 0 || len(clientCert) > 0 || len(skipVerify) > 0 {
+		if c.TLS == nil {
+			c.TLS = new(TLSConfig)
+		}
+		c.TLS.ServerCA = caCert
+		c.TLS.ClientCert = clientCert
+		c.TLS.ClientKey = clientKey
+		c.TLS.SkipVerify = skipVerify == "true"
+	}
+	username := os.Getenv("MESSAGEBUS_USERNAME")
+	if len(username) > 0 {
+		c.Username = username
+		c.Password = os.Getenv("MESSAGEBUS_PASSWORD")
+	}
+	saslMechanism := os.Getenv("MESSAGEBUS_SASL_MECHANISM")
+	if len(saslMechanism) > 0 {
+		c.SASL = &SASLConfig{
+			Mechanism: saslMechanism,
+			Username:  os.Getenv("MESSAGEBUS_SASL_USERNAME"),
+			Password:  os.Getenv("MESSAGEBUS_SASL_PASSWORD"),
+		}
+	}
+	topic := os.Getenv("MESSAGEBUS_KAFKA_TOPIC")
+	if len(topic) > 0 {
+		c.Topic = topic
+	}
+	partition := os.Getenv("MESSAGEBUS_KAFKA_PARTITION")
+	if len(partition) > 0 {
+		c.Partition, _ = strconv.Atoi(partition)
+	}
+	groupID := os.Getenv("MESSAGEBUS_KAFKA_GROUP_ID")
+	if len(groupID) > 0 {
+		c.GroupID = groupID
+	}
+	replayQueues := os.Getenv("MESSAGEBUS_KAFKA_REPLAY_QUEUES")
+	if len(replayQueues) > 0 {
+		c.ReplayQueues = nil
+		for _, queue := range strings.Split(replayQueues, ",") {
+			queue = strings.TrimSpace(queue)
+			if queue != "" {
+				c.ReplayQueues = append(c.ReplayQueues, queue)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package messagebus
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultDeadLetterQueue receives messages that a service could not parse, unless MESSAGEBUS_DEADLETTER_QUEUE
+// names another queue
+const DefaultDeadLetterQueue = "/deadletter"
+
+// DeadLetterQueue is where SendToDeadLetter routes malformed messages
+var DeadLetterQueue = DefaultDeadLetterQueue
+
+// MalformedMessages counts malformed messages per source queue. It is published through expvar, so any service
+// serving expvar.Handler exposes it as "malformed_messages".
+var MalformedMessages = expvar.NewMap("malformed_messages")
+
+// DeadLetter wraps a message that could not be parsed together with where it came from and why it was rejected
+type DeadLetter struct {
+	Queue     string    `json:"queue"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+	Body      string    `json:"body"`
+}
+
+func init() {
+	queue := os.Getenv("MESSAGEBUS_DEADLETTER_QUEUE")
+	if len(queue) > 0 {
+		DeadLetterQueue = queue
+	}
+}
+
+// SendToDeadLetter counts a message read from queue that could not be parsed and moves it to the dead letter queue
+// with the original body, so it can be inspected and replayed instead of being passed on as an empty value.
+func SendToDeadLetter(bus Messagebus, queue string, body string, parseErr error) {
+	MalformedMessages.Add(queue, 1)
+	log.Printf("Malformed message on %s moved to %s: %v", queue, DeadLetterQueue, parseErr)
+	if queue == DeadLetterQueue {
+		// never loop a broken dead letter back onto its own queue
+		return
+	}
+
+	letter := DeadLetter{
+		Queue:     queue,
+		Error:     parseErr.Error(),
+		Timestamp: time.Now().UTC(),
+		Body:      body,
+	}
+	jsonStr, _ := json.Marshal(letter)
+	err := bus.SendMessage(jsonStr, DeadLetterQueue)
+	if err != nil {
+		log.Printf("Failed to send message to dead letter queue %v", err)
+	}
+}
@@ -3,8 +3,7 @@ package kafka
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,78 +14,106 @@ import (
 	"time"
 
 	kafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
 )
 
-const KafkaMaxMessageBytes = 16 * 1024
+var ErrBusClosed = errors.New("kafka message bus closed")
+
+// KafkaMaxMessageBytes caps the size of a fetch from one partition, so it must be larger than any message we send
+const KafkaMaxMessageBytes = 10 * 1024 * 1024
 
 type KafkaMessagebus struct {
 	conns       map[string]*kafka.Conn // cache of connections made at first read/write message, mapped by topic name
 	addr        string
+	groupID     string
 	ctx         context.Context
 	dialer      *kafka.Dialer
 	topicConnMu sync.RWMutex
+	subsMu      sync.Mutex
+	subs        map[*KafkaSubscription]struct{}
+	// replayTopics are read from the oldest retained message by a new consumer group, every other topic from the end
+	replayTopics map[string]bool
 }
 
+// KafkaSubscription consumes one topic as a member of a consumer group. Every partition of the topic is read and
+// the group offset is committed once a message has been handed to a ReceiveMessage subscriber, or acked by a
+// Subscribe one, so a restarted consumer resumes after the last message it finished with and replicas sharing the
+// group split the partitions between them.
 type KafkaSubscription struct {
+	bus    *KafkaMessagebus
+	reader *kafka.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+	// busClosed ends a Subscribe subscription when the bus is closed under it
+	busClosed func(error)
 }
 
-type KafkaTLSConfig struct {
-	ServerCA   string
-	ClientCert string
-	ClientKey  string
-	SkipVerify bool // skip hostname check
+type KafkaTLSConfig = messagebus.TLSConfig
+
+type KafkaSASLConfig = messagebus.SASLConfig
+
+func init() {
+	messagebus.Register(messagebus.KAFKA, func(cfg *messagebus.Config) (messagebus.Messagebus, error) {
+		ret, err := newKafkaMessageBus(cfg.Host, cfg.Port, cfg.Topic, cfg.Partition, cfg.GroupID, cfg.TLS, cfg.SASL)
+		if err != nil {
+			return nil, err
+		}
+		for _, queue := range cfg.ReplayQueues {
+			ret.replayTopics[TopicName(queue)] = true
+		}
+		return messagebus.Messagebus(ret), nil
+	})
+}
+
+func NewKafkaMessageBus(host string, port int, topic string, partition int, tlsCfg *KafkaTLSConfig, saslCfg *KafkaSASLConfig) (messagebus.Messagebus, error) {
+	return NewKafkaMessageBusGroup(host, port, topic, partition, "", tlsCfg, saslCfg)
 }
 
-func NewKafkaMessageBus(host string, port int, topic string, partition int, tlsCfg *KafkaTLSConfig) (messagebus.Messagebus, error) {
+// NewKafkaMessageBusGroup is NewKafkaMessageBus with an explicit consumer group. Subscriptions join groupID, or a
+// group named after the topic when it is empty, so that every replica of a service shares the same group.
+func NewKafkaMessageBusGroup(host string, port int, topic string, partition int, groupID string, tlsCfg *KafkaTLSConfig, saslCfg *KafkaSASLConfig) (messagebus.Messagebus, error) {
+	ret, err := newKafkaMessageBus(host, port, topic, partition, groupID, tlsCfg, saslCfg)
+	if err != nil {
+		return nil, err
+	}
+	return messagebus.Messagebus(ret), nil
+}
+
+func newKafkaMessageBus(host string, port int, topic string, partition int, groupID string, tlsCfg *KafkaTLSConfig, saslCfg *KafkaSASLConfig) (*KafkaMessagebus, error) {
 	ret := new(KafkaMessagebus)
 	ret.addr = fmt.Sprintf("%s:%d", host, port)
+	ret.groupID = groupID
 	ret.ctx = context.Background()
 	ret.conns = map[string]*kafka.Conn{}
 	ret.topicConnMu = sync.RWMutex{}
+	ret.subs = map[*KafkaSubscription]struct{}{}
+	ret.replayTopics = map[string]bool{}
 
 	dialer := &kafka.Dialer{
 		Timeout:   10 * time.Second,
 		DualStack: true,
 	}
 
-	// TLS
-	if tlsCfg != nil && tlsCfg.ServerCA != "" {
-		ca, err := os.ReadFile(tlsCfg.ServerCA)
+	// TLS, with the system roots unless a ServerCA is given
+	if tlsCfg != nil {
+		config, err := tlsCfg.ClientConfig()
 		if err != nil {
-			log.Println("failed to load server CA cert", err)
 			return nil, err
 		}
-		pool := x509.NewCertPool()
-		if ok := pool.AppendCertsFromPEM(ca); !ok {
-			log.Println("Unable to apppend cert to pool")
-		}
-		config := tls.Config{
-			RootCAs:            pool,
-			MinVersion:         tls.VersionTLS12,
-			InsecureSkipVerify: tlsCfg.SkipVerify,
-		}
+		dialer.TLS = config
+	}
 
-		// Client Authentication - optional
-		if tlsCfg.ClientCert != "" && tlsCfg.ClientKey != "" {
-			ccrt, err := os.ReadFile(tlsCfg.ClientCert)
-			if err != nil {
-				log.Println("failed to load client cert", err)
-				return nil, err
-			}
-			ckey, err := os.ReadFile(tlsCfg.ClientKey)
-			if err != nil {
-				log.Println("failed to load client key", err)
-				return nil, err
-			}
-			cert, err := tls.X509KeyPair(ccrt, ckey)
-			if err != nil {
-				log.Println("X509KeyPair error ", err)
-				return nil, err
-			}
-			config.Certificates = []tls.Certificate{cert}
+	// SASL
+	if saslCfg != nil && saslCfg.Mechanism != "" {
+		mechanism, err := saslMechanism(saslCfg)
+		if err != nil {
+			return nil, err
 		}
-		dialer.TLS = &config
+		dialer.SASLMechanism = mechanism
 	}
 
 	ret.dialer = dialer
@@ -104,20 +131,41 @@ func NewKafkaMessageBus(host string, port int, topic string, partition int, tlsC
 		ret.conns[topic] = conn
 		ret.topicConnMu.Unlock()
 	}
-	return messagebus.Messagebus(ret), nil
+	return ret, nil
+}
+
+func saslMechanism(saslCfg *KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToUpper(saslCfg.Mechanism) {
+	case messagebus.SASLPlain:
+		return plain.Mechanism{Username: saslCfg.Username, Password: saslCfg.Password}, nil
+	case messagebus.SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, saslCfg.Username, saslCfg.Password)
+	case messagebus.SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, saslCfg.Username, saslCfg.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q, use %s, %s or %s", saslCfg.Mechanism,
+			messagebus.SASLPlain, messagebus.SASLScramSHA256, messagebus.SASLScramSHA512)
+	}
 }
 
 func NewKafkaMessageBusFromConn(conn *kafka.Conn, topic string) (messagebus.Messagebus, error) {
 	ret := new(KafkaMessagebus)
-	ret.conns[topic] = conn
+	ret.conns = map[string]*kafka.Conn{topic: conn}
+	ret.subs = map[*KafkaSubscription]struct{}{}
+	ret.replayTopics = map[string]bool{}
 	ret.topicConnMu = sync.RWMutex{}
 	ret.ctx = context.Background()
 	intRet := messagebus.Messagebus(ret)
 	return intRet, nil
 }
 
+// TopicName maps a queue name onto the Kafka topic carrying it
+func TopicName(queue string) string {
+	return strings.ReplaceAll(queue, "/", "_")
+}
+
 func (m *KafkaMessagebus) TopicConnect(queue string) (*kafka.Conn, error) {
-	topic := strings.ReplaceAll(queue, "/", "_")
+	topic := TopicName(queue)
 
 	m.topicConnMu.RLock()
 	kconn, ok := m.conns[topic]
@@ -208,40 +256,181 @@ func (m *KafkaMessagebus) SendMessageWithHeaders(message []byte, queue string, h
 }
 
 func (m *KafkaMessagebus) ReceiveMessage(message chan<- string, queue string) (messagebus.Subscription, error) {
-	kconn, err := m.TopicConnect(queue)
-	if err != nil || kconn == nil {
+	ctx, mySub, err := m.newSubscription(queue)
+	if err != nil {
 		return nil, err
 	}
-	go m.RecieveLoop(kconn, message, queue)
-	mySub := new(KafkaSubscription)
+	go mySub.RecieveLoop(ctx, message)
 	return messagebus.Subscription(mySub), nil
 }
 
-func (m *KafkaMessagebus) RecieveLoop(conn *kafka.Conn, message chan<- string, queue string) {
-	defer func() {
-		_, cancel := context.WithTimeout(m.ctx, 1*time.Second)
-		conn.Close()
+// newSubscription joins the consumer group for queue. The returned context is cancelled when the subscription closes.
+func (m *KafkaMessagebus) newSubscription(queue string) (context.Context, *KafkaSubscription, error) {
+	topic := TopicName(queue)
+	groupID := m.groupID
+	if groupID == "" {
+		groupID = topic
+	}
+	if m.dialer == nil {
+		return nil, nil, fmt.Errorf("kafka message bus for %s has no broker address to consume from", topic)
+	}
+	// Only used when the group has no committed offset yet. Starting at the end keeps a new replica from acting on
+	// commands and replies that were meant for whoever was there before it.
+	startOffset := kafka.LastOffset
+	if m.replayTopics[topic] {
+		startOffset = kafka.FirstOffset
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	mySub := new(KafkaSubscription)
+	mySub.bus = m
+	mySub.cancel = cancel
+	mySub.done = make(chan struct{})
+	mySub.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{m.addr},
+		GroupID:     groupID,
+		Topic:       topic,
+		Dialer:      m.dialer,
+		MaxBytes:    KafkaMaxMessageBytes,
+		StartOffset: startOffset,
+		// CommitInterval 0 makes CommitMessages synchronous, so an offset is only stored once the message is delivered
+		CommitInterval: 0,
+	})
+	log.Printf("Kafka consumer starting in group %s [topic=%s broker=%s]", groupID, topic, m.addr)
+
+	m.subsMu.Lock()
+	m.subs[mySub] = struct{}{}
+	m.subsMu.Unlock()
+	return ctx, mySub, nil
+}
+
+func (s *KafkaSubscription) RecieveLoop(ctx context.Context, message chan<- string) {
+	defer close(s.done)
+
+	topic := s.reader.Config().Topic
+	for {
+		msg, err := s.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The reader reconnects and rejoins the group on its own, so just back off and fetch again
+			log.Printf("failed to read message from %s: %v", topic, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		select {
+		case message <- string(msg.Value):
+		case <-ctx.Done():
+			// Not delivered, so leave the offset alone and let the next group member pick it up
+			return
+		}
+
+		// Delivered, so commit even if Close is racing with us to avoid handing the message out twice. The timeout
+		// keeps Close from hanging on an unreachable broker.
+		commitCtx, cancel := context.WithTimeout(s.bus.ctx, 10*time.Second)
+		if err := s.reader.CommitMessages(commitCtx, msg); err != nil {
+			log.Printf("failed to commit offset %d on %s partition %d: %v", msg.Offset, topic, msg.Partition, err)
+		}
 		cancel()
-	}()
+	}
+}
+
+func (m *KafkaMessagebus) Send(ctx context.Context, queue string, body []byte, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.SendMessageWithHeaders(body, queue, headers)
+}
+
+// Subscribe implements messagebus.MessagebusV2. The group offset is only committed when a message is acked, so after
+// a restart or a rebalance consumption resumes at the oldest message that was not. Nack leaves the offset alone, but
+// the message is not fetched again until then, and acking a later message of the same partition commits past it.
+func (m *KafkaMessagebus) Subscribe(ctx context.Context, queue string) (messagebus.SubscriptionV2, error) {
+	loopCtx, mySub, err := m.newSubscription(queue)
+	if err != nil {
+		return nil, err
+	}
+	ret := messagebus.NewChanSubscription(ctx, mySub.Close)
+	mySub.busClosed = ret.Fail
+	go mySub.subscribeLoop(loopCtx, queue, ret)
+	return ret, nil
+}
 
+func (s *KafkaSubscription) subscribeLoop(ctx context.Context, queue string, sub *messagebus.ChanSubscription) {
+	defer close(s.done)
+
+	topic := s.reader.Config().Topic
 	for {
-		// Receive next message
-		msg, err := conn.ReadMessage(KafkaMaxMessageBytes)
+		msg, err := s.reader.FetchMessage(ctx)
 		if err != nil {
-			log.Println("failed to read message:", err)
-			// Treat read errors as fatal so the pod is restarted
-			if shouldRestartOnErr(err) {
-				log.Println("Fatal broker read error; exiting so Kubernetes restarts the pod")
-				os.Exit(1)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("failed to read message from %s: %v", topic, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		var headers map[string]string
+		if len(msg.Headers) > 0 {
+			headers = make(map[string]string, len(msg.Headers))
+			for _, hdr := range msg.Headers {
+				headers[hdr.Key] = string(hdr.Value)
 			}
-			break
 		}
-		message <- string(msg.Value)
+		ack := func() error {
+			commitCtx, cancel := context.WithTimeout(s.bus.ctx, 10*time.Second)
+			defer cancel()
+			return s.reader.CommitMessages(commitCtx, msg)
+		}
+		if !sub.Deliver(messagebus.NewMessage(queue, msg.Value, headers, ack, nil)) {
+			return
+		}
 	}
 }
 
+func (s *KafkaSubscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.cancel()
+		<-s.done
+		err = s.reader.Close()
+
+		s.bus.subsMu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.subsMu.Unlock()
+	})
+	return err
+}
+
 func (m *KafkaMessagebus) Close() error {
 	var err error
+
+	m.subsMu.Lock()
+	subs := make([]*KafkaSubscription, 0, len(m.subs))
+	for sub := range m.subs {
+		subs = append(subs, sub)
+	}
+	m.subsMu.Unlock()
+	for _, sub := range subs {
+		if sub.busClosed != nil {
+			sub.busClosed(ErrBusClosed)
+		}
+		if err1 := sub.Close(); err1 != nil {
+			err = err1
+		}
+	}
+
 	m.topicConnMu.Lock()
 	defer m.topicConnMu.Unlock()
 
@@ -255,6 +444,3 @@ func (m *KafkaMessagebus) Close() error {
 	return err
 }
 
-func (m *KafkaSubscription) Close() error {
-	return nil
-}
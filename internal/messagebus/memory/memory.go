@@ -0,0 +1,295 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+)
+
+// TopicPrefix marks a destination as a topic, following the ActiveMQ STOMP naming convention. Every current
+// subscriber of a topic gets a copy of each message. Any other destination is a queue: messages are buffered until
+// a subscriber is present and each message is handed to exactly one subscriber.
+const TopicPrefix = "/topic/"
+
+var ErrBusClosed = errors.New("memory message bus closed")
+
+type memoryMessage struct {
+	body    []byte
+	headers map[string]string
+}
+
+type destination struct {
+	name    string
+	topic   bool
+	pending []memoryMessage
+	subs    []*MemorySubscription
+}
+
+// MemoryMessagebus is an in-process Messagebus. Services that share one instance can talk to each other without an
+// external broker, which allows running several of them in a single binary and unit testing the bus clients.
+type MemoryMessagebus struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	dests  map[string]*destination
+	closed bool
+	// refs counts the services the factory handed the shared bus to. It only closes when the last of them does.
+	refs int
+}
+
+type MemorySubscription struct {
+	bus     *MemoryMessagebus
+	dest    *destination
+	pending []memoryMessage // topic messages not yet delivered to this subscriber
+	closed  bool
+	done    chan struct{}
+}
+
+var shared *MemoryMessagebus
+var sharedMu sync.Mutex
+
+// The factory hands every service in the process the same bus, which is what lets them talk to each other. A new one
+// is made once all of them have closed it.
+func init() {
+	messagebus.Register(messagebus.MEMORY, func(cfg *messagebus.Config) (messagebus.Messagebus, error) {
+		sharedMu.Lock()
+		defer sharedMu.Unlock()
+		if shared != nil {
+			shared.mu.Lock()
+			closed := shared.closed
+			if !closed {
+				shared.refs++
+			}
+			shared.mu.Unlock()
+			if !closed {
+				return shared, nil
+			}
+		}
+		bus, _ := NewMemoryMessageBus()
+		shared = bus.(*MemoryMessagebus)
+		shared.refs = 1
+		return shared, nil
+	})
+}
+
+func NewMemoryMessageBus() (messagebus.Messagebus, error) {
+	ret := new(MemoryMessagebus)
+	ret.cond = sync.NewCond(&ret.mu)
+	ret.dests = make(map[string]*destination)
+
+	intRet := messagebus.Messagebus(ret)
+	return intRet, nil
+}
+
+// getDestination must be called with m.mu held
+func (m *MemoryMessagebus) getDestination(name string) *destination {
+	dest, ok := m.dests[name]
+	if !ok {
+		dest = new(destination)
+		dest.name = name
+		dest.topic = strings.HasPrefix(name, TopicPrefix)
+		m.dests[name] = dest
+	}
+	return dest
+}
+
+func (m *MemoryMessagebus) SendMessage(message []byte, queue string) error {
+	return m.SendMessageWithHeaders(message, queue, nil)
+}
+
+// SendMessageWithHeaders queues the message like SendMessage. The headers only reach subscribers made with
+// Subscribe, as the v1 receive side has no way to hand them over.
+func (m *MemoryMessagebus) SendMessageWithHeaders(message []byte, queue string, headers map[string]string) error {
+	// Copy the body and headers so the caller can reuse them
+	var msg memoryMessage
+	msg.body = make([]byte, len(message))
+	copy(msg.body, message)
+	if len(headers) > 0 {
+		msg.headers = make(map[string]string, len(headers))
+		for key, value := range headers {
+			msg.headers[key] = value
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrBusClosed
+	}
+	dest := m.getDestination(queue)
+	if dest.topic {
+		for _, sub := range dest.subs {
+			sub.pending = append(sub.pending, msg)
+		}
+	} else {
+		dest.pending = append(dest.pending, msg)
+	}
+	m.cond.Broadcast()
+	return nil
+}
+
+func (m *MemoryMessagebus) ReceiveMessage(message chan<- string, queue string) (messagebus.Subscription, error) {
+	sub, err := m.subscribe(queue)
+	if err != nil {
+		return nil, err
+	}
+	go sub.RecieveLoop(message)
+	return messagebus.Subscription(sub), nil
+}
+
+func (m *MemoryMessagebus) subscribe(queue string) (*MemorySubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrBusClosed
+	}
+	sub := new(MemorySubscription)
+	sub.bus = m
+	sub.dest = m.getDestination(queue)
+	sub.done = make(chan struct{})
+	sub.dest.subs = append(sub.dest.subs, sub)
+	return sub, nil
+}
+
+func (m *MemoryMessagebus) Send(ctx context.Context, queue string, body []byte, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.SendMessageWithHeaders(body, queue, headers)
+}
+
+// Subscribe implements messagebus.MessagebusV2. A message is gone from the bus once it is received, so Ack does
+// nothing while Nack puts it back at the head of the queue, or of this subscriber's backlog for a topic.
+func (m *MemoryMessagebus) Subscribe(ctx context.Context, queue string) (messagebus.SubscriptionV2, error) {
+	sub, err := m.subscribe(queue)
+	if err != nil {
+		return nil, err
+	}
+	ret := messagebus.NewChanSubscription(ctx, sub.Close)
+	go func() {
+		for {
+			msg, ok := sub.take()
+			if !ok {
+				ret.Fail(ErrBusClosed)
+				return
+			}
+			nack := func() error {
+				sub.requeue(msg)
+				return nil
+			}
+			if !ret.Deliver(messagebus.NewMessage(queue, msg.body, msg.headers, nil, nack)) {
+				sub.requeue(msg)
+				return
+			}
+		}
+	}()
+	return ret, nil
+}
+
+// Close closes the bus, or for the bus the factory shares drops one reference to it
+func (m *MemoryMessagebus) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	if m.refs > 1 {
+		m.refs--
+		return nil
+	}
+	m.closed = true
+	for _, dest := range m.dests {
+		for _, sub := range dest.subs {
+			sub.closeLocked()
+		}
+		dest.subs = nil
+	}
+	m.cond.Broadcast()
+	return nil
+}
+
+// take blocks until there is a message for this subscription or it is closed
+func (s *MemorySubscription) take() (memoryMessage, bool) {
+	m := s.bus
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if s.closed {
+			return memoryMessage{}, false
+		}
+		if s.dest.topic && len(s.pending) > 0 {
+			msg := s.pending[0]
+			s.pending = s.pending[1:]
+			return msg, true
+		}
+		if !s.dest.topic && len(s.dest.pending) > 0 {
+			msg := s.dest.pending[0]
+			s.dest.pending = s.dest.pending[1:]
+			return msg, true
+		}
+		m.cond.Wait()
+	}
+}
+
+// requeue puts back a message that was taken but never handed over or was rejected. A queue message goes back for
+// any subscriber to take, a topic message back to this subscriber unless it has gone away.
+func (s *MemorySubscription) requeue(msg memoryMessage) {
+	m := s.bus
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	if s.dest.topic {
+		if !s.closed {
+			s.pending = append([]memoryMessage{msg}, s.pending...)
+		}
+	} else {
+		s.dest.pending = append([]memoryMessage{msg}, s.dest.pending...)
+	}
+	m.cond.Broadcast()
+}
+
+func (s *MemorySubscription) RecieveLoop(message chan<- string) {
+	for {
+		msg, ok := s.take()
+		if !ok {
+			return
+		}
+		select {
+		case message <- string(msg.body):
+		case <-s.done:
+			s.requeue(msg)
+			return
+		}
+	}
+}
+
+// closeLocked must be called with the bus lock held
+func (s *MemorySubscription) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.pending = nil
+	close(s.done)
+}
+
+func (s *MemorySubscription) Close() error {
+	m := s.bus
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.closeLocked()
+	for i, sub := range s.dest.subs {
+		if sub == s {
+			s.dest.subs = append(s.dest.subs[:i], s.dest.subs[i+1:]...)
+			break
+		}
+	}
+	m.cond.Broadcast()
+	return nil
+}
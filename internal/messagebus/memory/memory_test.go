@@ -0,0 +1,268 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+)
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return ""
+}
+
+func expectNothing(t *testing.T, messages <-chan string) {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected message %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestQueueBuffersUntilSubscribed(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+
+	if err := mb.SendMessage([]byte("first"), "/databus"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.SendMessage([]byte("second"), "/databus"); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan string, 10)
+	if _, err := mb.ReceiveMessage(messages, "/databus"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, messages); got != "first" {
+		t.Errorf("got %q, want first", got)
+	}
+	if got := receive(t, messages); got != "second" {
+		t.Errorf("got %q, want second", got)
+	}
+}
+
+func TestQueueDeliversOnce(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+
+	a := make(chan string, 10)
+	b := make(chan string, 10)
+	_, _ = mb.ReceiveMessage(a, "/databus")
+	_, _ = mb.ReceiveMessage(b, "/databus")
+
+	for i := 0; i < 20; i++ {
+		_ = mb.SendMessage([]byte("msg"), "/databus")
+	}
+	count := 0
+	timeout := time.After(time.Second)
+	for count < 20 {
+		select {
+		case <-a:
+			count++
+		case <-b:
+			count++
+		case <-timeout:
+			t.Fatalf("got %d messages, want 20", count)
+		}
+	}
+	expectNothing(t, a)
+	expectNothing(t, b)
+}
+
+func TestTopicFanOut(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+
+	// Topics do not retain messages sent before anyone subscribed
+	_ = mb.SendMessage([]byte("lost"), "/topic/events")
+
+	a := make(chan string, 10)
+	b := make(chan string, 10)
+	_, _ = mb.ReceiveMessage(a, "/topic/events")
+	_, _ = mb.ReceiveMessage(b, "/topic/events")
+
+	_ = mb.SendMessageWithHeaders([]byte("hello"), "/topic/events", map[string]string{"expires": "1000"})
+	if got := receive(t, a); got != "hello" {
+		t.Errorf("subscriber a got %q, want hello", got)
+	}
+	if got := receive(t, b); got != "hello" {
+		t.Errorf("subscriber b got %q, want hello", got)
+	}
+	expectNothing(t, a)
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+
+	// Unbuffered and never read, so the first message is stuck in delivery when the subscription closes
+	stuck := make(chan string)
+	sub, _ := mb.ReceiveMessage(stuck, "/configui")
+	_ = mb.SendMessage([]byte("response"), "/configui")
+	time.Sleep(20 * time.Millisecond)
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan string, 10)
+	_, _ = mb.ReceiveMessage(messages, "/configui")
+	if got := receive(t, messages); got != "response" {
+		t.Errorf("got %q, want the message requeued from the closed subscription", got)
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	messages := make(chan string, 10)
+	_, _ = mb.ReceiveMessage(messages, "/databus")
+	if err := mb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.SendMessage([]byte("late"), "/databus"); err != ErrBusClosed {
+		t.Errorf("got %v, want ErrBusClosed", err)
+	}
+	if _, err := mb.ReceiveMessage(messages, "/databus"); err != ErrBusClosed {
+		t.Errorf("got %v, want ErrBusClosed", err)
+	}
+	expectNothing(t, messages)
+}
+
+func TestSharedBusClose(t *testing.T) {
+	cfg := messagebus.NewConfig(messagebus.MEMORY, "", 0)
+	first, _ := messagebus.New(cfg)
+	second, _ := messagebus.New(cfg)
+	if first != second {
+		t.Fatal("factory did not share the bus")
+	}
+	messages := make(chan string, 10)
+	_, _ = second.ReceiveMessage(messages, "/databus")
+	// one service closing its bus leaves it open for the other
+	first.Close()
+	if err := second.SendMessage([]byte("still open"), "/databus"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, messages); got != "still open" {
+		t.Errorf("got %q", got)
+	}
+	second.Close()
+	if err := second.SendMessage([]byte("late"), "/databus"); err != ErrBusClosed {
+		t.Errorf("got %v, want ErrBusClosed", err)
+	}
+	third, _ := messagebus.New(cfg)
+	defer third.Close()
+	if third == second {
+		t.Error("factory handed out the closed bus")
+	}
+}
+
+func receiveV2(t *testing.T, sub messagebus.SubscriptionV2) *messagebus.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.Messages():
+		if !ok {
+			t.Fatalf("subscription ended: %v", sub.Err())
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return nil
+}
+
+func TestSubscribeHeadersAndNack(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+	bus := messagebus.AsV2(mb)
+
+	sub, err := bus.Subscribe(context.Background(), "/databus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := bus.Send(context.Background(), "/databus", []byte("GET"), map[string]string{"ReplyTo": "/influx"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := receiveV2(t, sub)
+	if string(msg.Body) != "GET" || msg.Headers["ReplyTo"] != "/influx" {
+		t.Fatalf("got %q with headers %v", msg.Body, msg.Headers)
+	}
+	if err := msg.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	msg = receiveV2(t, sub)
+	if string(msg.Body) != "GET" {
+		t.Fatalf("got %q, want the nacked message again", msg.Body)
+	}
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeContextCancel(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, _ := messagebus.AsV2(mb).Subscribe(ctx, "/databus")
+	cancel()
+	select {
+	case _, ok := <-sub.Messages():
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Messages not closed after cancel")
+	}
+	if sub.Err() != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", sub.Err())
+	}
+
+	// Nothing is left subscribed, so the next receiver gets the message
+	_ = mb.SendMessage([]byte("next"), "/databus")
+	messages := make(chan string, 1)
+	_, _ = mb.ReceiveMessage(messages, "/databus")
+	if got := receive(t, messages); got != "next" {
+		t.Errorf("got %q, want next", got)
+	}
+}
+
+func TestAdapters(t *testing.T) {
+	mb, _ := NewMemoryMessageBus()
+	defer mb.Close()
+
+	// Hiding the native interfaces forces the adapters to be used
+	v2 := messagebus.AsV2(struct{ messagebus.Messagebus }{mb})
+	sub, _ := v2.Subscribe(context.Background(), "/prometheus")
+	v1 := messagebus.AsV1(struct{ messagebus.MessagebusV2 }{messagebus.AsV2(mb)})
+	messages := make(chan string, 1)
+	_, _ = v1.ReceiveMessage(messages, "/influx")
+
+	_ = v1.SendMessage([]byte("to prometheus"), "/prometheus")
+	_ = v2.Send(context.Background(), "/influx", []byte("to influx"), nil)
+	if msg := receiveV2(t, sub); string(msg.Body) != "to prometheus" {
+		t.Errorf("got %q", msg.Body)
+	}
+	if got := receive(t, messages); got != "to influx" {
+		t.Errorf("got %q", got)
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-sub.Messages(); ok || sub.Err() != nil {
+		t.Errorf("got open %v err %v after Close", ok, sub.Err())
+	}
+}
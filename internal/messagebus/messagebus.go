@@ -2,6 +2,8 @@
 
 package messagebus
 
+import "expvar"
+
 type Subscription interface {
 	Close() error
 }
@@ -12,3 +14,30 @@ type Messagebus interface {
 	ReceiveMessage(message chan<- string, queue string) (Subscription, error)
 	Close() error
 }
+
+const (
+	CONNECTED    = "Connected"
+	DISCONNECTED = "Disconnected"
+	CLOSED       = "Closed"
+)
+
+// StatefulMessagebus is implemented by message buses that keep their broker connection alive on their own and can
+// report whether it is currently up.
+type StatefulMessagebus interface {
+	Messagebus
+	State() string
+}
+
+// Connected is 1 per broker address while the StatefulMessagebus New created for it is connected, else 0. It is
+// published through expvar, so any service serving expvar.Handler exposes it as "messagebus_connected".
+var Connected = expvar.NewMap("messagebus_connected")
+
+// publishState makes bus's state show in Connected under addr
+func publishState(addr string, bus StatefulMessagebus) {
+	Connected.Set(addr, expvar.Func(func() any {
+		if bus.State() == CONNECTED {
+			return 1
+		}
+		return 0
+	}))
+}
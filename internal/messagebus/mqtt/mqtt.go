@@ -0,0 +1,530 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+)
+
+// QoS 1 gives at least once delivery between the broker and each client
+const QoS = 1
+
+// TopicPrefix marks a destination as a topic, following the ActiveMQ STOMP naming convention used by the other
+// backends. Any other destination is a queue.
+const TopicPrefix = "/topic/"
+
+// ShareGroup is the shared subscription group every queue subscription joins, so the broker hands each queue message
+// to only one subscriber however many services or replicas subscribe to it
+const ShareGroup = "idrac-telemetry"
+
+const (
+	connectTimeout = 10 * time.Second
+	publishTimeout = 10 * time.Second
+	// sessionExpiry lets the broker keep our subscriptions and hold QoS 1 messages for them while a service restarts
+	sessionExpiry = 300
+	// maxUnclaimed caps the queue messages kept per topic for a local subscription that has not been made yet
+	maxUnclaimed = 1000
+)
+
+var ErrBusClosed = errors.New("mqtt message bus closed")
+
+type MqttMessagebus struct {
+	cm     *autopaho.ConnectionManager
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	subs map[string][]*MqttSubscription // local subscriptions per topic
+	next map[string]int                 // local subscription of a queue that gets the next message
+	// unclaimed holds queue messages the resumed session delivered before the service subscribed to the queue again
+	unclaimed map[string][]mqttMessage
+	closed    bool
+}
+
+// mqttMessage is a received message on its way to a subscriber. ack sends the PUBACK and is only set for a queue
+// message, which is acknowledged once its subscriber is done with it. The client sends PUBACKs in the order messages
+// arrived, so every ack has to be called before long or it holds up the acknowledgement of all later messages.
+type mqttMessage struct {
+	body    []byte
+	headers map[string]string
+	ack     func() error
+}
+
+type MqttSubscription struct {
+	bus     *MqttMessagebus
+	queue   string
+	topic   string
+	message chan<- string                // set for ReceiveMessage
+	v2      *messagebus.ChanSubscription // set for Subscribe
+	done    chan struct{}
+	once    sync.Once
+
+	// backlog holds the messages received for the subscription until deliver hands them over, so a slow consumer
+	// never holds up the connection. ready is signalled when a message is added.
+	mu      sync.Mutex
+	backlog []mqttMessage
+	ready   chan struct{}
+}
+
+func init() {
+	messagebus.Register(messagebus.MQTT, func(cfg *messagebus.Config) (messagebus.Messagebus, error) {
+		return NewMqttMessageBus(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.TLS)
+	})
+}
+
+// TopicName maps a databus queue name onto an MQTT topic. The leading slash is dropped because it would otherwise
+// create an empty first topic level, so "/databus" becomes "databus" and "/authorization/command" keeps its levels.
+func TopicName(queue string) string {
+	return strings.TrimPrefix(queue, "/")
+}
+
+// isQueue reports whether topic came from a queue name rather than a /topic/ destination
+func isQueue(topic string) bool {
+	return !strings.HasPrefix(topic, TopicName(TopicPrefix))
+}
+
+// topicFilter is what we subscribe to for topic. Queues use a shared subscription so that, as with the other
+// backends, each message goes to one subscriber instead of to every service or replica subscribed to the queue.
+func topicFilter(topic string) string {
+	if isQueue(topic) {
+		return "$share/" + ShareGroup + "/" + topic
+	}
+	return topic
+}
+
+// NewMqttMessageBus connects to an MQTT 5 broker, using TLS when tlsCfg is not nil. Username and password are
+// optional. The connection is re-established in the background if it drops and subscriptions are renewed with it.
+//
+// The session outlives the connection, so the broker keeps queue subscriptions and their QoS 1 messages while a
+// service restarts. Messages sent to a queue that nobody has ever subscribed to are still dropped by the broker.
+func NewMqttMessageBus(host string, port int, username string, password string, tlsCfg *messagebus.TLSConfig) (messagebus.Messagebus, error) {
+	ret := new(MqttMessagebus)
+	ret.subs = make(map[string][]*MqttSubscription)
+	ret.next = make(map[string]int)
+	ret.unclaimed = make(map[string][]mqttMessage)
+
+	scheme := "mqtt"
+	cliCfg := autopaho.ClientConfig{
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: false,
+		SessionExpiryInterval:         sessionExpiry,
+		ConnectTimeout:                connectTimeout,
+		ConnectUsername:               username,
+		ConnectPassword:               []byte(password),
+		OnConnectionUp:                ret.connectionUp,
+		OnConnectError: func(err error) {
+			log.Printf("Could not connect to MQTT broker (%s:%d): %v", host, port, err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:          clientID(),
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){ret.dispatch},
+			// Queue messages are acknowledged once their subscriber is done with them, see mqttMessage
+			EnableManualAcknowledgment: true,
+			OnClientError: func(err error) {
+				log.Printf("MQTT client error: %v", err)
+			},
+		},
+	}
+	if tlsCfg != nil {
+		config, err := tlsCfg.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		cliCfg.TlsCfg = config
+		scheme = "tls"
+	}
+	u, err := url.Parse(fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port))))
+	if err != nil {
+		return nil, err
+	}
+	cliCfg.ServerUrls = []*url.URL{u}
+
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ret.ctx, cliCfg)
+	if err != nil {
+		ret.cancel()
+		return nil, err
+	}
+	ret.cm = cm
+
+	// Fail like the other backends do when the broker is not there, so the caller's retry loop kicks in
+	ctx, cancel := context.WithTimeout(ret.ctx, connectTimeout)
+	defer cancel()
+	if err := cm.AwaitConnection(ctx); err != nil {
+		ret.cancel()
+		<-cm.Done()
+		return nil, fmt.Errorf("could not connect to MQTT broker %s: %w", u, err)
+	}
+
+	intRet := messagebus.Messagebus(ret)
+	return intRet, nil
+}
+
+// clientID has to be unique per connection on the broker and stay the same across restarts, so that a restarted
+// service picks up the session, and with it the messages, it left behind. Every service opens a single bus, so the
+// host and program name are enough unless MESSAGEBUS_CLIENT_ID says otherwise.
+func clientID() string {
+	if id := os.Getenv("MESSAGEBUS_CLIENT_ID"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("idrac-telemetry-%s-%s", host, filepath.Base(os.Args[0]))
+}
+
+// connectionUp subscribes again to every topic in use, as the broker may have dropped them with the session
+func (m *MqttMessagebus) connectionUp(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+	m.mu.Lock()
+	topics := make([]string, 0, len(m.subs))
+	for topic := range m.subs {
+		topics = append(topics, topic)
+	}
+	m.mu.Unlock()
+	if len(topics) == 0 {
+		return
+	}
+	// Must not block the connection manager
+	go func() {
+		for _, topic := range topics {
+			if err := m.subscribe(cm, topic); err != nil {
+				log.Printf("Failed to resubscribe to MQTT topic %s: %v", topic, err)
+			}
+		}
+	}()
+}
+
+func (m *MqttMessagebus) subscribe(cm *autopaho.ConnectionManager, topic string) error {
+	ctx, cancel := context.WithTimeout(m.ctx, publishTimeout)
+	defer cancel()
+	_, err := cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topicFilter(topic), QoS: QoS}},
+	})
+	return err
+}
+
+// dispatch queues a received message for every local subscription of a topic, or for the next local subscription in
+// turn of a queue. It runs on the connection's inbound loop, which also handles the acknowledgements of our own
+// publishes, so it must never wait for a consumer.
+//
+// Only a queue message handed to a subscription keeps its PUBACK until it is processed. Topic messages, and queue
+// messages nobody here has subscribed to yet, are acknowledged right away so they cannot hold up other PUBACKs.
+func (m *MqttMessagebus) dispatch(pr paho.PublishReceived) (bool, error) {
+	topic := pr.Packet.Topic
+	msg := mqttMessage{body: pr.Packet.Payload}
+	if pr.Packet.Properties != nil && len(pr.Packet.Properties.User) > 0 {
+		msg.headers = make(map[string]string, len(pr.Packet.Properties.User))
+		for _, prop := range pr.Packet.Properties.User {
+			msg.headers[prop.Key] = prop.Value
+		}
+	}
+	ack := func() error {
+		// Only missing for packets made up in tests
+		if pr.Client == nil {
+			return nil
+		}
+		return pr.Client.Ack(pr.Packet)
+	}
+
+	m.mu.Lock()
+	subs := append([]*MqttSubscription(nil), m.subs[topic]...)
+	if isQueue(topic) && len(subs) > 0 {
+		i := m.next[topic] % len(subs)
+		m.next[topic] = i + 1
+		m.mu.Unlock()
+		msg.ack = ack
+		subs[i].enqueue(msg)
+		return true, nil
+	}
+	if isQueue(topic) {
+		unclaimed := append(m.unclaimed[topic], msg)
+		if len(unclaimed) > maxUnclaimed {
+			unclaimed = unclaimed[1:]
+		}
+		m.unclaimed[topic] = unclaimed
+	}
+	m.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.enqueue(msg)
+	}
+	if err := ack(); err != nil {
+		log.Printf("Failed to acknowledge MQTT message on %s: %v", topic, err)
+	}
+	return true, nil
+}
+
+func (s *MqttSubscription) enqueue(msg mqttMessage) {
+	s.mu.Lock()
+	s.backlog = append(s.backlog, msg)
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// requeue puts back a message that was not handed over or was rejected. Once the subscription is closed the message
+// goes to another local subscription of the queue instead.
+func (s *MqttSubscription) requeue(msg mqttMessage) {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		s.bus.redistribute(s.topic, []mqttMessage{msg})
+		return
+	default:
+	}
+	s.backlog = append([]mqttMessage{msg}, s.backlog...)
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// redistribute hands the queue messages a closed subscription still held to another local subscription of the
+// queue. Without one they are acknowledged and dropped, as an unacknowledged message would hold up every PUBACK
+// after it. When the whole bus is closing they are left unacknowledged so the broker sends them again.
+func (m *MqttMessagebus) redistribute(topic string, msgs []mqttMessage) {
+	m.mu.Lock()
+	closed := m.closed
+	var target *MqttSubscription
+	if subs := m.subs[topic]; len(subs) > 0 {
+		target = subs[0]
+	}
+	m.mu.Unlock()
+
+	for _, msg := range msgs {
+		switch {
+		case closed:
+		case target != nil:
+			target.enqueue(msg)
+		case msg.ack != nil:
+			if err := msg.ack(); err != nil {
+				log.Printf("Failed to acknowledge MQTT message on %s: %v", topic, err)
+			}
+		}
+	}
+}
+
+// deliver hands the backlog to the consumer in order until the subscription is closed. ReceiveMessage subscribers
+// have a message acknowledged once it is in their channel, Subscribe subscribers ack it themselves.
+func (s *MqttSubscription) deliver() {
+	for {
+		s.mu.Lock()
+		if len(s.backlog) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.ready:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		msg := s.backlog[0]
+		s.backlog = s.backlog[1:]
+		s.mu.Unlock()
+
+		if s.v2 != nil {
+			nack := func() error {
+				s.requeue(msg)
+				return nil
+			}
+			if !s.v2.Deliver(messagebus.NewMessage(s.queue, msg.body, msg.headers, msg.ack, nack)) {
+				s.requeue(msg)
+				return
+			}
+			continue
+		}
+
+		select {
+		case s.message <- string(msg.body):
+			if msg.ack != nil {
+				if err := msg.ack(); err != nil {
+					log.Printf("Failed to acknowledge MQTT message on %s: %v", s.topic, err)
+				}
+			}
+		case <-s.done:
+			s.requeue(msg)
+			return
+		}
+	}
+}
+
+func (m *MqttMessagebus) SendMessage(message []byte, queue string) error {
+	return m.SendMessageWithHeaders(message, queue, nil)
+}
+
+// SendMessageWithHeaders publishes the message with QoS 1, carrying the headers as MQTT 5 user properties
+func (m *MqttMessagebus) SendMessageWithHeaders(message []byte, queue string, headers map[string]string) error {
+	pub := &paho.Publish{
+		QoS:     QoS,
+		Topic:   TopicName(queue),
+		Payload: message,
+	}
+	if len(headers) > 0 {
+		pub.Properties = new(paho.PublishProperties)
+		for key, value := range headers {
+			pub.Properties.User.Add(key, value)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, publishTimeout)
+	defer cancel()
+	if _, err := m.cm.Publish(ctx, pub); err != nil {
+		if m.ctx.Err() != nil {
+			return ErrBusClosed
+		}
+		log.Println("failed to publish message:", queue, err)
+		return err
+	}
+	return nil
+}
+
+func (m *MqttMessagebus) Send(ctx context.Context, queue string, body []byte, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.SendMessageWithHeaders(body, queue, headers)
+}
+
+func (m *MqttMessagebus) ReceiveMessage(message chan<- string, queue string) (messagebus.Subscription, error) {
+	sub := m.newSubscription(queue)
+	sub.message = message
+	if err := m.addSubscription(sub); err != nil {
+		return nil, err
+	}
+	return messagebus.Subscription(sub), nil
+}
+
+// Subscribe implements messagebus.MessagebusV2. Headers are the MQTT 5 user properties of the message. A queue
+// message is only acknowledged to the broker on Ack, so one that is never acked is sent again after a restart, and
+// Nack hands it back to the queue's local subscriptions. Topic messages are acknowledged on receipt.
+func (m *MqttMessagebus) Subscribe(ctx context.Context, queue string) (messagebus.SubscriptionV2, error) {
+	sub := m.newSubscription(queue)
+	ret := messagebus.NewChanSubscription(ctx, sub.Close)
+	sub.v2 = ret
+	if err := m.addSubscription(sub); err != nil {
+		ret.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (m *MqttMessagebus) newSubscription(queue string) *MqttSubscription {
+	sub := new(MqttSubscription)
+	sub.bus = m
+	sub.queue = queue
+	sub.topic = TopicName(queue)
+	sub.done = make(chan struct{})
+	sub.ready = make(chan struct{}, 1)
+	return sub
+}
+
+func (m *MqttMessagebus) addSubscription(sub *MqttSubscription) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrBusClosed
+	}
+	first := len(m.subs[sub.topic]) == 0
+	m.subs[sub.topic] = append(m.subs[sub.topic], sub)
+	for _, msg := range m.unclaimed[sub.topic] {
+		sub.enqueue(msg)
+	}
+	delete(m.unclaimed, sub.topic)
+	m.mu.Unlock()
+	go sub.deliver()
+
+	if first {
+		if err := m.subscribe(m.cm, sub.topic); err != nil {
+			// connectionUp will retry once the broker is back
+			log.Printf("Failed to subscribe to MQTT topic %s: %v", sub.topic, err)
+		}
+	}
+	return nil
+}
+
+func (m *MqttMessagebus) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	var subs []*MqttSubscription
+	for _, topicSubs := range m.subs {
+		subs = append(subs, topicSubs...)
+	}
+	m.mu.Unlock()
+
+	// Stops the subscriptions' deliver goroutines
+	for _, sub := range subs {
+		if sub.v2 != nil {
+			sub.v2.Fail(ErrBusClosed)
+		}
+		sub.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	err := m.cm.Disconnect(ctx)
+	m.cancel()
+	<-m.cm.Done()
+	return err
+}
+
+func (s *MqttSubscription) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+
+		m := s.bus
+		m.mu.Lock()
+		subs := m.subs[s.topic]
+		for i, sub := range subs {
+			if sub == s {
+				subs = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		last := len(subs) == 0
+		if last {
+			delete(m.subs, s.topic)
+			delete(m.next, s.topic)
+		} else {
+			m.subs[s.topic] = subs
+		}
+		closed := m.closed
+		m.mu.Unlock()
+
+		s.mu.Lock()
+		backlog := s.backlog
+		s.backlog = nil
+		s.mu.Unlock()
+		m.redistribute(s.topic, backlog)
+
+		// The broker subscription is shared by all local subscriptions of the topic
+		if last && !closed {
+			ctx, cancel := context.WithTimeout(m.ctx, publishTimeout)
+			defer cancel()
+			_, err = m.cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{topicFilter(s.topic)}})
+		}
+	})
+	return err
+}
@@ -0,0 +1,252 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+)
+
+// startBroker runs a throwaway mosquitto on a free local port, skipping the test when it is not installed
+func startBroker(t *testing.T) int {
+	t.Helper()
+	bin, err := exec.LookPath("mosquitto")
+	if err != nil {
+		t.Skip("mosquitto not found in PATH")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	conf := filepath.Join(t.TempDir(), "mosquitto.conf")
+	err = os.WriteFile(conf, []byte(fmt.Sprintf("listener %d 127.0.0.1\nallow_anonymous true\n", port)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "-c", conf)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			return port
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("mosquitto did not start")
+	return 0
+}
+
+func receive(t *testing.T, msgs <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+		return ""
+	}
+}
+
+func TestTopicName(t *testing.T) {
+	for queue, topic := range map[string]string{
+		"/databus":               "databus",
+		"/prometheus":            "prometheus",
+		"/authorization/command": "authorization/command",
+		"databus":                "databus",
+	} {
+		if got := TopicName(queue); got != topic {
+			t.Errorf("TopicName(%q) = %q, want %q", queue, got, topic)
+		}
+	}
+}
+
+func TestTopicFilter(t *testing.T) {
+	for topic, filter := range map[string]string{
+		"databus":        "$share/" + ShareGroup + "/databus",
+		"configui/reply": "$share/" + ShareGroup + "/configui/reply",
+		"topic/events":   "topic/events",
+	} {
+		if got := topicFilter(topic); got != filter {
+			t.Errorf("topicFilter(%q) = %q, want %q", topic, got, filter)
+		}
+	}
+}
+
+// newTestBus is a bus without a connection, for feeding dispatch directly
+func newTestBus() *MqttMessagebus {
+	return &MqttMessagebus{subs: make(map[string][]*MqttSubscription), next: make(map[string]int),
+		unclaimed: make(map[string][]mqttMessage)}
+}
+
+func newSub(m *MqttMessagebus, topic string, message chan<- string) *MqttSubscription {
+	sub := &MqttSubscription{bus: m, topic: topic, message: message, done: make(chan struct{}),
+		ready: make(chan struct{}, 1)}
+	m.subs[topic] = append(m.subs[topic], sub)
+	return sub
+}
+
+// A queue message goes to one local subscription, and is held until there is one
+func TestDispatchQueue(t *testing.T) {
+	m := newTestBus()
+	publish := func(topic string, payload string) {
+		_, _ = m.dispatch(paho.PublishReceived{Packet: &paho.Publish{Topic: topic, Payload: []byte(payload)}})
+	}
+
+	publish("databus", "early")
+	if got := m.unclaimed["databus"]; len(got) != 1 || string(got[0].body) != "early" {
+		t.Fatalf("unclaimed = %v, want [early]", got)
+	}
+
+	one := make(chan string, 10)
+	two := make(chan string, 10)
+	subOne := newSub(m, "databus", one)
+	subTwo := newSub(m, "databus", two)
+	go subOne.deliver()
+	go subTwo.deliver()
+	defer close(subOne.done)
+	defer close(subTwo.done)
+
+	publish("databus", "a")
+	publish("databus", "b")
+	if got := receive(t, one); got != "a" {
+		t.Errorf("first subscription got %q, want a", got)
+	}
+	if got := receive(t, two); got != "b" {
+		t.Errorf("second subscription got %q, want b", got)
+	}
+	select {
+	case msg := <-one:
+		t.Errorf("first subscription also got %q", msg)
+	case msg := <-two:
+		t.Errorf("second subscription also got %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Subscribe hands over the user properties as headers, and a nacked queue message comes back
+func TestSubscribeHeadersAndNack(t *testing.T) {
+	m := newTestBus()
+	sub := newSub(m, "databus", nil)
+	sub.v2 = messagebus.NewChanSubscription(context.Background(), nil)
+	go sub.deliver()
+	defer sub.v2.Close()
+	defer close(sub.done)
+
+	props := &paho.PublishProperties{}
+	props.User.Add("ReplyTo", "/prometheus")
+	_, _ = m.dispatch(paho.PublishReceived{Packet: &paho.Publish{Topic: "databus", Payload: []byte("one"),
+		Properties: props}})
+
+	next := func() *messagebus.Message {
+		select {
+		case msg := <-sub.v2.Messages():
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message")
+			return nil
+		}
+	}
+	msg := next()
+	if string(msg.Body) != "one" || msg.Headers["ReplyTo"] != "/prometheus" {
+		t.Fatalf("got %q with headers %v", msg.Body, msg.Headers)
+	}
+	if err := msg.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	msg = next()
+	if string(msg.Body) != "one" {
+		t.Fatalf("got %q after nack, want one", msg.Body)
+	}
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A consumer that does not read must not hold up the connection's inbound loop
+func TestDispatchDoesNotBlock(t *testing.T) {
+	m := newTestBus()
+	slow := make(chan string)
+	sub := newSub(m, "databus", slow)
+	go sub.deliver()
+	defer close(sub.done)
+
+	dispatched := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, _ = m.dispatch(paho.PublishReceived{Packet: &paho.Publish{Topic: "databus",
+				Payload: []byte(fmt.Sprint(i))}})
+		}
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked on a slow consumer")
+	}
+	for i := 0; i < 3; i++ {
+		if got := receive(t, slow); got != fmt.Sprint(i) {
+			t.Errorf("got %q, want %d", got, i)
+		}
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	port := startBroker(t)
+
+	mb, err := NewMqttMessageBus("127.0.0.1", port, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mb.Close()
+
+	msgs := make(chan string, 10)
+	sub, err := mb.ReceiveMessage(msgs, "/databus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mb.SendMessage([]byte("one"), "/databus"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.SendMessageWithHeaders([]byte("two"), "/databus", map[string]string{"ReplyTo": "/prometheus"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, msgs); got != "one" {
+		t.Errorf("got %q, want one", got)
+	}
+	if got := receive(t, msgs); got != "two" {
+		t.Errorf("got %q, want two", got)
+	}
+
+	sub.Close()
+	if err := mb.SendMessage([]byte("three"), "/databus"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		t.Errorf("got %q after the subscription was closed", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,123 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package messagebus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// NewCorrelationID returns a random ID for matching a response to the request that asked for it
+func NewCorrelationID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Requester sends requests and hands each response arriving on its response queue to the request carrying the same
+// correlation ID, so concurrent requests can share one queue. Responses must be JSON objects with a "correlationId"
+// member; responses nobody is waiting for any more, e.g. because the request timed out, are dropped.
+type Requester struct {
+	bus   Messagebus
+	queue string
+
+	mu      sync.Mutex
+	sub     Subscription
+	pending map[string]chan string
+}
+
+func NewRequester(bus Messagebus, queue string) *Requester {
+	ret := new(Requester)
+	ret.bus = bus
+	ret.queue = queue
+	ret.pending = make(map[string]chan string)
+	return ret
+}
+
+// start subscribes to the response queue on first use. It is called with mu held.
+func (r *Requester) start() error {
+	if r.sub != nil {
+		return nil
+	}
+	messages := make(chan string, 10)
+	sub, err := r.bus.ReceiveMessage(messages, r.queue)
+	if err != nil {
+		return err
+	}
+	r.sub = sub
+	go func() {
+		for message := range messages {
+			var resp struct {
+				CorrelationID string `json:"correlationId"`
+			}
+			_ = json.Unmarshal([]byte(message), &resp)
+			r.mu.Lock()
+			waiting, ok := r.pending[resp.CorrelationID]
+			delete(r.pending, resp.CorrelationID)
+			r.mu.Unlock()
+			if !ok {
+				log.Printf("Dropping response on %s with no matching request (correlation ID %q)", r.queue, resp.CorrelationID)
+				continue
+			}
+			waiting <- message
+		}
+	}()
+	return nil
+}
+
+// Request calls send with a new correlation ID, which send must put in the request, and returns the response echoing
+// it. It gives up with the context's error once ctx is done.
+func (r *Requester) Request(ctx context.Context, send func(correlationID string) error) (string, error) {
+	id := NewCorrelationID()
+	waiting := make(chan string, 1)
+
+	r.mu.Lock()
+	err := r.start()
+	if err == nil {
+		r.pending[id] = waiting
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	err = send(id)
+	if err != nil {
+		return "", err
+	}
+	select {
+	case message := <-waiting:
+		return message, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Requesters keeps one Requester per response queue. The zero value is ready to use.
+type Requesters struct {
+	mu         sync.Mutex
+	requesters map[string]*Requester
+}
+
+func (r *Requesters) Get(bus Messagebus, queue string) *Requester {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.requesters == nil {
+		r.requesters = make(map[string]*Requester)
+	}
+	ret, ok := r.requesters[queue]
+	if !ok || ret.bus != bus {
+		ret = NewRequester(bus, queue)
+		r.requesters[queue] = ret
+	}
+	return ret
+}
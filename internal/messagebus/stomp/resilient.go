@@ -0,0 +1,459 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package stomp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/go-stomp/stomp"
+	"github.com/go-stomp/stomp/frame"
+
+	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/messagebus"
+)
+
+const (
+	// DefaultHeartBeat is used for both directions, so a broker that stops answering is noticed within about
+	// twice this interval instead of never.
+	DefaultHeartBeat = 30 * time.Second
+	// DefaultMaxPendingSends is how many sends are buffered while the broker is unreachable
+	DefaultMaxPendingSends = 1000
+)
+
+var ErrNotConnected = errors.New("stomp message bus not connected and send buffer is full")
+
+type pendingSend struct {
+	queue   string
+	message []byte
+	headers map[string]string
+}
+
+// ResilientStompMessagebus wraps a STOMP connection that is re-established with backoff whenever heartbeats or
+// reads show it has died. Active subscriptions are re-created on the new connection and sends made while
+// disconnected are buffered up to MaxPendingSends, after which they fail with ErrNotConnected.
+type ResilientStompMessagebus struct {
+	addr            string
+	tlsConfig       *tls.Config
+	HeartBeat       time.Duration
+	MaxPendingSends int
+
+	mu      sync.Mutex
+	conn    *stomp.Conn
+	state   string
+	subs    map[*ResilientStompSubscription]struct{}
+	pending []pendingSend
+
+	lost   chan *stomp.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type ResilientStompSubscription struct {
+	bus     *ResilientStompMessagebus
+	queue   string
+	ackMode stomp.AckMode
+	// deliver hands a message to the subscriber, which is responsible for acknowledging it
+	deliver func(conn *stomp.Conn, msg *stomp.Message)
+	// busClosed, if set, is told when the whole bus is closed under the subscription
+	busClosed func(err error)
+
+	mu     sync.Mutex
+	conn   *stomp.Conn
+	sub    *stomp.Subscription
+	closed bool
+}
+
+func init() {
+	messagebus.Register(messagebus.STOMP, func(cfg *messagebus.Config) (messagebus.Messagebus, error) {
+		var tlsConfig *tls.Config
+		if cfg.TLS != nil {
+			var err error
+			tlsConfig, err = cfg.TLS.ClientConfig()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewResilientStompMessageBusTLS(cfg.Host, cfg.Port, tlsConfig)
+	})
+}
+
+func NewResilientStompMessageBus(host string, port int) (messagebus.StatefulMessagebus, error) {
+	return NewResilientStompMessageBusTLS(host, port, nil)
+}
+
+// NewResilientStompMessageBusTLS is NewResilientStompMessageBus over TLS. A nil tlsConfig means plain TCP.
+func NewResilientStompMessageBusTLS(host string, port int, tlsConfig *tls.Config) (messagebus.StatefulMessagebus, error) {
+	ret := new(ResilientStompMessagebus)
+	ret.addr = fmt.Sprintf("%s:%d", host, port)
+	ret.tlsConfig = tlsConfig
+	ret.HeartBeat = DefaultHeartBeat
+	ret.MaxPendingSends = DefaultMaxPendingSends
+	ret.subs = make(map[*ResilientStompSubscription]struct{})
+	ret.lost = make(chan *stomp.Conn, 1)
+	ret.ctx, ret.cancel = context.WithCancel(context.Background())
+
+	conn, err := ret.dial()
+	if err != nil {
+		return nil, err
+	}
+	ret.conn = conn
+	ret.state = messagebus.CONNECTED
+
+	go ret.reconnectLoop()
+
+	intRet := messagebus.StatefulMessagebus(ret)
+	return intRet, nil
+}
+
+func (m *ResilientStompMessagebus) dial() (*stomp.Conn, error) {
+	heartBeat := stomp.ConnOpt.HeartBeat(m.HeartBeat, m.HeartBeat)
+	if m.tlsConfig == nil {
+		return stomp.Dial("tcp", m.addr, heartBeat)
+	}
+	netConn, err := tls.Dial("tcp", m.addr, m.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := stomp.Connect(netConn, heartBeat)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// State reports whether the bus is currently connected to the broker
+func (m *ResilientStompMessagebus) State() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// connectionLost is called by anyone who sees conn fail. Reports about a connection that was already replaced are
+// ignored.
+func (m *ResilientStompMessagebus) connectionLost(conn *stomp.Conn) {
+	m.mu.Lock()
+	if m.conn != conn || m.state != messagebus.CONNECTED {
+		m.mu.Unlock()
+		return
+	}
+	m.state = messagebus.DISCONNECTED
+	m.mu.Unlock()
+
+	log.Printf("Lost connection to message bus at %s", m.addr)
+	select {
+	case m.lost <- conn:
+	default:
+	}
+}
+
+func (m *ResilientStompMessagebus) reconnectLoop() {
+	for {
+		var old *stomp.Conn
+		select {
+		case <-m.ctx.Done():
+			return
+		case old = <-m.lost:
+		}
+		// Heartbeat or read failures leave the old connection half open, drop it before dialing again
+		_ = old.MustDisconnect()
+
+		b := backoff.NewExponentialBackOff()
+		b.MaxElapsedTime = 0
+		var conn *stomp.Conn
+		err := backoff.RetryNotify(func() error {
+			var err error
+			conn, err = m.dial()
+			return err
+		}, backoff.WithContext(b, m.ctx), func(err error, wait time.Duration) {
+			log.Printf("Could not reconnect to message bus at %s, retrying in %s: %v", m.addr, wait, err)
+		})
+		if err != nil {
+			// only happens when the bus is closed
+			return
+		}
+
+		m.mu.Lock()
+		if m.state == messagebus.CLOSED {
+			// Close ran while dialing and disconnected the old connection, do not leave this one open
+			m.mu.Unlock()
+			_ = conn.Disconnect()
+			return
+		}
+		m.conn = conn
+		m.mu.Unlock()
+
+		if !m.flushPending(conn) {
+			// Still marked disconnected, so go around again
+			m.lost <- conn
+			continue
+		}
+
+		// Anything subscribed before the state flipped to connected is in this snapshot, anything after
+		// subscribed itself in ReceiveMessage
+		m.mu.Lock()
+		subs := make([]*ResilientStompSubscription, 0, len(m.subs))
+		for sub := range m.subs {
+			subs = append(subs, sub)
+		}
+		m.mu.Unlock()
+		for _, sub := range subs {
+			err = sub.subscribe(conn)
+			if err != nil {
+				log.Printf("Failed to resubscribe to %s: %v", sub.queue, err)
+				m.connectionLost(conn)
+				break
+			}
+		}
+		if err == nil {
+			log.Printf("Reconnected to message bus at %s, resubscribed %d queues", m.addr, len(subs))
+		}
+	}
+}
+
+// flushPending sends everything buffered while disconnected, then marks the bus connected. New sends keep being
+// buffered until the buffer has drained so ordering is preserved.
+func (m *ResilientStompMessagebus) flushPending(conn *stomp.Conn) bool {
+	for {
+		m.mu.Lock()
+		if len(m.pending) == 0 {
+			m.state = messagebus.CONNECTED
+			m.mu.Unlock()
+			return true
+		}
+		batch := m.pending
+		m.pending = nil
+		m.mu.Unlock()
+
+		for i, p := range batch {
+			err := send(conn, p.message, p.queue, p.headers)
+			if err != nil {
+				log.Printf("Failed to flush buffered messages: %v", err)
+				m.mu.Lock()
+				m.pending = append(batch[i:], m.pending...)
+				m.mu.Unlock()
+				return false
+			}
+		}
+	}
+}
+
+func send(conn *stomp.Conn, message []byte, queue string, headers map[string]string) error {
+	return conn.Send(queue, "text/plain", message, func(frame *frame.Frame) error {
+		for key, value := range headers {
+			frame.Header.Set(key, value)
+		}
+		return nil
+	})
+}
+
+// bufferLocked must be called with m.mu held
+func (m *ResilientStompMessagebus) bufferLocked(message []byte, queue string, headers map[string]string) error {
+	if len(m.pending) >= m.MaxPendingSends {
+		return ErrNotConnected
+	}
+	body := make([]byte, len(message))
+	copy(body, message)
+	m.pending = append(m.pending, pendingSend{queue: queue, message: body, headers: headers})
+	return nil
+}
+
+func (m *ResilientStompMessagebus) SendMessage(message []byte, queue string) error {
+	return m.SendMessageWithHeaders(message, queue, nil)
+}
+
+func (m *ResilientStompMessagebus) SendMessageWithHeaders(message []byte, queue string, headers map[string]string) error {
+	m.mu.Lock()
+	switch m.state {
+	case messagebus.CLOSED:
+		m.mu.Unlock()
+		return stomp.ErrAlreadyClosed
+	case messagebus.DISCONNECTED:
+		err := m.bufferLocked(message, queue, headers)
+		m.mu.Unlock()
+		return err
+	}
+	conn := m.conn
+	m.mu.Unlock()
+
+	err := send(conn, message, queue, headers)
+	if err != nil {
+		m.connectionLost(conn)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.state == messagebus.CLOSED {
+			return err
+		}
+		return m.bufferLocked(message, queue, headers)
+	}
+	return nil
+}
+
+func (m *ResilientStompMessagebus) ReceiveMessage(message chan<- string, queue string) (messagebus.Subscription, error) {
+	mySub := new(ResilientStompSubscription)
+	mySub.bus = m
+	mySub.queue = queue
+	mySub.ackMode = stomp.AckClient
+	mySub.deliver = func(conn *stomp.Conn, msg *stomp.Message) {
+		message <- string(msg.Body)
+		err := conn.Ack(msg)
+		if err != nil {
+			log.Printf("ACK failed! %v", err)
+		}
+	}
+	err := m.addSubscription(mySub)
+	if err != nil {
+		return nil, err
+	}
+	return messagebus.Subscription(mySub), nil
+}
+
+func (m *ResilientStompMessagebus) Send(ctx context.Context, queue string, body []byte, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.SendMessageWithHeaders(body, queue, headers)
+}
+
+// Subscribe implements messagebus.MessagebusV2. Messages are acknowledged individually, and ones that are never
+// acked are redelivered by the broker once the subscription or connection goes away.
+func (m *ResilientStompMessagebus) Subscribe(ctx context.Context, queue string) (messagebus.SubscriptionV2, error) {
+	mySub := new(ResilientStompSubscription)
+	mySub.bus = m
+	mySub.queue = queue
+	mySub.ackMode = stomp.AckClientIndividual
+	ret := messagebus.NewChanSubscription(ctx, mySub.Close)
+	mySub.busClosed = ret.Fail
+	mySub.deliver = func(conn *stomp.Conn, msg *stomp.Message) {
+		headers := make(map[string]string, msg.Header.Len())
+		for i := 0; i < msg.Header.Len(); i++ {
+			key, value := msg.Header.GetAt(i)
+			headers[key] = value
+		}
+		ack := func() error {
+			return conn.Ack(msg)
+		}
+		nack := func() error {
+			return conn.Nack(msg)
+		}
+		if !ret.Deliver(messagebus.NewMessage(queue, msg.Body, headers, ack, nack)) {
+			_ = conn.Nack(msg)
+		}
+	}
+	err := m.addSubscription(mySub)
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (m *ResilientStompMessagebus) addSubscription(mySub *ResilientStompSubscription) error {
+	m.mu.Lock()
+	if m.state == messagebus.CLOSED {
+		m.mu.Unlock()
+		return stomp.ErrAlreadyClosed
+	}
+	m.subs[mySub] = struct{}{}
+	state := m.state
+	conn := m.conn
+	m.mu.Unlock()
+
+	// While disconnected the subscription is created by reconnectLoop once the broker is back
+	if state == messagebus.CONNECTED {
+		err := mySub.subscribe(conn)
+		if err != nil {
+			m.connectionLost(conn)
+		}
+	}
+	return nil
+}
+
+func (m *ResilientStompMessagebus) Close() error {
+	m.mu.Lock()
+	if m.state == messagebus.CLOSED {
+		m.mu.Unlock()
+		return nil
+	}
+	m.state = messagebus.CLOSED
+	subs := m.subs
+	m.subs = make(map[*ResilientStompSubscription]struct{})
+	conn := m.conn
+	m.mu.Unlock()
+
+	m.cancel()
+	for sub := range subs {
+		err := sub.unsubscribe()
+		if err != nil {
+			log.Printf("Failed to unsubscribe %v", err)
+		}
+		if sub.busClosed != nil {
+			sub.busClosed(stomp.ErrAlreadyClosed)
+		}
+	}
+	return conn.Disconnect()
+}
+
+func (s *ResilientStompSubscription) subscribe(conn *stomp.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.conn == conn {
+		return nil
+	}
+	sub, err := conn.Subscribe(s.queue, s.ackMode)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.sub = sub
+	go s.RecieveLoop(conn, sub)
+	return nil
+}
+
+// RecieveLoop hands messages to the caller until the underlying subscription ends. If that happens without Close
+// being called the connection is treated as dead.
+func (s *ResilientStompSubscription) RecieveLoop(conn *stomp.Conn, sub *stomp.Subscription) {
+	for {
+		msg := <-sub.C
+		if msg == nil {
+			break
+		} else if msg.Err != nil {
+			//This can timeout... just keep going...
+			continue
+		}
+		s.deliver(conn, msg)
+	}
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed {
+		s.bus.connectionLost(conn)
+	}
+}
+
+func (s *ResilientStompSubscription) unsubscribe() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.sub == nil || !s.sub.Active() {
+		return nil
+	}
+	return s.sub.Unsubscribe()
+}
+
+func (s *ResilientStompSubscription) Close() error {
+	s.bus.mu.Lock()
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+	return s.unsubscribe()
+}
@@ -0,0 +1,279 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package messagebus
+
+import (
+	"context"
+	"sync"
+)
+
+// Message is a message received through MessagebusV2. The receiver must call Ack once it has finished with the
+// message, or Nack to have the broker deliver it again. Backends without acknowledgements treat both as no-ops.
+type Message struct {
+	Queue   string
+	Body    []byte
+	Headers map[string]string
+
+	ack  func() error
+	nack func() error
+	once sync.Once
+}
+
+// NewMessage is used by backends to build a received message. A nil ack or nack makes that operation a no-op.
+func NewMessage(queue string, body []byte, headers map[string]string, ack func() error, nack func() error) *Message {
+	ret := new(Message)
+	ret.Queue = queue
+	ret.Body = body
+	ret.Headers = headers
+	ret.ack = ack
+	ret.nack = nack
+	return ret
+}
+
+// Ack settles the message as processed. Only the first Ack or Nack has any effect.
+func (m *Message) Ack() error {
+	return m.settle(m.ack)
+}
+
+// Nack settles the message as not processed so it can be delivered again. Only the first Ack or Nack has any effect.
+func (m *Message) Nack() error {
+	return m.settle(m.nack)
+}
+
+func (m *Message) settle(fn func() error) error {
+	var err error
+	m.once.Do(func() {
+		if fn != nil {
+			err = fn()
+		}
+	})
+	return err
+}
+
+// SubscriptionV2 delivers messages until its context is cancelled, it is closed, or the backend gives up on it.
+// Messages is closed at that point and Err reports why: nil after Close, the context error after cancellation, or
+// the backend error.
+type SubscriptionV2 interface {
+	Messages() <-chan *Message
+	Err() error
+	Close() error
+}
+
+// MessagebusV2 is a context aware, byte oriented message bus. Unlike Messagebus it hands received headers to the
+// subscriber and lets it decide when a message is acknowledged, so a consumer can ack only after it has safely
+// processed a message. Use AsV2 and AsV1 to move between the two interfaces.
+type MessagebusV2 interface {
+	Send(ctx context.Context, queue string, body []byte, headers map[string]string) error
+	Subscribe(ctx context.Context, queue string) (SubscriptionV2, error)
+	Close() error
+}
+
+// ChanSubscription implements SubscriptionV2 on top of a channel. Backends feed it with Deliver and stop feeding it
+// once Done is closed. The stop function passed to NewChanSubscription releases the backend side and is called
+// exactly once when the subscription ends.
+type ChanSubscription struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	msgs   chan *Message
+	stop   func() error
+
+	// mu is held for reading by Deliver, which may block, so only end takes it for writing and only after cancel
+	mu    sync.RWMutex
+	ended bool
+
+	errMu  sync.Mutex
+	err    error
+	closed bool
+}
+
+func NewChanSubscription(ctx context.Context, stop func() error) *ChanSubscription {
+	ret := new(ChanSubscription)
+	ret.ctx, ret.cancel = context.WithCancel(ctx)
+	ret.msgs = make(chan *Message)
+	ret.stop = stop
+
+	go func() {
+		<-ret.ctx.Done()
+		ret.setErr(ctx.Err())
+		ret.end()
+	}()
+	return ret
+}
+
+// Deliver blocks until the subscriber takes msg or the subscription ends, and reports whether msg was taken
+func (s *ChanSubscription) Deliver(msg *Message) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ended {
+		return false
+	}
+	select {
+	case s.msgs <- msg:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// Done is closed once the subscription is ending
+func (s *ChanSubscription) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Fail ends the subscription with err
+func (s *ChanSubscription) Fail(err error) {
+	s.setErr(err)
+	s.cancel()
+}
+
+// setErr keeps the first reason the subscription ended. Close counts as a reason without an error.
+func (s *ChanSubscription) setErr(err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	if s.err == nil && !s.closed {
+		s.err = err
+	}
+}
+
+// end must only be called once the context is done, so that Deliver has let go of mu
+func (s *ChanSubscription) end() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	close(s.msgs)
+	s.mu.Unlock()
+
+	if s.stop != nil {
+		if err := s.stop(); err != nil {
+			s.setErr(err)
+		}
+	}
+}
+
+func (s *ChanSubscription) Messages() <-chan *Message {
+	return s.msgs
+}
+
+func (s *ChanSubscription) Err() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+func (s *ChanSubscription) Close() error {
+	s.errMu.Lock()
+	if s.err == nil {
+		s.closed = true
+	}
+	s.errMu.Unlock()
+	s.cancel()
+	s.end()
+	return nil
+}
+
+// AsV2 returns mb as a MessagebusV2. Backends that implement it natively are returned as is, anything else is
+// wrapped: the wrapped bus acknowledges on receipt, so Ack and Nack are no-ops and no headers are received.
+func AsV2(mb Messagebus) MessagebusV2 {
+	if v2, ok := mb.(MessagebusV2); ok {
+		return v2
+	}
+	return &v1Adapter{mb: mb}
+}
+
+// AsV1 returns mb as a Messagebus so existing callers can use a v2 only backend. Messages are acknowledged once
+// they have been handed to the receive channel.
+func AsV1(mb MessagebusV2) Messagebus {
+	if v1, ok := mb.(Messagebus); ok {
+		return v1
+	}
+	return &v2Adapter{mb: mb}
+}
+
+type v1Adapter struct {
+	mb Messagebus
+}
+
+func (a *v1Adapter) Send(ctx context.Context, queue string, body []byte, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.mb.SendMessageWithHeaders(body, queue, headers)
+}
+
+func (a *v1Adapter) Subscribe(ctx context.Context, queue string) (SubscriptionV2, error) {
+	messages := make(chan string)
+	sub, err := a.mb.ReceiveMessage(messages, queue)
+	if err != nil {
+		return nil, err
+	}
+	ret := NewChanSubscription(ctx, sub.Close)
+	go func() {
+		for {
+			select {
+			case body := <-messages:
+				if !ret.Deliver(NewMessage(queue, []byte(body), nil, nil, nil)) {
+					return
+				}
+			case <-ret.Done():
+				return
+			}
+		}
+	}()
+	return ret, nil
+}
+
+func (a *v1Adapter) Close() error {
+	return a.mb.Close()
+}
+
+type v2Adapter struct {
+	mb MessagebusV2
+}
+
+type v2AdapterSubscription struct {
+	sub  SubscriptionV2
+	done chan struct{}
+	once sync.Once
+}
+
+func (a *v2Adapter) SendMessage(message []byte, queue string) error {
+	return a.mb.Send(context.Background(), queue, message, nil)
+}
+
+func (a *v2Adapter) SendMessageWithHeaders(message []byte, queue string, headers map[string]string) error {
+	return a.mb.Send(context.Background(), queue, message, headers)
+}
+
+func (a *v2Adapter) ReceiveMessage(message chan<- string, queue string) (Subscription, error) {
+	sub, err := a.mb.Subscribe(context.Background(), queue)
+	if err != nil {
+		return nil, err
+	}
+	mySub := &v2AdapterSubscription{sub: sub, done: make(chan struct{})}
+	go func() {
+		for msg := range sub.Messages() {
+			select {
+			case message <- string(msg.Body):
+				_ = msg.Ack()
+			case <-mySub.done:
+				_ = msg.Nack()
+				return
+			}
+		}
+	}()
+	return Subscription(mySub), nil
+}
+
+func (a *v2Adapter) Close() error {
+	return a.mb.Close()
+}
+
+func (s *v2AdapterSubscription) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return s.sub.Close()
+}
@@ -0,0 +1,345 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package redfish
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SysInfo identifies a device and its firmware
+type SysInfo struct {
+	HostName string
+	SKU      string
+	Model    string
+	FwVer    string
+	FQDN     string
+	ImgID    string
+}
+
+// Profile holds what differs between Redfish implementations: how a device is identified, how its SSE streams are
+// filtered and how its metric values are named. Init picks the first registered profile that matches the service.
+type Profile interface {
+	// Name identifies the vendor and generation, e.g. "Dell iDRAC9"
+	Name() string
+	// Match reports whether the profile fits the service with serviceRoot, whose first manager is manager (nil if it
+	// has none)
+	Match(serviceRoot *RedfishPayload, manager *RedfishPayload) bool
+	// SystemID is the ID the device's values are reported under, such as its service tag or serial number
+	SystemID(r *RedfishClient) (string, error)
+	SysInfo(r *RedfishClient) (SysInfo, error)
+	// SSEFilter is the query selecting events of formatType (MetricReportFormat, EventFormat or LifecycleFormat) on
+	// the ServerSentEventUri, "" to take every event
+	SSEFilter(formatType string) string
+	// ValueIdentity returns the ID, context and label of a MetricValue of a metric report. The ID is "" if the value
+	// names no metric.
+	ValueIdentity(value map[string]interface{}) (id string, context string, label string)
+}
+
+var (
+	profilesMu sync.Mutex
+	profiles   = []Profile{idrac10Profile, idrac9Profile}
+)
+
+// RegisterProfile adds a profile, tried before those registered earlier and the built in ones. The generic DMTF
+// profile is used for services no profile matches.
+func RegisterProfile(profile Profile) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	profiles = append([]Profile{profile}, profiles...)
+}
+
+// Profile is the profile of the service, the generic DMTF one until Init has found out or if r is nil
+func (r *RedfishClient) Profile() Profile {
+	if r == nil || r.profile == nil {
+		return dmtfProfile
+	}
+	return r.profile
+}
+
+// detectProfile finds the system and manager of the service and the profile that matches it
+func (r *RedfishClient) detectProfile(serviceRoot *RedfishPayload) {
+	r.systemUri = r.firstMember("/redfish/v1/Systems")
+	r.managerUri = r.firstMember("/redfish/v1/Managers")
+	var manager *RedfishPayload
+	if r.managerUri != "" {
+		manager, _ = r.GetUri(r.managerUri)
+	}
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	r.profile = dmtfProfile
+	for _, profile := range profiles {
+		if profile.Match(serviceRoot, manager) {
+			r.profile = profile
+			break
+		}
+	}
+	log.Printf("%s: Using the %s profile", r.Hostname, r.profile.Name())
+}
+
+// firstMember is the URI of the first member of collection, or "" if it has none
+func (r *RedfishClient) firstMember(collection string) string {
+	payload, err := r.GetUri(collection)
+	if err != nil {
+		return ""
+	}
+	members, _ := payload.Object["Members"].([]interface{})
+	if len(members) == 0 {
+		return ""
+	}
+	return getUriFromValue(members[0])
+}
+
+// SystemUri and ManagerUri are the first members of the Systems and Managers collections
+func (r *RedfishClient) SystemUri() string {
+	return r.systemUri
+}
+
+func (r *RedfishClient) ManagerUri() string {
+	return r.managerUri
+}
+
+func stringProperty(object map[string]interface{}, name string) string {
+	value, _ := object[name].(string)
+	return value
+}
+
+// metricID is the MetricId of value, or if it has none the last part of its MetricProperty: /abc/def#ghi => def_ghi
+func metricID(value map[string]interface{}) string {
+	id := stringProperty(value, "MetricId")
+	if id == "" {
+		id = stringProperty(value, "MetricProperty")
+		li := strings.LastIndex(id, "/")
+		if li != -1 {
+			id = id[li+1:]
+		}
+		id = strings.ReplaceAll(id, "#", "_")
+	}
+	return id
+}
+
+// genericSysInfo reads SysInfo from the standard properties of the service's system and manager
+func genericSysInfo(r *RedfishClient) (info SysInfo, err error) {
+	if r.systemUri == "" {
+		return info, errors.New("no system found")
+	}
+	system, err := r.GetUri(r.systemUri + "?$select=HostName,SKU,Model")
+	if err != nil {
+		return
+	}
+	info.HostName = stringProperty(system.Object, "HostName")
+	info.SKU = stringProperty(system.Object, "SKU")
+	info.Model = stringProperty(system.Object, "Model")
+
+	if r.managerUri == "" {
+		return
+	}
+	nic := r.firstMember(r.managerUri + "/EthernetInterfaces")
+	if nic != "" {
+		if nicPayload, err := r.GetUri(nic + "?$select=FQDN"); err == nil {
+			info.FQDN = stringProperty(nicPayload.Object, "FQDN")
+		}
+	}
+	manager, err := r.GetUri(r.managerUri + "?$select=FirmwareVersion,Links")
+	if err != nil {
+		return
+	}
+	info.FwVer = stringProperty(manager.Object, "FirmwareVersion")
+	if links, ok := manager.Object["Links"].(map[string]interface{}); ok {
+		info.ImgID = getUriFromValue(links["ActiveSoftwareImage"])
+		if info.ImgID != "" {
+			info.ImgID = info.ImgID[strings.LastIndex(info.ImgID, "/")+1:]
+		}
+	}
+	return
+}
+
+// dellProfile is an iDRAC, or an MSM or EC chassis manager, of one generation. The SSE filter syntax changed with
+// iDRAC10.
+type dellProfile struct {
+	name         string
+	metricFilter string
+	eventFilter  string
+	match        func(manager *RedfishPayload) bool
+}
+
+// lifecycleFilter selects the Lifecycle Controller events, which iDRACs send with EventType Other
+const lifecycleFilter = "?$filter=EventType%20eq%20%27Other%27"
+
+var idrac9Profile = &dellProfile{
+	name:         "Dell iDRAC9",
+	metricFilter: "?$filter=EventFormatType%20eq%20MetricReport",
+	eventFilter:  "?$filter=EventFormatType%20eq%20Event",
+	match:        func(manager *RedfishPayload) bool { return true },
+}
+
+var idrac10Profile = &dellProfile{
+	name:         "Dell iDRAC10",
+	metricFilter: "?$filter=EventFormatType%20eq%20%27MetricReport%27",
+	eventFilter:  "?$filter=EventType%20eq%20%27Alert%27",
+	match:        isIdrac10,
+}
+
+// poweredgeGeneration finds the generation in a manager model such as "17G Monolithic"
+var poweredgeGeneration = regexp.MustCompile(`^(\d+)G\b`)
+
+// isIdrac10 tells iDRAC10 (17G) from iDRAC9 by the generation in the manager's model. Managers that do not give their
+// generation are taken for iDRAC9, whose filters the MSM and EC chassis managers take too.
+func isIdrac10(manager *RedfishPayload) bool {
+	if manager == nil {
+		return false
+	}
+	match := poweredgeGeneration.FindStringSubmatch(stringProperty(manager.Object, "Model"))
+	if match == nil {
+		return false
+	}
+	generation, _ := strconv.Atoi(match[1])
+	return generation >= 17
+}
+
+func (p *dellProfile) Name() string {
+	return p.name
+}
+
+func (p *dellProfile) Match(serviceRoot *RedfishPayload, manager *RedfishPayload) bool {
+	oem, _ := serviceRoot.Object["Oem"].(map[string]interface{})
+	if oem["Dell"] == nil && !strings.EqualFold(stringProperty(serviceRoot.Object, "Vendor"), "Dell") {
+		return false
+	}
+	return p.match(manager)
+}
+
+func (p *dellProfile) SystemID(r *RedfishClient) (string, error) {
+	serviceRoot, err := r.GetUri("/redfish/v1")
+	if err != nil {
+		return "", err
+	}
+	//iDRAC
+	oem, _ := serviceRoot.Object["Oem"].(map[string]interface{})
+	dell, _ := oem["Dell"].(map[string]interface{})
+	if serviceTag := stringProperty(dell, "ServiceTag"); serviceTag != "" {
+		return serviceTag, nil
+	}
+	//log.Printf("%s: No Oem/Dell/ServiceTag elem!", r.Hostname)
+	chassisCollection, err := serviceRoot.GetPropertyByName("Chassis")
+	if err != nil {
+		return "", err
+	}
+	size := chassisCollection.GetCollectionSize()
+	for i := 0; i < size; i++ {
+		chassis, err := chassisCollection.GetPropertyByIndex(i)
+		if err != nil {
+			continue
+		}
+		if stringProperty(chassis.Object, "ChassisType") == "Enclosure" {
+			if stringProperty(chassis.Object, "Name") == "Blade Chassis" {
+				//EC case...
+				if sku := stringProperty(chassis.Object, "SKU"); sku != "" {
+					return sku, nil
+				}
+				chassisUri := stringProperty(chassis.Object, "@odata.id")
+				if chassisUri == "" {
+					return "", errors.New("Unable to determine System ID, blade chassis has no @odata.id")
+				}
+				oemChassis, err := r.GetUri(chassisUri + "/Attributes")
+				if err != nil {
+					return "", err
+				}
+				attributes, _ := oemChassis.Object["Attributes"].(map[string]interface{})
+				if mac := stringProperty(attributes, "NIC.1.MACAddress"); mac != "" {
+					return mac, nil
+				}
+				return "", fmt.Errorf("Unable to determine System ID, %s has no NIC.1.MACAddress", chassisUri)
+			}
+			if name := stringProperty(chassis.Object, "Name"); name != "" {
+				return name, nil
+			}
+		}
+	}
+	return "", errors.New("Unable to determine System ID")
+}
+
+func (p *dellProfile) SysInfo(r *RedfishClient) (SysInfo, error) {
+	return genericSysInfo(r)
+}
+
+func (p *dellProfile) SSEFilter(formatType string) string {
+	switch formatType {
+	case MetricReportFormat:
+		return p.metricFilter
+	case LifecycleFormat:
+		return lifecycleFilter
+	}
+	return p.eventFilter
+}
+
+// ValueIdentity takes the context and label from the value's Oem/Dell, which iDRACs fill in
+func (p *dellProfile) ValueIdentity(value map[string]interface{}) (string, string, string) {
+	id := metricID(value)
+	if oem, ok := value["Oem"].(map[string]interface{}); ok {
+		if dell, ok := oem["Dell"].(map[string]interface{}); ok {
+			if dell["ContextID"] != nil && dell["Label"] != nil {
+				return id, stringProperty(dell, "ContextID"), stringProperty(dell, "Label")
+			}
+		}
+	}
+	return id, "", id
+}
+
+// genericProfile is any service following the DMTF schemas. Services differ too much in the filters they take, so it
+// takes every event and leaves telling them apart to the listeners.
+type genericProfile struct{}
+
+var dmtfProfile Profile = genericProfile{}
+
+func (genericProfile) Name() string {
+	return "DMTF Redfish"
+}
+
+func (genericProfile) Match(serviceRoot *RedfishPayload, manager *RedfishPayload) bool {
+	return true
+}
+
+// SystemID is the serial number of the system, or its SKU or UUID
+func (genericProfile) SystemID(r *RedfishClient) (string, error) {
+	if r.systemUri == "" {
+		return "", errors.New("Unable to determine System ID, no system found")
+	}
+	system, err := r.GetUri(r.systemUri)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"SerialNumber", "SKU", "UUID"} {
+		if id := strings.TrimSpace(stringProperty(system.Object, name)); id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to determine System ID, %s has no serial number, SKU or UUID", r.systemUri)
+}
+
+func (genericProfile) SysInfo(r *RedfishClient) (SysInfo, error) {
+	return genericSysInfo(r)
+}
+
+func (genericProfile) SSEFilter(formatType string) string {
+	return ""
+}
+
+// ValueIdentity takes the context from the MetricProperty, the resource the value was read from
+func (genericProfile) ValueIdentity(value map[string]interface{}) (string, string, string) {
+	id := metricID(value)
+	context := stringProperty(value, "MetricProperty")
+	context = strings.TrimPrefix(context, "/redfish/v1/")
+	if li := strings.LastIndex(context, "/"); li != -1 {
+		context = context[:li]
+	}
+	if context == "" {
+		return id, "", id
+	}
+	return id, context, context + " " + id
+}
@@ -0,0 +1,192 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package redfish
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Event modes. With SSE the client holds a connection to the service's ServerSentEventUri, with PUSH the service
+	// POSTs to a PushListener through an EventService subscription, and with PULL the client GETs the metric reports
+	// on their schedules.
+	SSE  = "sse"
+	PUSH = "push"
+	PULL = "pull"
+
+	// Event format types of an EventService subscription
+	MetricReportFormat = "MetricReport"
+	EventFormat        = "Event"
+	// LifecycleFormat selects the Lifecycle Controller events from the SSE stream. They cannot be subscribed to apart
+	// from the alerts.
+	LifecycleFormat = "Lifecycle"
+
+	subscriptionsUri = "/redfish/v1/EventService/Subscriptions"
+	// PushPath is where a PushListener takes events, followed by the key of the subscription
+	PushPath = "/redfish/events/"
+)
+
+// ErrNoSSE is returned by ListenForAlerts, ListenForMetricReports and ListenForLceEvents when the service has no
+// ServerSentEventUri
+var ErrNoSSE = errors.New("service has no ServerSentEventUri, use push mode")
+
+// PushListener takes the events services POST to their EventService subscriptions and hands each to the channel
+// registered for the subscription. Subscriptions are told apart by a random key in the destination URL, which also
+// keeps anybody who does not know it from injecting events.
+type PushListener struct {
+	// URL is where services reach the listener, e.g. https://collector.example.com:8443
+	URL string
+
+	mu           sync.Mutex
+	destinations map[string]pushDestination
+}
+
+type pushDestination struct {
+	client *RedfishClient
+	event  chan<- *RedfishEvent
+}
+
+func NewPushListener(url string) *PushListener {
+	ret := new(PushListener)
+	ret.URL = strings.TrimSuffix(url, "/")
+	ret.destinations = make(map[string]pushDestination)
+	return ret
+}
+
+// register returns the destination URL under which the events for the new key go to event
+func (l *PushListener) register(client *RedfishClient, event chan<- *RedfishEvent) (string, string) {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	key := hex.EncodeToString(b)
+	l.mu.Lock()
+	l.destinations[key] = pushDestination{client: client, event: event}
+	l.mu.Unlock()
+	return key, l.URL + PushPath + key
+}
+
+func (l *PushListener) unregister(key string) {
+	l.mu.Lock()
+	delete(l.destinations, key)
+	l.mu.Unlock()
+}
+
+func (l *PushListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || !strings.HasPrefix(req.URL.Path, PushPath) {
+		http.NotFound(w, req)
+		return
+	}
+	l.mu.Lock()
+	dest, ok := l.destinations[strings.TrimPrefix(req.URL.Path, PushPath)]
+	l.mu.Unlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ret := new(RedfishPayload)
+	err = json.Unmarshal(body, &ret.Object)
+	if err != nil {
+		log.Printf("Failed to parse pushed event from %s: %v", dest.client.Hostname, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ret.Client = dest.client
+	redfishEvent := new(RedfishEvent)
+	redfishEvent.Payload = ret
+	if id, ok := ret.Object["Id"].(string); ok {
+		redfishEvent.ID = id
+	}
+	select {
+	case dest.event <- redfishEvent:
+		w.WriteHeader(http.StatusNoContent)
+	case <-req.Context().Done():
+	}
+}
+
+// ListenForPush subscribes listener to the service's events of formatType (MetricReportFormat or EventFormat) and
+// passes them to event until Ctx is done, then deletes the subscription. Subscriptions an earlier run left behind for
+// the listener are deleted first. Like the SSE listeners it sends an event carrying the error if it cannot subscribe.
+func (r *RedfishClient) ListenForPush(Ctx context.Context, event chan<- *RedfishEvent, listener *PushListener, formatType string) {
+	r.deleteSubscriptions(listener.URL+PushPath, formatType)
+
+	key, destination := listener.register(r, event)
+	defer listener.unregister(key)
+	body := map[string]interface{}{
+		"Destination":     destination,
+		"EventFormatType": formatType,
+		"Protocol":        "Redfish",
+		"Context":         "iDRAC-Telemetry-Reference-Tools",
+	}
+	// older services want the event types as well
+	if formatType == MetricReportFormat {
+		body["EventTypes"] = []string{"MetricReport"}
+	} else {
+		body["EventTypes"] = []string{"Alert"}
+	}
+	subscription, err := r.PostUri(subscriptionsUri, body)
+	if err != nil {
+		log.Printf("%s: Failed to subscribe to %s events: %v", r.Hostname, formatType, err)
+		// the caller subscribes again right away, give the service a break first
+		select {
+		case <-time.After(time.Minute):
+		case <-Ctx.Done():
+			return
+		}
+		ret := new(RedfishEvent)
+		ret.Err = err
+		event <- ret
+		return
+	}
+	log.Printf("%s: Subscribed to %s events at %s", r.Hostname, formatType, subscription)
+
+	<-Ctx.Done()
+	err = r.DeleteUri(subscription)
+	if err != nil {
+		log.Printf("%s: Failed to delete subscription %s: %v", r.Hostname, subscription, err)
+	}
+}
+
+// deleteSubscriptions removes the subscriptions of formatType whose destination starts with prefix
+func (r *RedfishClient) deleteSubscriptions(prefix string, formatType string) {
+	subscriptions, err := r.GetUri(subscriptionsUri)
+	if err != nil {
+		return
+	}
+	size := subscriptions.GetCollectionSize()
+	for i := 0; i < size; i++ {
+		subscription, err := subscriptions.GetPropertyByIndex(i)
+		if err != nil {
+			continue
+		}
+		destination, _ := subscription.Object["Destination"].(string)
+		format, _ := subscription.Object["EventFormatType"].(string)
+		uri, _ := subscription.Object["@odata.id"].(string)
+		if strings.HasPrefix(destination, prefix) && format == formatType && uri != "" {
+			log.Printf("%s: Deleting stale subscription %s", r.Hostname, uri)
+			_ = r.DeleteUri(uri)
+		}
+	}
+}
+
+// SupportsSSE reports whether the service has a ServerSentEventUri
+func (r *RedfishClient) SupportsSSE() (bool, error) {
+	eventService, err := r.GetUri("/redfish/v1/EventService")
+	if err != nil {
+		return false, err
+	}
+	return eventService.Object["ServerSentEventUri"] != nil, nil
+}
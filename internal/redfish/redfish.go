@@ -3,25 +3,24 @@
 package redfish
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dell/iDRAC-Telemetry-Reference-Tools/internal/sse"
 )
 
 const (
-	mrSSEFilter     = "?$filter=EventFormatType%20eq%20MetricReport"
-	mrSSEFilter17G  = "?$filter=EventFormatType%20eq%20%27MetricReport%27"
-	evtSSEFilter    = "?$filter=EventFormatType%20eq%20Event"
-	evtSSEFilter17G = "?$filter=EventType%20eq%20%27Alert%27"
+	sessionsUri = "/redfish/v1/SessionService/Sessions"
 )
 
 type RedfishClient struct {
@@ -32,6 +31,20 @@ type RedfishClient struct {
 	HttpClient  *http.Client
 	IsIPv6      int
 	FwVer       string
+
+	// sessionMu guards sessionToken and sessionUri, which are set while the client uses session authentication
+	sessionMu    sync.Mutex
+	sessionToken string
+	sessionUri   string
+
+	// profile says how the service differs from others, systemUri and managerUri are its system and manager
+	profile    Profile
+	systemUri  string
+	managerUri string
+
+	// eventIDMu guards lastEventIDs, the ID of the last event read from each SSE URI
+	eventIDMu    sync.Mutex
+	lastEventIDs map[string]string
 }
 
 type RedfishEvent struct {
@@ -40,50 +53,173 @@ type RedfishEvent struct {
 	Payload *RedfishPayload
 }
 
-func Init(hostname string, username string, password string) (*RedfishClient, error) {
+func Init(hostname string, username string, password string, opts TLSOptions) (*RedfishClient, error) {
 	ret := new(RedfishClient)
 	ret.Hostname = hostname
 	ret.Username = username
 	ret.Password = password
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	//Allow a max of 5 connections in the http client connection pool
-	//tr.MaxIdleConns = 5
-	//tr.MaxIdleConnsPerHost = 5
-	ret.HttpClient = &http.Client{Transport: tr}
-	_, err := ret.GetUri("/redfish/v1")
+	var err error
+	ret.HttpClient, err = newHttpClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	serviceRoot, err := ret.GetUri("/redfish/v1")
 	if err != nil {
 		log.Print("Failed to init redfish client: ", err)
 		return nil, err
 	}
+	ret.detectProfile(serviceRoot)
 	return ret, nil
 }
 
-func InitBearer(hostname string, token string) (*RedfishClient, error) {
+func InitBearer(hostname string, token string, opts TLSOptions) (*RedfishClient, error) {
 	ret := new(RedfishClient)
 	ret.Hostname = hostname
 	ret.BearerToken = token
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	var err error
+	ret.HttpClient, err = newHttpClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	serviceRoot, err := ret.GetUri("/redfish/v1")
+	if err != nil {
+		return nil, err
+	}
+	ret.detectProfile(serviceRoot)
+	return ret, nil
+}
+
+// InitSession is Init with session authentication: the client logs in once and sends the X-Auth-Token it gets
+// instead of the password, logging in again when the session expires
+func InitSession(hostname string, username string, password string, opts TLSOptions) (*RedfishClient, error) {
+	ret := new(RedfishClient)
+	ret.Hostname = hostname
+	ret.Username = username
+	ret.Password = password
+	var err error
+	ret.HttpClient, err = newHttpClient(opts)
+	if err != nil {
+		return nil, err
 	}
-	ret.HttpClient = &http.Client{Transport: tr}
-	_, err := ret.GetUri("/redfish/v1")
+	err = ret.Login()
 	if err != nil {
+		log.Print("Failed to init redfish client: ", err)
 		return nil, err
 	}
+	serviceRoot, err := ret.GetUri("/redfish/v1")
+	if err != nil {
+		log.Print("Failed to init redfish client: ", err)
+		return nil, err
+	}
+	ret.detectProfile(serviceRoot)
 	return ret, nil
 }
 
 func (r *RedfishClient) addAuthToRequest(req *http.Request) {
-	if r.BearerToken != "" {
-		req.Header.Add("Authorization", "Bearer "+r.BearerToken)
+	token := r.getSessionToken()
+	if token != "" {
+		req.Header.Set("X-Auth-Token", token)
+	} else if r.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.BearerToken)
 	} else {
 		req.SetBasicAuth(r.Username, r.Password)
 	}
 }
 
-func (r *RedfishClient) GetUri(uri string) (*RedfishPayload, error) {
+func (r *RedfishClient) getSessionToken() string {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	return r.sessionToken
+}
+
+// Login creates a session and has the client authenticate with its token from then on
+func (r *RedfishClient) Login() error {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	return r.login()
+}
+
+// login is Login with sessionMu held
+func (r *RedfishClient) login() error {
+	r.checkIPv6()
+	jsonStr, _ := json.Marshal(map[string]string{"UserName": r.Username, "Password": r.Password})
+	req, err := http.NewRequest("POST", "https://"+r.Hostname+sessionsUri, bytes.NewReader(jsonStr))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := r.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	token := resp.Header.Get("X-Auth-Token")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || token == "" {
+		return fmt.Errorf("Login failed for %s with Error code %d", r.Hostname, resp.StatusCode)
+	}
+	r.sessionToken = token
+	r.sessionUri = resp.Header.Get("Location")
+	if i := strings.Index(r.sessionUri, "/redfish/"); i > 0 {
+		r.sessionUri = r.sessionUri[i:]
+	}
+	return nil
+}
+
+// renewSession logs in again unless another request already replaced the stale token
+func (r *RedfishClient) renewSession(stale string) error {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	if r.sessionToken != stale {
+		return nil
+	}
+	log.Printf("%s: Session expired, logging in again", r.Hostname)
+	return r.login()
+}
+
+// Logout deletes the session of a client using session authentication
+func (r *RedfishClient) Logout() error {
+	r.sessionMu.Lock()
+	uri := r.sessionUri
+	r.sessionMu.Unlock()
+	if uri == "" {
+		return nil
+	}
+	err := r.DeleteUri(uri)
+	r.sessionMu.Lock()
+	r.sessionToken = ""
+	r.sessionUri = ""
+	r.sessionMu.Unlock()
+	return err
+}
+
+// do sends req with the client's authentication. If the client uses session authentication and the session has
+// expired, it logs in again and retries once.
+func (r *RedfishClient) do(req *http.Request) (*http.Response, error) {
+	r.addAuthToRequest(req)
+	token := r.getSessionToken()
+	resp, err := r.HttpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" {
+		return resp, err
+	}
+	resp.Body.Close()
+	err = r.renewSession(token)
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.addAuthToRequest(retry)
+	return r.HttpClient.Do(retry)
+}
+
+// checkIPv6 puts brackets around an IPv6 Hostname the first time the client is used
+func (r *RedfishClient) checkIPv6() {
 	if r.IsIPv6 == 0 {
 		split := strings.Split(r.Hostname, ":")
 		if len(split) > 2 {
@@ -93,13 +229,16 @@ func (r *RedfishClient) GetUri(uri string) (*RedfishPayload, error) {
 			r.IsIPv6 = 2
 		}
 	}
+}
+
+func (r *RedfishClient) GetUri(uri string) (*RedfishPayload, error) {
+	r.checkIPv6()
 	req, err := http.NewRequest("GET", "https://"+r.Hostname+uri, nil)
 	if err != nil {
 		return nil, err
 	}
-	r.addAuthToRequest(req)
 	req.Header.Add("Accept", "application/json")
-	resp, err := r.HttpClient.Do(req)
+	resp, err := r.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -121,6 +260,64 @@ func (r *RedfishClient) GetUri(uri string) (*RedfishPayload, error) {
 	return ret, nil
 }
 
+// sendUri sends body, unless it is nil, as JSON in a method request to uri and returns the response if the service
+// took it
+func (r *RedfishClient) sendUri(method string, uri string, body interface{}) (*http.Response, error) {
+	r.checkIPv6()
+	var reader io.Reader
+	if body != nil {
+		jsonStr, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(jsonStr)
+	}
+	req, err := http.NewRequest(method, "https://"+r.Hostname+uri, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s failed for %s with Error code %d: %s",
+			method, r.Hostname+uri, resp.StatusCode, respBody)
+	}
+	return resp, nil
+}
+
+// PatchUri sends body as JSON in a PATCH to uri
+func (r *RedfishClient) PatchUri(uri string, body interface{}) error {
+	_, err := r.sendUri("PATCH", uri, body)
+	return err
+}
+
+// PostUri sends body as JSON in a POST to uri and returns the URI of the resource it created
+func (r *RedfishClient) PostUri(uri string, body interface{}) (string, error) {
+	resp, err := r.sendUri("POST", uri, body)
+	if err != nil {
+		return "", err
+	}
+	location := resp.Header.Get("Location")
+	// the Location header may be absolute
+	if i := strings.Index(location, "/redfish/"); i > 0 {
+		location = location[i:]
+	}
+	return location, nil
+}
+
+func (r *RedfishClient) DeleteUri(uri string) error {
+	_, err := r.sendUri("DELETE", uri, nil)
+	return err
+}
+
 func (r *RedfishClient) Walk() map[string]*RedfishPayload {
 	res := make(map[string]*RedfishPayload)
 	r.walkUri("/redfish/v1", &res)
@@ -136,144 +333,46 @@ func (r *RedfishClient) walkUri(uri string, res *map[string]*RedfishPayload) {
 	payload.walk(res)
 }
 
+// GetSysInfo identifies the device as its profile says
 func (r *RedfishClient) GetSysInfo() (hostname, sku, model, fwver, fqdn, imgid string, err error) {
-	serviceRoot, err := r.GetUri("/redfish/v1/Systems/System.Embedded.1?$select=HostName,SKU,Model")
-	if err != nil {
-		return
-	}
-	//iDRAC
-	if serviceRoot.Object["HostName"] != nil {
-		hostname = serviceRoot.Object["HostName"].(string)
-	}
-	if serviceRoot.Object["SKU"] != nil {
-		sku = serviceRoot.Object["SKU"].(string)
-	}
-	if serviceRoot.Object["Model"] != nil {
-		model = serviceRoot.Object["Model"].(string)
-	}
-
-	serviceRoot, err = r.GetUri("/redfish/v1/Managers/iDRAC.Embedded.1/EthernetInterfaces/NIC.1?$select=FQDN")
-	if err != nil {
-		return
-	}
-	//iDRAC
-	if serviceRoot.Object["FQDN"] != nil {
-		fqdn = serviceRoot.Object["FQDN"].(string)
-	}
-
-	serviceRoot, err = r.GetUri("/redfish/v1/Managers/iDRAC.Embedded.1?$select=FirmwareVersion,Links")
-	if err != nil {
-		return
-	}
-	//iDRAC
-	if serviceRoot.Object["FirmwareVersion"] != nil {
-		fwver = serviceRoot.Object["FirmwareVersion"].(string)
-	}
-
-	if serviceRoot.Object["Links"] != nil {
-		act, ok := serviceRoot.Object["Links"].(map[string]interface{})["ActiveSoftwareImage"]
-		if ok {
-			imgid = act.(map[string]interface{})["@odata.id"].(string)
-			if imgid != "" {
-				imgid = imgid[strings.LastIndex(imgid, "/")+1:]
-			}
-		}
-	}
-	return
+	info, err := r.Profile().SysInfo(r)
+	return info.HostName, info.SKU, info.Model, info.FwVer, info.FQDN, info.ImgID, err
 }
 
+// GetSystemId is the ID the device's values are reported under, as its profile says
 func (r *RedfishClient) GetSystemId() (string, error) {
-	serviceRoot, err := r.GetUri("/redfish/v1")
-	if err != nil {
-		return "", err
-	}
-	//iDRAC
-	if serviceRoot.Object["Oem"] != nil {
-		//log.Printf("%s: Has Oem elem!", r.Hostname)
-		oem := valueToPayload(r, serviceRoot.Object["Oem"])
-		if oem.Object["Dell"] != nil {
-			dell := valueToPayload(r, oem.Object["Dell"])
-			//log.Printf("%s: Has Oem/Dell elem! %v", r.Hostname, dell)
-			if dell.Object["ServiceTag"] != nil {
-				return dell.Object["ServiceTag"].(string), nil
-			}
-		}
-	}
-	//log.Printf("%s: No Oem/Dell/ServiceTag elem!", r.Hostname)
-	chassisCollection, err := serviceRoot.GetPropertyByName("Chassis")
-	if err != nil {
-		return "", err
-	}
-	size := chassisCollection.GetCollectionSize()
-	for i := 0; i < size; i++ {
-		chassis, err := chassisCollection.GetPropertyByIndex(i)
-		if err != nil {
-			continue
-		}
-		if chassis.Object["ChassisType"].(string) == "Enclosure" {
-			if chassis.Object["Name"].(string) == "Blade Chassis" {
-				//EC case...
-				if chassis.Object["SKU"].(string) != "" {
-					return chassis.Object["SKU"].(string), nil
-				}
-				oemChassis, err := r.GetUri(chassis.Object["@odata.id"].(string) + "/Attributes")
-				if err != nil {
-					return "", err
-				}
-				attributes := valueToPayload(r, oemChassis.Object["Attributes"])
-				return attributes.Object["NIC.1.MACAddress"].(string), nil
-			}
-			return chassis.Object["Name"].(string), nil
-		}
-	}
-	return "", errors.New("Unable to determine System ID")
+	return r.Profile().SystemID(r)
 }
 
+// ListenForAlerts passes the service's alerts to event until Ctx is done
 func (r *RedfishClient) ListenForAlerts(Ctx context.Context, event chan<- *RedfishEvent) {
-	ret := new(RedfishEvent)
-	serviceRoot, err := r.GetUri("/redfish/v1")
-	if err == nil {
-		eventService, err := serviceRoot.GetPropertyByName("EventService")
-		if err == nil {
-			if eventService.Object["ServerSentEventUri"] != nil {
-				sseUri := "https://" + r.Hostname + eventService.Object["ServerSentEventUri"].(string)
-				filter := evtSSEFilter
-				if strings.Compare(r.FwVer, "4.00.00.00") < 0 {
-					filter = evtSSEFilter17G
-				}
-				ret.Err = r.StartSSE(Ctx, event, sseUri+filter)
-
-			} else {
-				log.Println("Don't support POST back yet!")
-				ret.Err = errors.New("Don't support POST back yet!")
-			}
-		} else {
-			ret.Err = err
-		}
-	} else {
-		log.Println("Unable to get service root!", err)
-		ret.Err = err
-	}
-	if ret.Err != nil {
-		event <- ret
-	}
+	r.listenSSE(Ctx, event, EventFormat)
 }
+
+// ListenForMetricReports passes the service's metric reports to event until Ctx is done
 func (r *RedfishClient) ListenForMetricReports(Ctx context.Context, event chan<- *RedfishEvent) {
+	r.listenSSE(Ctx, event, MetricReportFormat)
+}
+
+// ListenForLceEvents passes the service's Lifecycle Controller events to event until Ctx is done
+func (r *RedfishClient) ListenForLceEvents(Ctx context.Context, event chan<- *RedfishEvent) {
+	r.listenSSE(Ctx, event, LifecycleFormat)
+}
+
+// listenSSE passes the events the profile's filter for formatType selects on the ServerSentEventUri to event until
+// Ctx is done. When the stream cannot be opened or fails it sends an event carrying the error, ErrNoSSE if the
+// service has no ServerSentEventUri.
+func (r *RedfishClient) listenSSE(Ctx context.Context, event chan<- *RedfishEvent, formatType string) {
 	ret := new(RedfishEvent)
 	serviceRoot, err := r.GetUri("/redfish/v1")
 	if err == nil {
 		eventService, err := serviceRoot.GetPropertyByName("EventService")
 		if err == nil {
-			if eventService.Object["ServerSentEventUri"] != nil {
-				sseUri := "https://" + r.Hostname + eventService.Object["ServerSentEventUri"].(string)
-				filter := mrSSEFilter
-				if strings.Compare(r.FwVer, "4.00.00.00") < 0 {
-					filter = mrSSEFilter17G
-				}
-				ret.Err = r.StartSSE(Ctx, event, sseUri+filter)
+			if uri, ok := eventService.Object["ServerSentEventUri"].(string); ok && uri != "" {
+				sseUri := "https://" + r.Hostname + uri
+				ret.Err = r.StartSSE(Ctx, event, sseUri+r.Profile().SSEFilter(formatType))
 			} else {
-				log.Println("Don't support POST back yet!")
-				ret.Err = errors.New("Don't support POST back yet!")
+				ret.Err = ErrNoSSE
 			}
 		} else {
 			ret.Err = err
@@ -283,55 +382,41 @@ func (r *RedfishClient) ListenForMetricReports(Ctx context.Context, event chan<-
 		ret.Err = err
 	}
 	if ret.Err != nil {
-		event <- ret
+		select {
+		case event <- ret:
+		case <-Ctx.Done():
+		}
 	}
 }
 
-func (r *RedfishClient) ListenForLceEvents(Ctx context.Context, event chan<- *RedfishEvent) {
-	ret := new(RedfishEvent)
-	serviceRoot, err := r.GetUri("/redfish/v1")
-	if err == nil {
-		eventService, err := serviceRoot.GetPropertyByName("EventService")
-		sseUri := "https://" + r.Hostname + eventService.Object["ServerSentEventUri"].(string)
-		if err == nil {
-			if eventService.Object["ServerSentEventUri"] != nil {
-				ret.Err = r.GetLceSSE(Ctx, event, sseUri)
+func (r *RedfishClient) lastEventID(sseURI string) string {
+	r.eventIDMu.Lock()
+	defer r.eventIDMu.Unlock()
+	return r.lastEventIDs[sseURI]
+}
 
-			} else {
-				log.Println("Don't support POST back yet!")
-				ret.Err = errors.New("Don't support POST back yet!")
-			}
-		} else {
-			ret.Err = err
-		}
-	} else {
-		log.Println("Unable to get service root!", err)
-		ret.Err = err
-	}
-	if ret.Err != nil {
-		event <- ret
+func (r *RedfishClient) setLastEventID(sseURI string, id string) {
+	r.eventIDMu.Lock()
+	defer r.eventIDMu.Unlock()
+	if r.lastEventIDs == nil {
+		r.lastEventIDs = make(map[string]string)
 	}
+	r.lastEventIDs[sseURI] = id
 }
 
+// StartSSE passes the events of sseURI to event until Ctx is done. It resumes after the last event an earlier call
+// read from the same URI, which services that keep their events replay. The connection is only retried once, the
+// caller reconnects with its own backoff when StartSSE sends an error.
 func (r *RedfishClient) StartSSE(Ctx context.Context, event chan<- *RedfishEvent, sseURI string) error {
 	sseConfig := new(sse.Config)
 	sseConfig.Client = r.HttpClient
-	//iDRAC version
-	filter := mrSSEFilter
-	serviceRoot, err := r.GetUri("/redfish/v1/Managers/iDRAC.Embedded.1?$select=FirmwareVersion")
-	if err == nil {
-		if serviceRoot.Object["FirmwareVersion"] != nil {
-			fwver, ok := serviceRoot.Object["FirmwareVersion"].(string)
-			if ok && strings.Compare(fwver, "4.00.00.00") < 0 {
-				filter = mrSSEFilter17G
-			}
-		}
-	}
-	log.Println("SSE Metric Report Filter: ", filter)
+	sseConfig.RetryParams = sse.RetryParams{RetryInterval: 5 * time.Second, MaxRetries: 1}
+	sseConfig.LastEventID = r.lastEventID(sseURI)
+	log.Printf("%s: Connecting to %s with the %s profile", r.Hostname, sseURI, r.Profile().Name())
 
 	lastTS := time.Now() // Variable to hold the latest event timestamp
 	sseConfig.RequestCreator = func() *http.Request {
-		req, err := http.NewRequest("GET", sseURI, nil)
+		req, err := http.NewRequestWithContext(Ctx, "GET", sseURI, nil)
 		if err != nil {
 			return nil
 		}
@@ -341,11 +426,32 @@ func (r *RedfishClient) StartSSE(Ctx context.Context, event chan<- *RedfishEvent
 		return req
 	}
 	sseSource, err := sseConfig.Connect()
+	var badResponse sse.BadResponseError
+	if errors.As(err, &badResponse) && sseConfig.LastEventID != "" && badResponse.Response.StatusCode < 500 {
+		// the service does not resume from Last-Event-ID, start over without it
+		log.Printf("%s: Failed to resume SSE after event %s: %v", r.Hostname, sseConfig.LastEventID, err)
+		r.setLastEventID(sseURI, "")
+		sseConfig.LastEventID = ""
+		sseSource, err = sseConfig.Connect()
+	}
 	if err != nil {
 		log.Println("Error connecting! ", err)
 		err = errors.New("connection error")
 		return err
 	}
+	if sseConfig.LastEventID != "" {
+		log.Printf("%s: Resuming SSE after event %s", r.Hostname, sseConfig.LastEventID)
+	}
+	// closing the source unblocks Next when Ctx is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-Ctx.Done():
+			sseSource.Close()
+		case <-stop:
+		}
+	}()
 	for {
 		select {
 		case <-Ctx.Done():
@@ -356,6 +462,10 @@ func (r *RedfishClient) StartSSE(Ctx context.Context, event chan<- *RedfishEvent
 			redfishEvent := new(RedfishEvent)
 			redfishEvent.ID = sseEvent.ID
 			if err != nil {
+				if Ctx.Err() != nil {
+					// cancelled, nobody waits for the error
+					return nil
+				}
 				redfishEvent.Err = err
 				if strings.Contains(err.Error(), "EOF") {
 					// EOF denotes a terminated SSE connection.
@@ -377,6 +487,9 @@ func (r *RedfishClient) StartSSE(Ctx context.Context, event chan<- *RedfishEvent
 			}
 
 			lastTS = time.Now() // Update the latest event timestamp
+			if sseEvent.ID != "" {
+				r.setLastEventID(sseURI, sseEvent.ID)
+			}
 			ret := new(RedfishPayload)
 			err = json.Unmarshal(sseEvent.Data, &ret.Object)
 			if err != nil {
@@ -395,11 +508,7 @@ func (r *RedfishClient) GetEventsSSE(Ctx context.Context, event chan<- *RedfishE
 	sseConfig := new(sse.Config)
 	sseConfig.Client = r.HttpClient
 	sseConfig.RequestCreator = func() *http.Request {
-		filter := evtSSEFilter
-		if strings.Compare(r.FwVer, "4.00.00.00") < 0 {
-			filter = evtSSEFilter17G
-		}
-		req, err := http.NewRequest("GET", sseURI+filter, nil)
+		req, err := http.NewRequest("GET", sseURI+r.Profile().SSEFilter(EventFormat), nil)
 		if err != nil {
 			return nil
 		}
@@ -437,52 +546,6 @@ func (r *RedfishClient) GetEventsSSE(Ctx context.Context, event chan<- *RedfishE
 	}
 }
 
-func (r *RedfishClient) GetLceSSE(Ctx context.Context, event chan<- *RedfishEvent, sseURI string) error {
-	sseConfig := new(sse.Config)
-	sseConfig.Client = r.HttpClient
-	sseConfig.RequestCreator = func() *http.Request {
-		req, err := http.NewRequest("GET", sseURI+"?$filter=EventType%20eq%20%27Other%27", nil)
-		if err != nil {
-			return nil
-		}
-		r.addAuthToRequest(req)
-		req.Header.Add("Accept", "*/*")
-		return req
-	}
-	sseConfig.RetryParams.MaxRetries = 5
-	sseSource, err := sseConfig.Connect()
-	if err != nil {
-		return err
-	}
-	// waiting for context to be cancelled by listening to Ctx.Done() channel
-	// if someone cancelled it, it will close the connection
-	for {
-		select {
-		case <-Ctx.Done():
-			sseSource.Close()
-			return nil
-		default:
-			sseEvent, err := sseSource.Next()
-			if err != nil {
-				fmt.Println("SSESource.Next() Errored, breaking from loop")
-				fmt.Println(err)
-				break
-			}
-			redfishEvent := new(RedfishEvent)
-			redfishEvent.ID = sseEvent.ID
-			ret := new(RedfishPayload)
-			err = json.Unmarshal(sseEvent.Data, &ret.Object)
-			if err != nil {
-				log.Printf("Failed to parse message %v", err)
-				continue
-			}
-			ret.Client = r
-			redfishEvent.Payload = ret
-			event <- redfishEvent
-		}
-	}
-}
-
 func (r *RedfishClient) GetSSEByUri(event chan<- *RedfishEvent, sseURI string) {
 	errEvent := new(RedfishEvent)
 	sseConfig := new(sse.Config)
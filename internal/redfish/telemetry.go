@@ -0,0 +1,86 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package redfish
+
+import (
+	"fmt"
+)
+
+const (
+	TelemetryServiceUri        = "/redfish/v1/TelemetryService"
+	MetricReportDefinitionsUri = TelemetryServiceUri + "/MetricReportDefinitions"
+	MetricReportsUri           = TelemetryServiceUri + "/MetricReports"
+	// iDRACs before TelemetryService 1.2 only take the service state through this attribute
+	idracAttributesUri  = "/redfish/v1/Managers/iDRAC.Embedded.1/Attributes"
+	idracTelemetryState = "Telemetry.1.EnableTelemetry"
+)
+
+// GetTelemetryServiceEnabled reports whether the TelemetryService is enabled
+func (r *RedfishClient) GetTelemetryServiceEnabled() (bool, error) {
+	telemetryService, err := r.GetUri(TelemetryServiceUri)
+	if err != nil {
+		return false, err
+	}
+	if enabled, ok := telemetryService.Object["ServiceEnabled"].(bool); ok {
+		return enabled, nil
+	}
+	if status, ok := telemetryService.Object["Status"].(map[string]interface{}); ok {
+		return status["State"] == "Enabled", nil
+	}
+	return false, fmt.Errorf("%s reports neither ServiceEnabled nor Status", TelemetryServiceUri)
+}
+
+// SetTelemetryServiceEnabled turns the TelemetryService on or off, through the iDRAC attribute if the service does
+// not take ServiceEnabled
+func (r *RedfishClient) SetTelemetryServiceEnabled(enabled bool) error {
+	err := r.PatchUri(TelemetryServiceUri, map[string]interface{}{"ServiceEnabled": enabled})
+	if err == nil {
+		return nil
+	}
+	state := "Disabled"
+	if enabled {
+		state = "Enabled"
+	}
+	attrErr := r.PatchUri(idracAttributesUri, map[string]interface{}{
+		"Attributes": map[string]interface{}{idracTelemetryState: state},
+	})
+	if attrErr != nil {
+		return fmt.Errorf("%v, and through %s: %v", err, idracTelemetryState, attrErr)
+	}
+	return nil
+}
+
+// GetMetricReportDefinitions returns every MetricReportDefinition of the TelemetryService
+func (r *RedfishClient) GetMetricReportDefinitions() ([]*RedfishPayload, error) {
+	collection, err := r.GetUri(MetricReportDefinitionsUri + "?$expand=*($levels=1)")
+	if err != nil {
+		return nil, err
+	}
+	size := collection.GetCollectionSize()
+	ret := make([]*RedfishPayload, 0, size)
+	for i := 0; i < size; i++ {
+		// members that were not expanded are fetched one by one
+		def, err := collection.GetPropertyByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, def)
+	}
+	return ret, nil
+}
+
+// SetMetricReportDefinition enables or disables report id and changes how often it is sent. A nil enabled or an empty
+// recurrenceInterval (an ISO 8601 duration such as "PT60S") leaves that setting as it is.
+func (r *RedfishClient) SetMetricReportDefinition(id string, enabled *bool, recurrenceInterval string) error {
+	body := make(map[string]interface{})
+	if enabled != nil {
+		body["MetricReportDefinitionEnabled"] = *enabled
+	}
+	if recurrenceInterval != "" {
+		body["Schedule"] = map[string]interface{}{"RecurrenceInterval": recurrenceInterval}
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	return r.PatchUri(MetricReportDefinitionsUri+"/"+id, body)
+}
@@ -0,0 +1,96 @@
+// Licensed to You under the Apache License, Version 2.0.
+
+package redfish
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSOptions says how a client checks the service's certificate. The zero value verifies it against the system's
+// CAs.
+type TLSOptions struct {
+	// CACert is a PEM file with the CAs to verify the certificate against instead of the system's
+	CACert string
+	// Fingerprint is the SHA-256 of the certificate in hex, with or without colons. Only the certificate is compared
+	// unless CACert is set as well.
+	Fingerprint string
+	// Insecure accepts any certificate
+	Insecure bool
+}
+
+// FingerprintError is returned when the service's certificate is not the pinned one
+type FingerprintError struct {
+	Got  string
+	Want string
+}
+
+func (e *FingerprintError) Error() string {
+	return fmt.Sprintf("certificate fingerprint mismatch: got %s, want %s", e.Got, e.Want)
+}
+
+// IsCertificateError reports whether err comes from the service's certificate failing verification
+func IsCertificateError(err error) bool {
+	var fingerprintErr *FingerprintError
+	var verificationErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &fingerprintErr) || errors.As(err, &verificationErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+func (o TLSOptions) config() (*tls.Config, error) {
+	if o.Insecure {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	ret := new(tls.Config)
+	if o.CACert != "" {
+		pem, err := os.ReadFile(o.CACert)
+		if err != nil {
+			return nil, err
+		}
+		ret.RootCAs = x509.NewCertPool()
+		if !ret.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CACert)
+		}
+	}
+	if o.Fingerprint != "" {
+		want := strings.ToLower(strings.ReplaceAll(o.Fingerprint, ":", ""))
+		// a pinned certificate needs no CA, its fingerprint is checked instead
+		ret.InsecureSkipVerify = o.CACert == ""
+		ret.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate presented")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			got := hex.EncodeToString(sum[:])
+			if got != want {
+				return &FingerprintError{Got: got, Want: want}
+			}
+			return nil
+		}
+	}
+	return ret, nil
+}
+
+func newHttpClient(opts TLSOptions) (*http.Client, error) {
+	tlsConfig, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	tr := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	//Allow a max of 5 connections in the http client connection pool
+	//tr.MaxIdleConns = 5
+	//tr.MaxIdleConnsPerHost = 5
+	return &http.Client{Transport: tr}, nil
+}
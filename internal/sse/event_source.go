@@ -62,6 +62,8 @@ type Config struct {
 	Client         *http.Client
 	RetryParams    RetryParams
 	RequestCreator func() *http.Request
+	// LastEventID is sent as the Last-Event-ID header when connecting, for the server to resume after that event
+	LastEventID string
 }
 
 func (c *Config) Connect() (*EventSource, error) {
@@ -70,6 +72,7 @@ func (c *Config) Connect() (*EventSource, error) {
 		client = http.DefaultClient
 	}
 	source := createEventSource(client, c.RetryParams, c.RequestCreator)
+	source.lastEventID = c.LastEventID
 
 	readCloser, err := source.establishConnection()
 	if err != nil {
@@ -149,6 +152,11 @@ func (source *EventSource) Next() (Event, error) {
 	}
 }
 
+// LastEventID is the ID of the last event read, which a reconnect resumes after
+func (source *EventSource) LastEventID() string {
+	return source.lastEventID
+}
+
 func (source *EventSource) Close() error {
 	source.lock.Lock()
 	defer source.lock.Unlock()
@@ -205,7 +213,9 @@ func (source *EventSource) establishConnection() (*ReadCloser, error) {
 	for {
 		req := source.createRequest()
 
-		req.Header.Set("Last-Event-ID", source.lastEventID)
+		if source.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", source.lastEventID)
+		}
 
 		res, err := source.client.Do(req)
 		if err != nil {
@@ -235,6 +245,12 @@ func (source *EventSource) establishConnection() (*ReadCloser, error) {
 			http.StatusGatewayTimeout:
 			res.Body.Close()
 
+			connectionRetries++
+			if !source.shouldRetry(connectionRetries) {
+				return nil, BadResponseError{
+					Response: res,
+				}
+			}
 			err := source.waitForRetry()
 			if err != nil {
 				return nil, err